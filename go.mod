@@ -1,3 +1,19 @@
 module github.com/rcastellotti/mok
 
 go 1.25.3
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/bufbuild/protocompile v0.14.1
+	github.com/itchyny/gojq v0.12.19
+	github.com/vektah/gqlparser/v2 v2.5.58
+	google.golang.org/protobuf v1.36.12
+)
+
+require (
+	github.com/agnivade/levenshtein v1.2.1 // indirect
+	github.com/itchyny/timefmt-go v0.1.8 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+)
@@ -7,275 +7,1594 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"html/template"
-	"log"
-	"strconv"
-	"strings"
-
 	"io"
+	"maps"
+	"net"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/rcastellotti/mok/mok"
+	"gopkg.in/yaml.v3"
 )
 
-const indexTemplate = `
-<!doctype html>
-<html lang="en">
-    <head>
-        <meta charset="UTF-8" />
-        <meta
-            name="viewport"
-            content="width=device-width, user-scalable=no, initial-scale=1.0, maximum-scale=1.0, minimum-scale=1.0"
-        />
-        <title>mok</title>
-    </head>
-    <body>
-        <h1>mok</h1>
-        available endpoints:
-        <ul>
-            {{range .}}
-            <li><a href="{{.URLPath}}">{{.FilePath}}</a></li>
-            {{end}}
-        </ul>
-    </body>
-</html>
-`
+// defaultHost only accepts connections from the machine mok runs on, pass
+// -host 0.0.0.0 to reach it from elsewhere.
+const defaultHost = "127.0.0.1"
 
 var usage = `
-  usage: mok [options] <files.json>
+  usage: mok [serve] [options] [files.json]
+         mok record -target <url> [options]
+         mok replay [options] [dir]
+         mok validate [options] [files.json]
+         mok routes [options] [files.json]
+         mok init [dir]
+         mok completion bash|zsh|fish
+         mok convert -from <format> <file> | -to <format> [files.json]
+         mok diff -target <url> [options] [files.json]
+         mok help [command]
+
+  mok files.json is short for mok serve files.json.
 
   files can be local or remote (api endpoints):
-    remote: URI must start with http:// or https://
-    local: passing directories is not supported, use glob instead.
+    remote: URI must start with http:// or https://, or be an object of
+            a bucket, s3://bucket/key.json or gs://bucket/key.json
+    git: git+<repository>#<path>@<ref>, the path of a shallow clone
+    local: files or directories, directories are walked recursively and
+           every .json file is served at its path relative to the directory.
+    archives: .zip, .tar.gz and .tgz files, local or remote, are served
+           like directories without extracting them.
+    @file: the sources listed in file, one per line, # starts a comment.
+
+  routes can also be described in a yaml config, mok.yaml in the working
+  directory is loaded automatically, see -c.
 
-  additionally mok reads json from stdin, try it with 'echo '{"k": "v"}' | mok'
+  additionally mok reads json from stdin, try it with 'echo '{"k": "v"}' | mok'.
+  a pipe is only read when nothing else is served, or when - is among the files.
 
   options:
-    -p <port>           specify the port to listen on
-    -s <json string>    specify the json string to serve (on /)
+    -auth <user:pass>   require http basic auth on every request
+    -c <config.yaml>    specify the route config file (default mok.yaml)
+    -cache-control <v>  set Cache-Control on every response, e.g. "public, max-age=60"
+    -expires <duration> set Expires to now plus duration on every response, negative for stale
+    -cert <cert.pem>    serve https using this certificate, requires -key
+    -key <key.pem>      private key for -cert
+    -tls-auto           serve https using a generated self-signed certificate
+    -mtls-ca <ca.pem>   require client certificates signed by these CAs, with -cert or -tls-auto
+    -mtls-mode <mode>   what clients without a valid certificate get: require (the handshake fails),
+                        optional (only invalid ones fail) or status (401 when missing, 403 when invalid)
+    -compress <mode>    compress json and text responses: auto (per Accept-Encoding), always or never
+    -content-type <t>   serve every route with this Content-Type, e.g. "application/json; charset=utf-8"
+    -contract <spec>    warn about routes and fixtures drifting from this OpenAPI document
+    -contract-strict    refuse to start when they drift from -contract
+    -cors               allow cross origin requests from any origin, without credentials, and
+                        answer preflights
+    -cors-origin <origin>
+                        allow credentialed cross origin requests from origin only, e.g.
+                        http://localhost:3000, repeatable, implies -cors
+    -crud <db.json>     serve a read/write REST API from the top-level arrays of db.json
+    -delay <duration>   delay every response, fixed (100ms) or jittered (100ms±50ms)
+    -f                  keep reading json documents (e.g. json lines) from stdin, each replaces the
+                        served one, try 'some-generator | mok -f'
+    -fallback <url>     proxy requests not matching any route to this URL
+    -graphql <schema>   serve /graphql from a graphql schema, with introspection
+    -graphql-data <file>
+                        answer graphql queries with this json, keyed by root field, the rest is made up
+    -grpc <schema>      answer the grpc methods of a .proto file or descriptor set with json fixtures
+    -grpc-fixtures <dir>
+                        where the grpc fixtures are, dir/<package.Service>/<Method>.json
+                        (default the directory of the schema)
+    -grpc-port <port>   the port grpc listens on (default 9173)
+    -h2c                serve HTTP/2 without tls to clients with prior knowledge, next to http/1.1
+    -H <header>         add a "Name: value" header to every response, repeatable
+    -host <addr>        the address to listen on, 0.0.0.0 or :: for every interface (default 127.0.0.1)
+    -bind <addr>        same as -host
+    -index-template <file>
+                        render this html/template at / instead of the dashboard, with the
+                        method, path, status and hits of every route, see the readme
+    -input-path <path>  serve stdin or -s on path only, by default they answer every path no route does
+    -jsonrpc <path=dir> answer the JSON-RPC calls POSTed to path with dir/<method>.json, repeatable
+    -log-format <f>     log every request as json lines or in the combined log format of apache
+                        and nginx, with the duration in microseconds appended (default combined)
+    -log-file <file>    append the request log to file instead of stdout
+    -max-requests <n>   shut down once n requests were answered, the admin api aside
+    -minify             strip the whitespace of json responses, whatever the fixture looks like,
+                        ?_pretty=1 still indents a single response
+    -namespace          mount files sharing a route under their directory, a/users.json at /a/users.json
+    -no-index           serve neither the route listing at / nor the dashboard at /__mok__/
+    -netrc              authenticate the downloads of remote files with $NETRC or ~/.netrc
+    -network <name>     emulate a network: offline, 2g, slow-3g, 3g, 4g, wifi or flaky-wifi,
+                        -delay and -throttle take precedence
+    -once               shut down once the first request was answered, same as -max-requests 1
+    -oidc               serve a fake OpenID Connect provider minting JWTs at /oauth/token
+    -oidc-claims <file> default claims of the minted tokens, a json object
+    -oauth-clients <file>
+                        the clients allowed in the authorization code flow, yaml or json
+    -otlp <url>         export a span per request to this OTLP/HTTP traces endpoint, e.g.
+                        http://localhost:4318, the W3C traceparent of the request is the parent
+                        (default $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or $OTEL_EXPORTER_OTLP_ENDPOINT)
+    -p <port>           specify the port to listen on, 0 picks a free one and prints it as json
+    -announce <file>    write where mok listens to file as a json line, once it accepts connections
+    -prefix <path>      mount every route under path without renaming files, e.g. /api/v2
+    -pretty             serve fixtures without their extension, users.json at /users
+    -keep-ext           with -pretty, keep serving /users.json too
+    -pretty-json        indent json responses, whatever the fixture looks like, ?_pretty=0
+                        minifies a single response (-pretty is for urls)
+    -profile <name>     serve a profile of the config, e.g. error-day, switchable at /__mok__/profile
+    -rate-limit <rate>  limit every route to a number of requests per period, e.g. 10/s or 100/m
+    -record-har <file>  write every request and response of the session to file as a HAR on
+                        shutdown, also served at /__mok__/har
+    -refresh <duration> download remote files again every interval, e.g. 5m, with ETag revalidation
+    -remote-accept-any  download remote files whatever their Content-Type (e.g. text/plain), the body
+                        must still be json
+    -remote-cache <dir> keep remote downloads in dir across runs instead of temp files, revalidated
+                        on start and served as they are when the download fails
+    -remote-header <h>  send a "Name: value" header when downloading remote files, repeatable,
+                        e.g. 'Authorization: Bearer ...'
+    -remote-timeout <d> bound every download of a remote file (default 30s), failed ones are
+                        tried 3 times with backoff
+    -root <dir>         read local files and the config from dir only, paths are relative to it
+    -static <dir>       serve the files of dir as they are (images, html, csv...) when no route matches
+    -strict             answer requests no route answers with 501 rather than 404 or 405, and exit
+                        with status 1 on shutdown listing them
+    -s <json string>    specify the json string to serve (on /), or bind it to a path with /path=json,
+                        repeatable, e.g. -s /health='{"ok":true}' -s /user='{"id":1}'
+    -timeout <duration> shut down once mok served for duration, e.g. 30s, whatever it is doing
+    -templates          render every fixture as a go template, not only the routes with template: true
+    -throttle <rate>    cap how fast responses are written, e.g. 50kbps, 2mbps or 64KB/s
+    -unix <socket>      listen on a unix domain socket instead of tcp, -host and -p are ignored
+    -utils              serve httpbin's /status/{code}, /delay/{seconds}, /headers, /ip and /anything,
+                        and /echo, /bytes/{n} and /json/{n}
     -v                  verbose output
+    -vhost <host=dir>   serve a file or directory only to requests for host, e.g. api.local=fixtures/api,
+                        repeatable
+    -webhook <path>     record the webhooks POSTed to path, listed at /__mok__/webhooks
+    -webhook-secret <secret>
+                        refuse webhooks without a valid GitHub or Stripe HMAC signature
+    -wiremock <dir>     load WireMock stubs from dir/mappings, bodies from dir/__files
+    -w, -watch          watch served files and reload them on change
+    -ws <path=script>   upgrade path to a websocket playing the script (yaml), /path=echo echoes,
+                        repeatable
 
 `
 
 var (
-	portPtr    = flag.Int("p", 9172, "specify the port to listen on")
-	jsonStrPtr = flag.String("s", "", "specify the json string to serve")
-	verbosePtr = flag.Bool("v", false, "verbose output")
+	authPtr     = flag.String("auth", "", "require http basic auth on every request, as user:pass")
+	configPtr   = flag.String("c", "", "specify the route config file")
+	portPtr     = flag.Int("p", 9172, "specify the port to listen on")
+	rootPtr     = flag.String("root", "", "read local files and the config from dir only")
+	verbosePtr  = flag.Bool("v", false, "verbose output")
+	corsPtr     = flag.Bool("cors", false, "allow cross origin requests and answer preflights")
+	crudPtr     = flag.String("crud", "", "serve a read/write REST API from the top-level arrays of the file")
+	certPtr     = flag.String("cert", "", "serve https using this certificate, requires -key")
+	keyPtr      = flag.String("key", "", "private key for -cert")
+	inPathPtr   = flag.String("input-path", "", "serve stdin or -s on this path only, instead of every path no route answers")
+	mtlsCAPtr   = flag.String("mtls-ca", "", "require client certificates signed by these CAs")
+	strictPtr   = flag.Bool("strict", false, "answer requests no route answers with 501 and exit 1 listing them")
+	h2cPtr      = flag.Bool("h2c", false, "serve HTTP/2 without tls to clients with prior knowledge")
+	tlsAutoPtr  = flag.Bool("tls-auto", false, "serve https using a generated self-signed certificate")
+	followPtr   = flag.Bool("f", false, "keep reading json documents from stdin, each replaces the served one")
+	fallbackPtr = flag.String("fallback", "", "proxy requests not matching any route to this URL")
+	wiremockPtr = flag.String("wiremock", "", "load WireMock stubs from dir/mappings, bodies from dir/__files")
+	oidcPtr     = flag.Bool("oidc", false, "serve a fake OpenID Connect provider")
+	claimsPtr   = flag.String("oidc-claims", "", "default claims of the minted tokens, a json object")
+	clientsPtr  = flag.String("oauth-clients", "", "the clients allowed in the authorization code flow")
+	webhookPtr  = flag.String("webhook", "", "record the webhooks POSTed to path")
+	secretPtr   = flag.String("webhook-secret", "", "refuse webhooks without a valid GitHub or Stripe HMAC signature")
+	utilsPtr    = flag.Bool("utils", false, "serve httpbin's /status/{code}, /delay/{seconds}, /headers, /ip and /anything, and /echo, /bytes/{n} and /json/{n}")
+	cachePtr    = flag.String("cache-control", "", "set Cache-Control on every response")
+	expiresPtr  = flag.Duration("expires", 0, "set Expires to now plus duration on every response")
+	prefixPtr   = flag.String("prefix", "", "mount every route under this path, e.g. /api/v2")
+	nsPtr       = flag.Bool("namespace", false, "mount files colliding on a route under their directory name")
+	prettyPtr   = flag.Bool("pretty", false, "serve users.json at /users")
+	keepExtPtr  = flag.Bool("keep-ext", false, "with -pretty, keep serving /users.json too")
+	profilePtr  = flag.String("profile", "", "serve this profile of the config")
+	refreshPtr  = flag.Duration("refresh", 0, "download remote files again every interval, e.g. 5m")
+	rcachePtr   = flag.String("remote-cache", "", "keep remote downloads in dir, revalidated on start")
+	anyTypePtr  = flag.Bool("remote-accept-any", false, "download remote files whatever their Content-Type, if the body is json")
+	timeoutPtr  = flag.Duration("timeout", 0, "shut down once mok served for duration, e.g. 30s")
+	oncePtr     = flag.Bool("once", false, "shut down once the first request was answered")
+	maxReqPtr   = flag.Int("max-requests", 0, "shut down once n requests were answered")
+	rtimeoutPtr = flag.Duration("remote-timeout", mok.DefaultRemoteTimeout, "bound every download of a remote file")
+	netrcPtr    = flag.Bool("netrc", false, "authenticate remote downloads with ~/.netrc")
+	staticPtr   = flag.String("static", "", "serve the files of dir as they are when no route matches")
+	ctypePtr    = flag.String("content-type", "", "serve every route with this Content-Type")
+	tmplPtr     = flag.Bool("templates", false, "render every fixture as a go template")
+	unixPtr     = flag.String("unix", "", "listen on a unix domain socket instead of tcp")
+	graphqlPtr  = flag.String("graphql", "", "serve /graphql from a graphql schema")
+	gqlDataPtr  = flag.String("graphql-data", "", "answer graphql queries with this json, keyed by root field")
+	grpcPtr     = flag.String("grpc", "", "answer the grpc methods of a .proto file or descriptor set")
+	grpcDirPtr  = flag.String("grpc-fixtures", "", "where the grpc fixtures are")
+	grpcPortPtr = flag.Int("grpc-port", 9173, "the port grpc listens on")
+	announcePtr = flag.String("announce", "", "write where mok listens to file as a json line")
+	otlpPtr     = flag.String("otlp", mok.TracesEndpoint(), "export a span per request to this OTLP/HTTP traces endpoint")
+	harPtr      = flag.String("record-har", "", "write the session to file as a HAR on shutdown")
+	logFilePtr  = flag.String("log-file", "", "append the request log to file instead of stdout")
+	indexPtr    = flag.String("index-template", "", "render this html/template at / instead of the dashboard")
+	prettyJSPtr = flag.Bool("pretty-json", false, "indent json responses whatever their fixture looks like")
+	minifyPtr   = flag.Bool("minify", false, "strip the whitespace of json responses")
+	noIndexPtr  = flag.Bool("no-index", false, "serve neither the route listing at / nor the dashboard")
+	contractPtr = flag.String("contract", "", "warn about routes and fixtures drifting from this OpenAPI document")
+	strictCPtr  = flag.Bool("contract-strict", false, "refuse to start when they drift from -contract")
+	watchPtr    = new(bool)
+	hostPtr     = new(string)
+	delayFlag   mok.Delay
+	rateFlag    mok.RateLimit
+	speedFlag   mok.Bandwidth
+	networkFlag mok.Network
+	compFlag    mok.Compression
+	mtlsFlag    mok.ClientCerts
+	logFlag     mok.LogFormat
+	headerFlag  headerFlags
+	originFlag  originFlags
+	remoteFlag  headerFlags
+	wsFlag      mountFlags
+	rpcFlag     mountFlags
+	vhostFlag   vhostFlags
+	inlineFlag  inlineFlags
 )
 
-func errAndExit(msg string) {
-	fmt.Fprintf(os.Stderr, "error: %s\n\n", msg)
-	os.Exit(1)
+func init() {
+	flag.Var(&inlineFlag, "s", "specify the json string to serve on /, or on a path as /path=json, repeatable")
+	flag.Var(&originFlag, "cors-origin", "allow credentialed cross origin requests from origin only, repeatable")
+	flag.Var(&headerFlag, "H", `add a "Name: value" header to every response, repeatable`)
+	flag.Var(&remoteFlag, "remote-header", `send a "Name: value" header when downloading remote files, repeatable`)
+	flag.Var(&vhostFlag, "vhost", "serve a file or directory only to requests for a host, as host=dir, repeatable")
+	flag.Var(&rpcFlag, "jsonrpc", "answer the JSON-RPC calls to path with the fixtures in dir, as path=dir, repeatable")
+	flag.Var(&wsFlag, "ws", "upgrade path to a websocket playing the script, as path=script, repeatable")
+	flag.Var(&delayFlag, "delay", "delay every response, fixed (100ms) or jittered (100ms±50ms)")
+	flag.Var(&speedFlag, "throttle", "cap how fast responses are written, e.g. 50kbps")
+	flag.Var(&mtlsFlag, "mtls-mode", "what clients without a valid certificate get: require, optional or status")
+	flag.Var(&logFlag, "log-format", "log every request as json or combined")
+	flag.Var(&compFlag, "compress", "compress json and text responses: auto, always or never")
+	flag.Var(&networkFlag, "network", "emulate a network: "+strings.Join(mok.NetworkNames(), ", "))
+	flag.Var(&rateFlag, "rate-limit", "limit every route to a number of requests per period, e.g. 10/s")
+	flag.BoolVar(watchPtr, "w", false, "watch served files and reload them on change")
+	flag.BoolVar(watchPtr, "watch", false, "watch served files and reload them on change")
+	flag.StringVar(hostPtr, "host", defaultHost, "the address to listen on")
+	flag.StringVar(hostPtr, "bind", defaultHost, "the address to listen on")
 }
 
-func main() {
-	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, usage)
+// headerFlags collects repeatable "Name: value" flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+// Set implements flag.Value.
+func (h *headerFlags) Set(s string) error {
+	name, _, found := strings.Cut(s, ":")
+	if !found || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q, expected `Name: value`", s)
+	}
+	*h = append(*h, s)
+	return nil
+}
+
+func (h headerFlags) header() http.Header {
+	header := make(http.Header)
+	for _, s := range h {
+		name, value, _ := strings.Cut(s, ":")
+		header.Add(textproto.TrimString(name), textproto.TrimString(value))
 	}
-	flag.Parse()
+	return header
+}
 
-	directInput := getDirectInput()
+// originFlags collects repeatable -cors-origin flags.
+type originFlags []string
 
-	if flag.NArg() < 1 && len(directInput) == 0 {
-		errAndExit("no file specified")
+func (f *originFlags) String() string { return strings.Join(*f, ", ") }
+
+// Set implements flag.Value.
+func (f *originFlags) Set(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return fmt.Errorf("invalid origin %q, expected scheme://host[:port]", s)
 	}
-	// mok receives exactly what the shell passes.
-	//   ./mok testdata/*.json
-	// shells expand the glob before execution, so the program sees:
-	//   ./mok testdata/a.json testdata/b.json ...
-	// curious rabbits: https://man7.org/linux/man-pages/man7/glob.7.html
-	files := processFileArgs(flag.Args())
+	*f = append(*f, strings.TrimSuffix(s, "/"))
+	return nil
+}
 
-	setupHandlers(directInput, files)
+// mountFlags collects repeatable "/path=source" flags.
+type mountFlags [][2]string
 
-	if len(directInput) == 0 {
-		printSummary(*portPtr, files)
-	} else {
-		fmt.Printf("mok is serving direct input on http://localhost:%d/\n", *portPtr)
+func (f *mountFlags) String() string { return fmt.Sprint(*f) }
+
+// Set implements flag.Value.
+func (f *mountFlags) Set(s string) error {
+	path, source, found := strings.Cut(s, "=")
+	if !found || !strings.HasPrefix(path, "/") || source == "" {
+		return fmt.Errorf("invalid value %q, expected /path=<file or dir>", s)
 	}
+	*f = append(*f, [2]string{path, source})
+	return nil
+}
+
+// inlineFlags collects repeatable "-s" flags, "/path=json" serves json at
+// path, plain json is served on every path.
+type inlineFlags struct {
+	root   string
+	routes [][2]string
+}
 
-	if err := http.ListenAndServe(":"+strconv.Itoa(*portPtr), nil); err != nil {
+func (f *inlineFlags) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.root
+}
+
+// Set implements flag.Value.
+func (f *inlineFlags) Set(s string) error {
+	// json never starts with a slash
+	if strings.HasPrefix(s, "/") {
+		path, content, found := strings.Cut(s, "=")
+		if !found || content == "" {
+			return fmt.Errorf("invalid value %q, expected /path=json", s)
+		}
+		f.routes = append(f.routes, [2]string{path, content})
+		return nil
+	}
+	if f.root != "" {
+		return errors.New("only one -s can be served on every path, bind the others to a path with /path=json")
+	}
+	f.root = s
+	return nil
+}
+
+// vhostFlags collects repeatable "host=file" flags.
+type vhostFlags [][2]string
+
+func (f *vhostFlags) String() string { return fmt.Sprint(*f) }
+
+// Set implements flag.Value.
+func (f *vhostFlags) Set(s string) error {
+	host, file, found := strings.Cut(s, "=")
+	if !found || host == "" || strings.ContainsAny(host, "/:") || file == "" {
+		return fmt.Errorf("invalid virtual host %q, expected host=<file or dir>", s)
+	}
+	*f = append(*f, [2]string{host, file})
+	return nil
+}
+
+var recordUsage = `
+  usage: mok record -target <url> [options]
+
+  proxies every request to the target and saves json responses as fixtures,
+  together with a mok.yaml describing the routes. serve them back with:
+
+    mok replay [options] [dir]
+
+  options:
+    -target <url>       the API to record, e.g. https://api.example.com
+    -o <dir>            where fixtures are written (default recordings)
+    -p <port>           specify the port to listen on
+    -host <addr>        the address to listen on (default 127.0.0.1)
+
+`
+
+func runRecord(args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, recordUsage)
+	}
+	target := fs.String("target", "", "the API to record")
+	dir := fs.String("o", "recordings", "where fixtures are written")
+	port := fs.Int("p", 9172, "specify the port to listen on")
+	host := fs.String("host", defaultHost, "the address to listen on")
+	fs.Parse(args)
+
+	if *target == "" {
+		errAndExit("-target is required")
+	}
+	handler, err := mok.NewRecorder(*target, *dir, os.Stdout)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	fmt.Printf("  mok is recording %s at http://%s\n", *target, displayAddr(addr))
+	fmt.Printf("  fixtures are written to %s/\n\n", *dir)
+
+	if err := http.ListenAndServe(addr, handler); err != nil {
 		errAndExit("http: " + err.Error())
 	}
 }
 
-type MokFile struct {
-	FilePath string
-	URLPath  string
+var validateUsage = `
+  usage: mok validate [options] [files.json]
+
+  parses every fixture and the config like mok serve would, without serving
+  them, and reports syntax errors with their line and column and routes
+  served twice. mok exits with status 1 on problems, e.g. in a pre-commit
+  hook. mok.yaml in the working directory is checked too.
+
+  options:
+    -c <file>           the config to check, routes and the fixtures they serve
+    -json               print the problems as a json array
+
+`
+
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, validateUsage)
+	}
+	config := fs.String("c", "", "the config to check")
+	asJSON := fs.Bool("json", false, "print the problems as a json array")
+	fs.Parse(args)
+
+	if *config == "" {
+		if _, err := os.Stat(mok.DefaultConfigFile); err == nil {
+			*config = mok.DefaultConfigFile
+		}
+	}
+	if fs.NArg() == 0 && *config == "" {
+		errAndExit("nothing to validate, pass fixtures or -c")
+	}
+
+	problems, checked := mok.Validate(nil, *config, fs.Args())
+	switch {
+	case *asJSON:
+		if problems == nil {
+			problems = []mok.Problem{}
+		}
+		out, _ := json.MarshalIndent(problems, "", "  ")
+		fmt.Println(string(out))
+	case len(problems) == 0:
+		fmt.Printf("  %s ok\n", plural(checked, "fixture"))
+	default:
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("\n  %s in %s\n", plural(len(problems), "problem"), plural(checked, "fixture"))
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
 }
 
-func downloadJSON(_url string) (string, error) {
-	logInfo(fmt.Sprintf("downloading: %q", _url))
-	u, err := url.Parse(_url)
+var routesUsage = `
+  usage: mok routes [options] [files.json]
+
+  prints the routes mok serve would serve, without listening: method, path,
+  source, status and the matchers of rules, scenarios and auth. mok.yaml in
+  the working directory is read too.
+
+  options:
+    -c <file>           specify the route config file
+    -root <dir>         read local files and the config from dir only
+    -prefix <path>      mount every route under path, e.g. /api/v2
+    -pretty             serve fixtures without their extension
+    -keep-ext           with -pretty, keep serving /users.json too
+    -namespace          mount files sharing a route under their directory
+    -profile <name>     list the routes of a profile of the config
+    -json               print the routes as a json array
+
+`
+
+func runRoutes(args []string) {
+	fs := flag.NewFlagSet("routes", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, routesUsage)
+	}
+	config := fs.String("c", "", "specify the route config file")
+	root := fs.String("root", "", "read local files and the config from dir only")
+	prefix := fs.String("prefix", "", "mount every route under this path")
+	pretty := fs.Bool("pretty", false, "serve users.json at /users")
+	keepExt := fs.Bool("keep-ext", false, "with -pretty, keep serving /users.json too")
+	namespace := fs.Bool("namespace", false, "mount files sharing a route under their directory")
+	profile := fs.String("profile", "", "list the routes of this profile of the config")
+	asJSON := fs.Bool("json", false, "print the routes as a json array")
+	fs.Parse(args)
+
+	if *config == "" {
+		if _, err := os.Stat(filepath.Join(*root, mok.DefaultConfigFile)); err == nil {
+			*config = mok.DefaultConfigFile
+		}
+	}
+	if fs.NArg() == 0 && *config == "" {
+		errAndExit("no file specified")
+	}
+
+	opts := []mok.Option{mok.WithFiles(fs.Args()...), mok.WithPrefix(*prefix)}
+	if *config != "" {
+		opts = append(opts, mok.WithConfig(*config))
+	}
+	if *root != "" {
+		opts = append(opts, mok.WithFS(os.DirFS(*root)))
+	}
+	if *pretty {
+		opts = append(opts, mok.WithPrettyURLs(*keepExt))
+	}
+	if *namespace {
+		opts = append(opts, mok.WithNamespace())
+	}
+	if *profile != "" {
+		opts = append(opts, mok.WithProfile(*profile))
+	}
+	srv, err := mok.New(opts...)
 	if err != nil {
-		return "", fmt.Errorf("parse URL: %w", err)
+		errAndExit(err.Error())
 	}
+	// removes the downloaded copies of remote files
+	defer srv.Shutdown(context.Background())
 
-	tempFile, err := os.CreateTemp("", fmt.Sprintf("mok-%s.*.json", u.Host))
+	table := srv.RouteTable()
+	if *asJSON {
+		if table == nil {
+			table = []mok.RouteInfo{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(table)
+		return
+	}
+	printRoutes(os.Stdout, table)
+}
+
+// printRoutes writes table in aligned columns, a route with many matchers
+// takes a line per matcher.
+func printRoutes(w io.Writer, table []mok.RouteInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  METHOD\tPATH\tSOURCE\tSTATUS\tMATCHERS")
+	for _, r := range table {
+		matchers := r.Matchers
+		if len(matchers) == 0 {
+			matchers = []string{"-"}
+		}
+		status := "-"
+		if r.Status != 0 {
+			status = strconv.Itoa(r.Status)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", cmp.Or(r.Method, "*"), r.Host+r.Path, r.Source, status, matchers[0])
+		for _, m := range matchers[1:] {
+			fmt.Fprintf(tw, "  \t\t\t\t%s\n", m)
+		}
+	}
+	tw.Flush()
+}
+
+var initUsage = `
+  usage: mok init [dir]
+
+  writes a starter layout into dir, the working directory by default: a
+  mok.yaml with a templated route, a scenario, rules and a profile, and the
+  fixtures they serve. existing files are left alone.
+
+`
+
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, initUsage)
+	}
+	fs.Parse(args)
+
+	dir := cmp.Or(fs.Arg(0), ".")
+	written, err := mok.Scaffold(dir)
 	if err != nil {
-		return "", fmt.Errorf("create temp file: %w", err)
+		errAndExit("init: " + err.Error())
+	}
+	if len(written) == 0 {
+		fmt.Printf("  %s has every file of the starter layout already\n", dir)
+		return
+	}
+	for _, name := range written {
+		fmt.Printf("  created %s\n", name)
+	}
+	fmt.Println("\n  serve it with:")
+	if dir != "." {
+		fmt.Printf("  cd %s && ", dir)
+	} else {
+		fmt.Print("  ")
 	}
-	defer tempFile.Close()
-	logInfo(fmt.Sprintf("creating temp file: %q", tempFile.Name()))
+	fmt.Println("mok")
+}
+
+var convertUsage = `
+  usage: mok convert -from <format> [options] <file>
+         mok convert -to <format> [options] [files.json]
+
+  turns an openapi document, a postman collection with saved responses or a
+  HAR into fixtures and a mok.yaml, the layout of a recording: serve them
+  with mok replay. the other way around, describes the routes mok serve
+  would serve, mok.yaml in the working directory included, as one of them.
+
+  options:
+    -from <format>      import from openapi, postman or har
+    -to <format>        export to openapi, postman or har
+    -o <path>           where fixtures are written (default mocks), or the
+                        exported file (default stdout)
+    -c <file>           with -to, specify the route config file
+    -base-url <url>     with -to, where the exported requests go
+                        (default http://localhost:9172)
+
+`
+
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, convertUsage)
+	}
+	from := fs.String("from", "mok", "import from openapi, postman or har")
+	to := fs.String("to", "mok", "export to openapi, postman or har")
+	out := fs.String("o", "", "where fixtures are written, or the exported file")
+	config := fs.String("c", "", "with -to, specify the route config file")
+	baseURL := fs.String("base-url", "http://localhost:9172", "with -to, where the exported requests go")
+	fs.Parse(args)
+
+	switch {
+	case *from != "mok" && *to != "mok":
+		errAndExit("one side of the conversion must be mok")
+	case *from != "mok":
+		if fs.NArg() != 1 {
+			errAndExit("convert -from takes one file")
+		}
+		dir := cmp.Or(*out, "mocks")
+		written, err := mok.Import(*from, fs.Arg(0), dir)
+		if err != nil {
+			errAndExit("convert: " + err.Error())
+		}
+		fmt.Printf("  wrote %s to %s/, serve them with:\n\n  mok replay %s\n", plural(len(written), "file"), dir, dir)
+	case *to != "mok":
+		if *config == "" {
+			if _, err := os.Stat(mok.DefaultConfigFile); err == nil {
+				*config = mok.DefaultConfigFile
+			}
+		}
+		if fs.NArg() == 0 && *config == "" {
+			errAndExit("no file specified")
+		}
+		opts := []mok.Option{mok.WithFiles(fs.Args()...)}
+		if *config != "" {
+			opts = append(opts, mok.WithConfig(*config))
+		}
+		srv, err := mok.New(opts...)
+		if err != nil {
+			errAndExit(err.Error())
+		}
+		// removes the downloaded copies of remote files
+		defer srv.Shutdown(context.Background())
+
+		var buf bytes.Buffer
+		if err := srv.Export(*to, *baseURL, &buf); err != nil {
+			errAndExit("convert: " + err.Error())
+		}
+		if *out == "" {
+			os.Stdout.Write(buf.Bytes())
+		} else if err := os.WriteFile(*out, buf.Bytes(), 0o644); err != nil {
+			errAndExit("convert: " + err.Error())
+		}
+	default:
+		errAndExit("pass -from or -to")
+	}
+}
+
+var diffUsage = `
+  usage: mok diff -target <url> [options] [files.json]
 
-	resp, err := http.Get(_url)
+  requests the GET routes mok serve would serve from the live api they stand
+  in for and reports where it drifted from the fixtures: statuses, fields
+  missing on either side and values changing type. wildcards are requested
+  as 1, /users/{id} as /users/1. mok exits with status 1 on drift, e.g. in a
+  nightly job. mok.yaml in the working directory is read too.
+
+  options:
+    -target <url>       the live api, e.g. https://api.example.com
+    -c <file>           specify the route config file
+    -H <header>         send a "Name: value" header with every request, repeatable
+    -json               print the drift as a json array
+
+`
+
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, diffUsage)
+	}
+	target := fs.String("target", "", "the live api")
+	config := fs.String("c", "", "specify the route config file")
+	var header headerFlags
+	fs.Var(&header, "H", `send a "Name: value" header with every request, repeatable`)
+	asJSON := fs.Bool("json", false, "print the drift as a json array")
+	fs.Parse(args)
+
+	if *target == "" {
+		errAndExit("-target is required")
+	}
+	if *config == "" {
+		if _, err := os.Stat(mok.DefaultConfigFile); err == nil {
+			*config = mok.DefaultConfigFile
+		}
+	}
+	if fs.NArg() == 0 && *config == "" {
+		errAndExit("no file specified")
+	}
+
+	opts := []mok.Option{mok.WithFiles(fs.Args()...)}
+	if *config != "" {
+		opts = append(opts, mok.WithConfig(*config))
+	}
+	srv, err := mok.New(opts...)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+	problems, checked, err := srv.Diff(context.Background(), *target, header.header())
+	// removes the downloaded copies of remote files
+	srv.Shutdown(context.Background())
 	if err != nil {
-		return "", fmt.Errorf("download: %w", err)
+		errAndExit("diff: " + err.Error())
 	}
-	defer resp.Body.Close()
 
-	if resp.Header.Get("Content-Type") != "application/json" {
-		return "", fmt.Errorf("unexpected content type for %q: %s", _url, resp.Header.Get("Content-Type"))
+	switch {
+	case *asJSON:
+		if problems == nil {
+			problems = []mok.Problem{}
+		}
+		out, _ := json.MarshalIndent(problems, "", "  ")
+		fmt.Println(string(out))
+	case len(problems) == 0:
+		fmt.Printf("  %s checked, no drift from %s\n", plural(checked, "route"), *target)
+	default:
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("\n  %s in %s\n", plural(len(problems), "difference"), plural(checked, "route"))
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
 	}
+}
+
+var completionUsage = `
+  usage: mok completion bash|zsh|fish
+
+  prints a script completing the commands, the flags and the json files of
+  mok in the shell, load it with:
+
+    source <(mok completion bash)                              # ~/.bashrc
+    source <(mok completion zsh)                               # ~/.zshrc
+    mok completion fish > ~/.config/fish/completions/mok.fish
+
+`
 
-	if resp.StatusCode != http.StatusOK {
-		logInfo(fmt.Sprintf("failed to download file from: %q", _url))
-		return "", fmt.Errorf("download failed: %s", resp.Status)
+func runCompletion(args []string) {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, completionUsage)
 	}
+	fs.Parse(args)
 
-	if _, err := io.Copy(tempFile, resp.Body); err != nil {
-		return "", fmt.Errorf("save: %w", err)
+	names := slices.Sorted(maps.Keys(commands))
+	switch fs.Arg(0) {
+	case "bash":
+		writeBashCompletion(os.Stdout, names)
+	case "zsh":
+		writeZshCompletion(os.Stdout, names)
+	case "fish":
+		writeFishCompletion(os.Stdout, names)
+	default:
+		errAndExit("completion takes bash, zsh or fish")
 	}
+}
+
+// completedFlag is a flag of a command and what it does.
+type completedFlag struct{ name, usage string }
+
+// usageFlagRe matches the options of a usage, "    -o <dir>   where...".
+var usageFlagRe = regexp.MustCompile(`(?m)^    -([\w-]+)(?: <[^>]*>)?\s*(.*)$`)
 
-	logInfo(fmt.Sprintf("succesfully downloaded file %q to %q", _url, tempFile.Name()))
-	return tempFile.Name(), nil
+// commandFlags are the flags of a command: the ones of serve are the
+// global flag set, the other commands list theirs in their usage.
+func commandFlags(command string) []completedFlag {
+	var flags []completedFlag
+	switch command {
+	case "help":
+		return nil
+	case "serve", "replay":
+		flag.VisitAll(func(f *flag.Flag) {
+			flags = append(flags, completedFlag{f.Name, f.Usage})
+		})
+		return flags
+	}
+	text, _ := commandUsage(command)
+	for _, m := range usageFlagRe.FindAllStringSubmatch(text, -1) {
+		flags = append(flags, completedFlag{m[1], m[2]})
+	}
+	return flags
 }
 
-func printSummary(port int, files []MokFile) {
-	baseURL := fmt.Sprintf("http://localhost:%d", port)
+// flagNames are the flags of a command as typed, -name.
+func flagNames(command string) string {
+	var names []string
+	for _, f := range commandFlags(command) {
+		names = append(names, "-"+f.name)
+	}
+	return strings.Join(names, " ")
+}
 
-	fmt.Printf("  mok is listening at %s\n\n", baseURL)
-	fmt.Println("  available endpoints:")
+// argCompletion is what the arguments of a command are: a shell for
+// completion, a command for help, json files otherwise.
+func argCompletion(command string) string {
+	switch command {
+	case "completion":
+		return "bash zsh fish"
+	case "help":
+		return "commands"
+	}
+	return ""
+}
 
-	maxURLLen := 0
-	for _, file := range files {
-		urlLen := len("GET " + file.URLPath)
-		if urlLen > maxURLLen {
-			maxURLLen = urlLen
+func writeBashCompletion(w io.Writer, names []string) {
+	fmt.Fprintf(w, `# bash completion for mok, load it with: source <(mok completion bash)
+_mok() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd=serve
+	local commands="%s"
+	if [[ $COMP_CWORD -gt 1 && " $commands " == *" ${COMP_WORDS[1]} "* ]]; then
+		cmd=${COMP_WORDS[1]}
+	fi
+	if [[ $cur == -* ]]; then
+		case $cmd in
+`, strings.Join(names, " "))
+	for _, name := range names {
+		if len(commandFlags(name)) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", name, flagNames(name))
+	}
+	fmt.Fprint(w, `		esac
+		return
+	fi
+	case $cmd in
+`)
+	for _, name := range names {
+		switch args := argCompletion(name); args {
+		case "":
+		case "commands":
+			fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W \"$commands\" -- \"$cur\")); return ;;\n", name)
+		default:
+			fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, args)
 		}
 	}
+	fmt.Fprint(w, `	esac
+	COMPREPLY=($(compgen -f -X '!*.json' -- "$cur") $(compgen -d -- "$cur"))
+	if [[ $COMP_CWORD -eq 1 ]]; then
+		COMPREPLY+=($(compgen -W "$commands" -- "$cur"))
+	fi
+}
+complete -o filenames -F _mok mok
+`)
+}
 
-	for _, file := range files {
-		url := file.URLPath
-		source := fmt.Sprintf("(%s)", file.FilePath)
+func writeZshCompletion(w io.Writer, names []string) {
+	fmt.Fprintf(w, `#compdef mok
+# zsh completion for mok, load it with: source <(mok completion zsh)
+_mok() {
+	local -a subcommands=(%s)
+	local -a flags
+	local cmd=serve
+	if (( CURRENT > 2 && ${subcommands[(Ie)$words[2]]} )); then
+		cmd=$words[2]
+	fi
+	if [[ $PREFIX == -* ]]; then
+		case $cmd in
+`, strings.Join(names, " "))
+	for _, name := range names {
+		if len(commandFlags(name)) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t\t%s) flags=(%s) ;;\n", name, flagNames(name))
+	}
+	fmt.Fprint(w, `		esac
+		compadd -a flags
+		return
+	fi
+	case $cmd in
+`)
+	for _, name := range names {
+		switch args := argCompletion(name); args {
+		case "":
+		case "commands":
+			fmt.Fprintf(w, "\t%s) compadd -a subcommands; return ;;\n", name)
+		default:
+			fmt.Fprintf(w, "\t%s) compadd %s; return ;;\n", name, args)
+		}
+	}
+	fmt.Fprint(w, `	esac
+	(( CURRENT == 2 )) && compadd -a subcommands
+	_files -g '*.json'
+}
+compdef _mok mok
+`)
+}
 
-		padding := maxURLLen - len(" "+url)
-		spaces := strings.Repeat(" ", padding)
+func writeFishCompletion(w io.Writer, names []string) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
+	commands := strings.Join(names, " ")
+	fmt.Fprintf(w, `# fish completion for mok, save it with: mok completion fish > ~/.config/fish/completions/mok.fish
+complete -c mok -f
+complete -c mok -n 'not __fish_seen_subcommand_from %s' -a '%s'
+`, commands, commands)
+	for _, name := range names {
+		cond := "__fish_seen_subcommand_from " + name
+		if name == "serve" {
+			// a bare mok serves
+			cond = "not __fish_seen_subcommand_from " + strings.Join(slices.DeleteFunc(slices.Clone(names), func(n string) bool { return n == "serve" }), " ")
+		}
+		for _, f := range commandFlags(name) {
+			fmt.Fprintf(w, "complete -c mok -n '%s' -o '%s' -d '%s'\n", cond, quote(f.name), quote(f.usage))
+		}
+		switch args := argCompletion(name); args {
+		case "":
+			fmt.Fprintf(w, "complete -c mok -n '%s' -a '(__fish_complete_suffix .json)'\n", cond)
+		case "commands":
+			fmt.Fprintf(w, "complete -c mok -n '%s' -a '%s'\n", cond, commands)
+		default:
+			fmt.Fprintf(w, "complete -c mok -n '%s' -a '%s'\n", cond, args)
+		}
+	}
+}
 
-		fmt.Printf("   %s%s  %s\n", url, spaces, source)
+// plural is "1 fixture" or "2 fixtures".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
 	}
+	return fmt.Sprintf("%d %ss", n, noun)
 }
 
-func logInfo(msg string) {
-	if *verbosePtr {
-		log.Println(msg)
+func errAndExit(msg string) {
+	fmt.Fprintf(os.Stderr, "error: %s\n\n", msg)
+	os.Exit(1)
+}
+
+// commands are the subcommands of mok, each parses its own flags.
+var commands = map[string]func(args []string){
+	"serve":    runServe,
+	"record":   runRecord,
+	"replay":   runReplay,
+	"validate": runValidate,
+	"routes":   runRoutes,
+	"init":     runInit,
+	"convert":  runConvert,
+	"diff":     runDiff,
+	"help":     runHelp,
+}
+
+// completion lists the commands, registered here to not depend on itself.
+func init() { commands["completion"] = runCompletion }
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
 	}
+	// a bare mok files.json serves them
+	runServe(os.Args[1:])
 }
 
-func getDirectInput() []byte {
-	// stdin first
-	fi, err := os.Stdin.Stat()
+// runHelp prints the usage of a command, of serve by default.
+func runHelp(args []string) {
+	command := "serve"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	text, ok := commandUsage(command)
+	if !ok {
+		errAndExit(fmt.Sprintf("unknown command %q, try mok help", command))
+	}
+	fmt.Print(text)
+}
+
+// commandUsage is the usage of a command, help and serve share the one of
+// mok.
+func commandUsage(command string) (string, bool) {
+	switch command {
+	case "serve", "replay", "help":
+		return usage, true
+	case "record":
+		return recordUsage, true
+	case "validate":
+		return validateUsage, true
+	case "routes":
+		return routesUsage, true
+	case "init":
+		return initUsage, true
+	case "convert":
+		return convertUsage, true
+	case "diff":
+		return diffUsage, true
+	case "completion":
+		return completionUsage, true
+	}
+	return "", false
+}
+
+func runServe(args []string) { serve(args, false) }
+
+// runReplay serves a recording of mok record, the mok.yaml in the
+// directory describes its routes.
+func runReplay(args []string) { serve(args, true) }
+
+func serve(args []string, replay bool) {
+	flag.CommandLine.Parse(args)
+	args = flag.Args()
+
+	if replay {
+		// a recording is just a config pointing to its fixtures
+		dir := cmp.Or(flag.Arg(0), "recordings")
+		*configPtr = filepath.Join(dir, mok.DefaultConfigFile)
+		args = nil
+	}
+	if *configPtr == "" {
+		if _, err := os.Stat(filepath.Join(*rootPtr, mok.DefaultConfigFile)); err == nil {
+			*configPtr = mok.DefaultConfigFile
+		}
+	}
+
+	// - reads stdin next to the other sources
+	stdinArg := slices.Contains(args, "-")
+	args = slices.DeleteFunc(args, func(arg string) bool { return arg == "-" })
+
+	var directInput []byte
+	var follow io.Reader
+	if *followPtr {
+		if inlineFlag.root != "" {
+			errAndExit("-f follows stdin, it cannot be used with -s on every path")
+		}
+		directInput, follow = followStdin()
+	} else {
+		directInput = getDirectInput(stdinArg || !hasSources(args))
+	}
+	streamed, err := splitDirectInput(directInput)
 	if err != nil {
-		errAndExit("cannot read direct input: " + err.Error())
+		errAndExit(err.Error())
+	}
+	if streamed != nil && follow == nil {
+		for _, route := range streamed {
+			route[0] = strings.TrimSuffix(*inPathPtr, "/") + route[0]
+			inlineFlag.routes = append(inlineFlag.routes, route)
+		}
+		directInput = nil
 	}
 
-	if size := fi.Size(); size > 0 {
-		directInput := make([]byte, size)
-		if _, err := os.Stdin.Read(directInput); err != nil {
-			errAndExit("cannot read direct input: " + err.Error())
+	if len(directInput) == 0 && !hasSources(args) {
+		errAndExit("no file specified")
+	}
+	if (*certPtr == "") != (*keyPtr == "") {
+		errAndExit("-cert and -key must be passed together")
+	}
+	if *tlsAutoPtr && *certPtr != "" {
+		errAndExit("-tls-auto cannot be used with -cert and -key")
+	}
+	if *mtlsCAPtr != "" && !*tlsAutoPtr && *certPtr == "" {
+		errAndExit("-mtls-ca requires -cert and -key or -tls-auto")
+	}
+	if *h2cPtr && (*tlsAutoPtr || *certPtr != "") {
+		errAndExit("-h2c is for plain http, https speaks HTTP/2 already")
+	}
+	if (*fallbackPtr != "" || *wiremockPtr != "" || *staticPtr != "") && len(directInput) > 0 && *inPathPtr == "" {
+		errAndExit("-fallback, -wiremock and -static cannot be used with direct input served on every path, see -input-path")
+	}
+
+	// mok receives exactly what the shell passes.
+	//   ./mok testdata/*.json
+	// shells expand the glob before execution, so the program sees:
+	//   ./mok testdata/a.json testdata/b.json ...
+	// curious rabbits: https://man7.org/linux/man-pages/man7/glob.7.html
+	opts := []mok.Option{
+		mok.WithFiles(args...),
+		mok.WithDirectInput(directInput),
+		mok.WithDirectInputPath(*inPathPtr),
+		mok.WithDelay(delayFlag),
+		mok.WithRateLimit(rateFlag),
+		mok.WithThrottle(speedFlag),
+		mok.WithNetwork(networkFlag),
+		mok.WithCompression(compFlag),
+		mok.WithCacheControl(*cachePtr),
+		mok.WithContentType(*ctypePtr),
+		mok.WithExpires(*expiresPtr),
+		mok.WithPrefix(*prefixPtr),
+		mok.WithHeaders(headerFlag.header()),
+		mok.WithOutput(os.Stdout),
+	}
+	if follow != nil {
+		opts = append(opts, mok.WithFollow(follow))
+	}
+	if *configPtr != "" {
+		opts = append(opts, mok.WithConfig(*configPtr))
+	}
+	if len(remoteFlag) > 0 {
+		opts = append(opts, mok.WithRemoteHeaders(remoteFlag.header()))
+	}
+	if *netrcPtr {
+		opts = append(opts, mok.WithNetrc(""))
+	}
+	if *rtimeoutPtr != mok.DefaultRemoteTimeout {
+		opts = append(opts, mok.WithRemoteTimeout(*rtimeoutPtr))
+	}
+	if *anyTypePtr {
+		opts = append(opts, mok.WithRemoteAcceptAny())
+	}
+	if *rcachePtr != "" {
+		opts = append(opts, mok.WithRemoteCache(*rcachePtr))
+	}
+	if *refreshPtr > 0 {
+		opts = append(opts, mok.WithRefresh(*refreshPtr))
+	}
+	if *profilePtr != "" {
+		opts = append(opts, mok.WithProfile(*profilePtr))
+	}
+	if *rootPtr != "" {
+		opts = append(opts, mok.WithFS(os.DirFS(*rootPtr)))
+	}
+	if *verbosePtr {
+		opts = append(opts, mok.WithVerbose())
+	}
+	if *otlpPtr != "" {
+		opts = append(opts, mok.WithTracing(*otlpPtr))
+	}
+	if *harPtr != "" {
+		opts = append(opts, mok.WithRecordHAR(*harPtr))
+	}
+	if logFlag != "" || *logFilePtr != "" {
+		var out io.Writer = os.Stdout
+		if *logFilePtr != "" {
+			f, err := os.OpenFile(*logFilePtr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				errAndExit(fmt.Sprintf("opening the request log: %s", err))
+			}
+			defer f.Close()
+			out = f
+		}
+		opts = append(opts, mok.WithAccessLog(out, logFlag))
+	}
+	if *corsPtr || len(originFlag) > 0 {
+		opts = append(opts, mok.WithCORS(originFlag...))
+	}
+	if *authPtr != "" {
+		user, pass, found := strings.Cut(*authPtr, ":")
+		if !found || user == "" {
+			errAndExit("-auth must be user:pass")
 		}
-		return directInput
+		opts = append(opts, mok.WithBasicAuth(user, pass))
+	}
+	if *crudPtr != "" {
+		opts = append(opts, mok.WithCRUD(*crudPtr))
+	}
+	if *utilsPtr {
+		opts = append(opts, mok.WithUtils())
+	}
+	if *tmplPtr {
+		opts = append(opts, mok.WithTemplates())
+	}
+	if *secretPtr != "" && *webhookPtr == "" {
+		errAndExit("-webhook-secret needs -webhook")
+	}
+	if *webhookPtr != "" {
+		opts = append(opts, mok.WithWebhooks(*webhookPtr, *secretPtr))
+	}
+	if *oidcPtr {
+		var claims map[string]any
+		if *claimsPtr != "" {
+			data, err := os.ReadFile(*claimsPtr)
+			if err != nil {
+				errAndExit("reading claims: " + err.Error())
+			}
+			if err := json.Unmarshal(data, &claims); err != nil {
+				errAndExit(fmt.Sprintf("parsing claims %q: %s", *claimsPtr, err))
+			}
+		}
+		opts = append(opts, mok.WithOIDC(claims))
+	}
+	if *clientsPtr != "" {
+		if !*oidcPtr {
+			errAndExit("-oauth-clients requires -oidc")
+		}
+		data, err := os.ReadFile(*clientsPtr)
+		if err != nil {
+			errAndExit("reading clients: " + err.Error())
+		}
+		var cfg struct {
+			Clients []mok.OAuthClient `yaml:"clients"`
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			errAndExit(fmt.Sprintf("parsing clients %q: %s", *clientsPtr, err))
+		}
+		opts = append(opts, mok.WithOAuthClients(cfg.Clients...))
+	}
+	if *fallbackPtr != "" {
+		opts = append(opts, mok.WithFallback(*fallbackPtr))
+	}
+	if *wiremockPtr != "" {
+		opts = append(opts, mok.WithWiremock(*wiremockPtr))
+	}
+	if *nsPtr {
+		opts = append(opts, mok.WithNamespace())
+	}
+	if *keepExtPtr && !*prettyPtr {
+		errAndExit("-keep-ext requires -pretty")
+	}
+	if *prettyPtr {
+		opts = append(opts, mok.WithPrettyURLs(*keepExtPtr))
+	}
+	if *staticPtr != "" {
+		opts = append(opts, mok.WithStatic(*staticPtr))
+	}
+	if *graphqlPtr != "" {
+		opts = append(opts, mok.WithGraphQL(*graphqlPtr, *gqlDataPtr))
+	}
+	if *grpcPtr != "" {
+		opts = append(opts, mok.WithGRPC(*grpcPtr, cmp.Or(*grpcDirPtr, filepath.Dir(*grpcPtr))))
+	}
+	for _, vhost := range vhostFlag {
+		opts = append(opts, mok.WithVirtualHost(vhost[0], vhost[1]))
+	}
+	for _, inline := range inlineFlag.routes {
+		opts = append(opts, mok.WithInlineJSON(inline[0], []byte(inline[1])))
+	}
+	for _, rpc := range rpcFlag {
+		opts = append(opts, mok.WithJSONRPC(rpc[0], rpc[1]))
+	}
+	for _, ws := range wsFlag {
+		opts = append(opts, mok.WithWebSocket(ws[0], ws[1]))
+	}
+	if *h2cPtr {
+		opts = append(opts, mok.WithH2C())
+	}
+	if *strictPtr {
+		opts = append(opts, mok.WithStrict())
+	}
+	if *prettyJSPtr && *minifyPtr {
+		errAndExit("-pretty-json cannot be used with -minify")
+	}
+	if *prettyJSPtr {
+		opts = append(opts, mok.WithJSONStyle(mok.JSONPretty))
+	}
+	if *minifyPtr {
+		opts = append(opts, mok.WithJSONStyle(mok.JSONMinify))
+	}
+	if *indexPtr != "" && *noIndexPtr {
+		errAndExit("-index-template cannot be used with -no-index")
+	}
+	if *indexPtr != "" {
+		opts = append(opts, mok.WithIndexTemplate(*indexPtr))
+	}
+	if *noIndexPtr {
+		opts = append(opts, mok.WithoutIndex())
+	}
+	if *strictCPtr && *contractPtr == "" {
+		errAndExit("-contract-strict needs -contract")
+	}
+	if *contractPtr != "" {
+		opts = append(opts, mok.WithContract(*contractPtr, *strictCPtr))
+	}
+	if *oncePtr {
+		*maxReqPtr = 1
+	}
+	if *maxReqPtr > 0 {
+		opts = append(opts, mok.WithMaxRequests(*maxReqPtr))
+	}
+	if *timeoutPtr > 0 {
+		opts = append(opts, mok.WithTimeout(*timeoutPtr))
+	}
+	if *watchPtr {
+		opts = append(opts, mok.WithWatch(mok.WatchInterval))
 	}
 
-	// then `-s` flag
-	if *jsonStrPtr != "" {
-		return []byte(*jsonStrPtr)
+	scheme := "http"
+	switch {
+	case *tlsAutoPtr:
+		cert, fingerprint, err := mok.SelfSignedCert()
+		if err != nil {
+			errAndExit("tls: " + err.Error())
+		}
+		opts = append(opts, mok.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+		fmt.Printf("  self-signed certificate sha256 fingerprint:\n  %s\n\n", fingerprint)
+		scheme = "https"
+	case *certPtr != "":
+		cert, err := tls.LoadX509KeyPair(*certPtr, *keyPtr)
+		if err != nil {
+			errAndExit("tls: " + err.Error())
+		}
+		opts = append(opts, mok.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+		scheme = "https"
 	}
 
-	return nil
-}
+	if *mtlsCAPtr != "" {
+		cas, err := mok.LoadCertPool(*mtlsCAPtr)
+		if err != nil {
+			errAndExit("mtls: " + err.Error())
+		}
+		opts = append(opts, mok.WithClientCerts(cas, mtlsFlag))
+	}
 
-func processFileArgs(args []string) []MokFile {
-	seen := make(map[string]struct{})
-	var files []MokFile
+	srv, err := mok.New(opts...)
+	if err != nil {
+		errAndExit(err.Error())
+	}
+
+	var l net.Listener
+	if *unixPtr != "" {
+		l, err = listenUnix(*unixPtr)
+		if err != nil {
+			errAndExit("http: " + err.Error())
+		}
+		srv.PrintSummary("unix:" + *unixPtr)
+		fmt.Printf("\n  try it with: curl --unix-socket %s %s://localhost/\n", *unixPtr, scheme)
+	} else {
+		addr := net.JoinHostPort(*hostPtr, strconv.Itoa(*portPtr))
+		l, err = net.Listen("tcp", addr)
+		if err != nil {
+			errAndExit("http: " + err.Error())
+		}
+		srv.PrintSummary(scheme + "://" + displayAddr(l.Addr().String()))
+	}
+
+	if *grpcPtr != "" {
+		gl, err := net.Listen("tcp", net.JoinHostPort(*hostPtr, strconv.Itoa(*grpcPortPtr)))
+		if err != nil {
+			errAndExit("grpc: " + err.Error())
+		}
+		fmt.Printf("\n  grpc is listening at %s\n", displayAddr(gl.Addr().String()))
+		go func() {
+			if err := srv.ServeGRPC(gl); err != nil {
+				errAndExit("grpc: " + err.Error())
+			}
+		}()
+	}
 
-	for _, arg := range args {
-		filePath, err := resolveFile(arg)
+	if *portPtr == 0 || *announcePtr != "" {
+		line, err := announcement(l.Addr(), scheme)
 		if err != nil {
 			errAndExit(err.Error())
 		}
+		if *portPtr == 0 && *unixPtr == "" {
+			// the port is only known now, harnesses read it from here
+			fmt.Printf("\n%s", line)
+		}
+		if *announcePtr != "" {
+			if err := writeAnnouncement(*announcePtr, line); err != nil {
+				errAndExit("announce: " + err.Error())
+			}
+		}
+	}
 
-		if _, exists := seen[filePath]; exists {
-			continue
+	// registered before serving so that an early ctrl-c is not lost
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go shutdownOnSignal(ctx, stop, srv)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reloadOnSignal(hup, srv)
+	if err := srv.Serve(l); err != nil {
+		errAndExit("http: " + err.Error())
+	}
+	// which fixtures the session exercised
+	srv.PrintStats()
+	if unexpected := srv.Unexpected(); len(unexpected) > 0 {
+		fmt.Fprintln(os.Stderr, "\n  unexpected requests:")
+		for _, u := range unexpected {
+			fmt.Fprintf(os.Stderr, "   %s %s  (%dx)\n", u.Method, u.Path, u.Count)
 		}
+		os.Exit(1)
+	}
+}
 
-		seen[filePath] = struct{}{}
-		files = append(files, MokFile{
-			FilePath: filePath,
-			URLPath:  "/" + filepath.Base(filePath),
-		})
+// shutdownOnSignal stops srv once ctx is done, on ctrl-c or SIGTERM, in-flight requests are
+// drained and downloaded files removed. a second signal kills mok right away.
+func shutdownOnSignal(ctx context.Context, stop context.CancelFunc, srv *mok.Server) {
+	<-ctx.Done()
+	stop()
+
+	fmt.Println("\n  shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), mok.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "shutdown: %s\n", err)
+	}
+}
+
+// reloadOnSignal reloads the routes of srv on every SIGHUP, the config and
+// the files are read again and remote files downloaded again while the
+// listener stays open.
+func reloadOnSignal(hup <-chan os.Signal, srv *mok.Server) {
+	for range hup {
+		if err := srv.Reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "reload: %s, still serving the previous routes\n", err)
+		}
 	}
+}
 
-	return files
+// listenUnix listens on the unix socket at path, a socket left behind by a
+// mok that crashed is replaced, one still accepting connections is not.
+// the socket is removed when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
 }
 
-func resolveFile(arg string) (string, error) {
-	// remote
-	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
-		file, err := downloadJSON(arg)
+// announcement describes where mok listens as a single json line:
+//
+//	{"url":"http://127.0.0.1:54321","host":"127.0.0.1","port":54321,"pid":4242}
+//	{"url":"unix:/tmp/mok.sock","unix":"/tmp/mok.sock","pid":4242}
+func announcement(addr net.Addr, scheme string) ([]byte, error) {
+	a := struct {
+		URL  string `json:"url"`
+		Host string `json:"host,omitempty"`
+		Port int    `json:"port,omitempty"`
+		Unix string `json:"unix,omitempty"`
+		PID  int    `json:"pid"`
+	}{PID: os.Getpid()}
+
+	if addr.Network() == "unix" {
+		a.URL, a.Unix = "unix:"+addr.String(), addr.String()
+	} else {
+		host, port, err := net.SplitHostPort(addr.String())
 		if err != nil {
-			return "", fmt.Errorf("downloading remote file: %w", err)
+			return nil, err
 		}
-		return file, nil
+		a.Host = host
+		a.Port, _ = strconv.Atoi(port)
+		a.URL = scheme + "://" + displayAddr(addr.String())
 	}
 
-	// local
-	info, err := os.Stat(arg)
+	line, err := json.Marshal(a)
 	if err != nil {
-		return "", fmt.Errorf("checking file: %w", err)
+		return nil, err
 	}
-	if info.IsDir() {
-		return "", fmt.Errorf("argument is a directory: %s", arg)
+	return append(line, '\n'), nil
+}
+
+// writeAnnouncement replaces file with line through a rename, whoever polls
+// it never reads half of it.
+func writeAnnouncement(file string, line []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".mok-announce-*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return arg, nil
+	if _, err := tmp.Write(line); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
 }
 
-func setupHandlers(directInput []byte, files []MokFile) {
-	tmpl := template.Must(template.New("").Parse(indexTemplate))
+// displayAddr is addr as a browser would reach it, the unspecified
+// addresses listen on every interface, localhost among them.
+func displayAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if len(directInput) > 0 {
-			serveDirectInput(w, directInput)
-			return
-		}
+// hasSources reports whether the flags or args give mok something to serve
+// other than stdin.
+func hasSources(args []string) bool {
+	return len(args) > 0 || inlineFlag.root != "" || len(inlineFlag.routes) > 0 || *configPtr != "" || *crudPtr != "" || *wiremockPtr != "" ||
+		*oidcPtr || *utilsPtr || *webhookPtr != "" || *staticPtr != "" || len(wsFlag) > 0 || len(rpcFlag) > 0 || len(vhostFlag) > 0 ||
+		*grpcPtr != "" || *graphqlPtr != ""
+}
 
-		if r.Header.Get("Accept") == "application/json" {
-			json.NewEncoder(w).Encode(files)
-			return
+// getDirectInput reads stdin when a file is redirected in, and a pipe when
+// readPipe is set: pipes are read until they are closed, and launchers
+// such as docker -i or node's spawn leave them open.
+func getDirectInput(readPipe bool) []byte {
+	// stdin first, when something is piped or redirected in
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		errAndExit("cannot read direct input: " + err.Error())
+	}
+
+	if fi.Mode()&os.ModeNamedPipe != 0 && readPipe || fi.Mode().IsRegular() {
+		directInput, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			errAndExit("cannot read direct input: " + err.Error())
+		}
+		if len(bytes.TrimSpace(directInput)) > 0 {
+			return directInput
 		}
+	}
 
-		tmpl.Execute(w, files)
-	})
+	// then `-s` flag
+	if inlineFlag.root != "" {
+		return []byte(inlineFlag.root)
+	}
 
-	for _, f := range files {
-		_, fileName := filepath.Split(f.FilePath)
+	return nil
+}
 
-		http.HandleFunc("/"+fileName, func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFile(w, r, f.FilePath)
-		})
+// followStdin reads the first json document of stdin, the ones after it
+// are read from the returned reader as they arrive.
+func followStdin() ([]byte, io.Reader) {
+	dec := json.NewDecoder(os.Stdin)
+	var first json.RawMessage
+	if err := dec.Decode(&first); err != nil {
+		errAndExit("-f needs json on stdin: " + err.Error())
 	}
+	return first, io.MultiReader(dec.Buffered(), os.Stdin)
 }
 
-func serveDirectInput(w http.ResponseWriter, input []byte) {
-	var dat map[string]any
-	if err := json.Unmarshal(input, &dat); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusInternalServerError)
-		return
+// splitDirectInput maps a stream of json documents to /0, /1 and so on,
+// and an object whose keys are all paths to a route per key:
+//
+//	{"/users": [...], "/health": {"ok": true}}
+//
+// routes are nil when input is a single document, it is served as it is.
+func splitDirectInput(input []byte) ([][2]string, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	var docs []json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(input))
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parsing direct input, document %d: %s", len(docs), err)
+		}
+		docs = append(docs, doc)
+	}
+
+	var routes [][2]string
+	if len(docs) > 1 {
+		for i, doc := range docs {
+			routes = append(routes, [2]string{"/" + strconv.Itoa(i), string(doc)})
+		}
+		return routes, nil
+	}
+
+	var byPath map[string]json.RawMessage
+	if json.Unmarshal(docs[0], &byPath) != nil || len(byPath) == 0 {
+		return nil, nil
+	}
+	for _, path := range slices.Sorted(maps.Keys(byPath)) {
+		if !strings.HasPrefix(path, "/") {
+			return nil, nil
+		}
+		routes = append(routes, [2]string{path, string(byPath[path])})
 	}
-	json.NewEncoder(w).Encode(dat)
+	return routes, nil
 }
@@ -0,0 +1,139 @@
+package mok
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogFormat is how WithAccessLog writes requests: "json" is one object
+// per line, "combined" the combined log format of apache and nginx, with
+// the duration in microseconds appended like %D.
+type LogFormat string
+
+const (
+	LogJSON     LogFormat = "json"
+	LogCombined LogFormat = "combined"
+)
+
+func (f LogFormat) String() string { return string(f) }
+
+// Set implements flag.Value.
+func (f *LogFormat) Set(s string) error {
+	switch format := LogFormat(strings.ToLower(strings.TrimSpace(s))); format {
+	case LogJSON, LogCombined:
+		*f = format
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q, expected json or combined", s)
+}
+
+// accessEntry is a line of the json access log.
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Remote     string    `json:"remote"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+
+	duration time.Duration
+}
+
+// withAccessLog writes every request to out once it is answered, the
+// requests of the admin api and of the dashboard are left out like in the
+// captured requests.
+func withAccessLog(next http.Handler, out io.Writer, format LogFormat) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/__mok__/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		duration := time.Since(start)
+
+		e := accessEntry{
+			Time:       start,
+			Remote:     r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Status:     cmp.Or(rec.status, http.StatusOK),
+			Bytes:      rec.bytes,
+			DurationMS: float64(duration.Microseconds()) / 1000,
+			UserAgent:  r.UserAgent(),
+			Referer:    r.Referer(),
+			duration:   duration,
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			e.Remote = host
+		}
+		var line []byte
+		if format == LogJSON {
+			line, _ = json.Marshal(e)
+			line = append(line, '\n')
+		} else {
+			line = combinedLine(e, r)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		out.Write(line)
+	})
+}
+
+// combinedLine is e in the combined log format.
+func combinedLine(e accessEntry, r *http.Request) []byte {
+	user, _, ok := r.BasicAuth()
+	if !ok || user == "" {
+		user = "-"
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = fmt.Sprint(e.Bytes)
+	}
+	return fmt.Appendf(nil, "%s - %s [%s] %q %d %s %q %q %d\n",
+		cmp.Or(e.Remote, "-"), user, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto, e.Status, size,
+		cmp.Or(e.Referer, "-"), cmp.Or(e.UserAgent, "-"), e.duration.Microseconds())
+}
+
+// countingWriter records the status and the size of the body.
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
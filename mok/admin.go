@@ -0,0 +1,164 @@
+package mok
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxRouteBody caps the size of routes posted to the admin API.
+const maxRouteBody = 1 << 20
+
+// registerAdmin mounts the admin API and the dashboard under /__mok__/.
+func (s *Server) registerAdmin(mux *http.ServeMux) {
+	handleAdmin(mux, "GET /__mok__/routes", s.listRoutes)
+	handleAdmin(mux, "POST /__mok__/routes", s.addRoute)
+	handleAdmin(mux, "DELETE /__mok__/routes", s.removeRoute)
+	handleAdmin(mux, "POST /__mok__/reload", s.reload)
+	handleAdmin(mux, "GET /__mok__/profile", s.getProfile)
+	handleAdmin(mux, "PUT /__mok__/profile", s.setProfile)
+	handleAdmin(mux, "POST /__mok__/shutdown", s.shutdown)
+	if !s.opts.noIndex {
+		handleAdmin(mux, "GET /__mok__/{$}", serveDashboard)
+		handleAdmin(mux, "GET /__mok__/dashboard.json", s.dashboardData)
+	}
+	handleAdmin(mux, "PUT /__mok__/overrides", s.setOverride)
+	handleAdmin(mux, "DELETE /__mok__/overrides", s.clearOverride)
+	handleAdmin(mux, "GET /__mok__/requests", s.serveRequests)
+	handleAdmin(mux, "DELETE /__mok__/requests", s.clearRequests)
+	handleAdmin(mux, "GET /__mok__/verify", s.verifyRequests)
+	handleAdmin(mux, "GET /__mok__/har", s.serveHAR)
+	handleAdmin(mux, "GET /__mok__/stats", s.serveStats)
+	handleAdmin(mux, "DELETE /__mok__/stats", s.clearStats)
+	if s.webhooks != nil {
+		handleAdmin(mux, "GET /__mok__/webhooks", s.webhooks.serveWebhooks)
+		handleAdmin(mux, "DELETE /__mok__/webhooks", s.webhooks.clear)
+	}
+}
+
+// adminProtection refuses the requests changing mok that other sites make
+// browsers send, whatever CORS allows: web pages must not add routes serving
+// local files or shut mok down. curl and test suites send no Origin.
+var adminProtection = http.NewCrossOriginProtection()
+
+// handleAdmin mounts an admin endpoint behind adminProtection.
+func handleAdmin(mux *http.ServeMux, pattern string, h http.HandlerFunc) {
+	mux.Handle(pattern, adminProtection.Handler(h))
+}
+
+// readAdminBody reads the body of an admin request, json or yaml only: a
+// form or text/plain body can be posted cross site without a preflight.
+func readAdminBody(r *http.Request) ([]byte, error) {
+	ctype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case ctype == "application/json", ctype == "application/yaml", ctype == "application/x-yaml", ctype == "text/yaml",
+		strings.HasSuffix(ctype, "+json"), strings.HasSuffix(ctype, "+yaml"):
+	default:
+		return nil, fmt.Errorf("expected a json or yaml body, got Content-Type %q", r.Header.Get("Content-Type"))
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRouteBody))
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	return body, nil
+}
+
+func (s *Server) listRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Routes())
+}
+
+// addRoute takes a route in the config format, as json or yaml, see
+// AddRoute.
+func (s *Server) addRoute(w http.ResponseWriter, r *http.Request) {
+	body, err := readAdminBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// yaml is a superset of json, one parser for both
+	var route RouteConfig
+	if err := yaml.Unmarshal(body, &route); err != nil {
+		http.Error(w, "parsing route: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.AddRoute(route); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errPattern) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	pattern := (&MokFile{URLPath: s.opts.prefix + route.Path, Host: strings.ToLower(route.Host), Method: strings.ToUpper(route.Method)}).pattern()
+	writeJSON(w, http.StatusCreated, s.route(pattern))
+}
+
+// removeRoute takes the route as ?path=/users&method=GET, see RemoveRoute.
+func (s *Server) removeRoute(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := s.RemoveRoute(query.Get("method"), query.Get("path")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errNoRoute) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if err := s.Reload(); err != nil {
+		http.Error(w, "reload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Routes())
+}
+
+type profileState struct {
+	Profile  string   `json:"profile" yaml:"profile"`
+	Profiles []string `json:"profiles,omitempty" yaml:"-"`
+}
+
+func (s *Server) getProfile(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.Profiles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, profileState{Profile: s.Profile(), Profiles: profiles})
+}
+
+// setProfile takes {"profile": "error-day"}, an empty one goes back to the
+// plain config, see SetProfile.
+func (s *Server) setProfile(w http.ResponseWriter, r *http.Request) {
+	body, err := readAdminBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	var state profileState
+	if err := yaml.Unmarshal(body, &state); err != nil {
+		http.Error(w, "parsing profile: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.SetProfile(state.Profile); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.getProfile(w, r)
+}
+
+// shutdown answers first and stops the server afterwards, Shutdown waits for
+// in-flight requests, including this one.
+func (s *Server) shutdown(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "shutting down"})
+	s.shutdownInBackground()
+}
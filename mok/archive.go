@@ -0,0 +1,125 @@
+package mok
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// .zip, .tar.gz and .tgz archives, local or remote, serve their fixtures
+// like a directory: fixtures.zip holding users/list.json serves
+// /users/list.json. they are read in memory, nothing is extracted.
+
+// archiveTypes are the content types of remote archives without an
+// archive extension, e.g. the artifacts of a CI.
+var archiveTypes = map[string]string{
+	"application/zip":              "zip",
+	"application/x-zip-compressed": "zip",
+	"application/gzip":             "tar.gz",
+	"application/x-gzip":           "tar.gz",
+	"application/x-tar+gzip":       "tar.gz",
+}
+
+// archiveKind is "zip" or "tar.gz" for the archives, after their name or
+// their content type, "" otherwise.
+func archiveKind(name, ctype string) string {
+	switch name = strings.ToLower(name); {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	}
+	mediaType, _, _ := mime.ParseMediaType(ctype)
+	return archiveTypes[mediaType]
+}
+
+// archiveFiles serves the fixtures of the archive data, their file paths
+// are under name.
+func archiveFiles(name, kind string, data []byte) ([]*MokFile, error) {
+	// fs.WalkDir cleans the paths it walks
+	name = path.Clean(filepath.ToSlash(name))
+	if kind == "tar.gz" {
+		var err error
+		if data, err = tarToZip(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	files, err := walkDir(archiveFS{name: name, fsys: zr}, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no fixtures in the archive", name)
+	}
+	return files, nil
+}
+
+// tarToZip repacks a tar.gz archive as an uncompressed zip, the fs.FS
+// of archive/zip then serves both.
+func tarToZip(data []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     strings.TrimPrefix(path.Clean("/"+hdr.Name), "/"),
+			Method:   zip.Store,
+			Modified: hdr.ModTime,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// archiveFS serves the files of an archive under its name, so that routes
+// show fixtures.zip/users/list.json rather than users/list.json.
+type archiveFS struct {
+	name string
+	fsys fs.FS
+}
+
+func (a archiveFS) Open(name string) (fs.File, error) {
+	rel, ok := strings.CutPrefix(name, a.name)
+	if !ok || (rel != "" && rel[0] != '/') {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if rel = strings.TrimPrefix(rel, "/"); rel == "" {
+		rel = "."
+	}
+	return a.fsys.Open(rel)
+}
@@ -0,0 +1,82 @@
+package mok
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// @fixtures.txt stands for the sources listed in fixtures.txt, one file,
+// directory or url per line, for fixture sets too large for the command
+// line:
+//
+//	# users
+//	users/
+//	https://api.example.com/orders  # the live orders
+//	@more.txt
+//
+// blank lines and comments are skipped, paths are relative to the list.
+
+// expandArgFiles replaces the @file arguments of args with the sources
+// they list, nested lists included. the list is read again on every
+// reload.
+func expandArgFiles(fsys fs.FS, args []string) ([]string, error) {
+	return expandArgs(fsys, args, nil)
+}
+
+func expandArgs(fsys fs.FS, args, reading []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		name, ok := strings.CutPrefix(arg, "@")
+		if !ok {
+			expanded = append(expanded, arg)
+			continue
+		}
+		name = joinPath(fsys, name)
+		for _, r := range reading {
+			if r == name {
+				return nil, fmt.Errorf("argument file %s lists itself", name)
+			}
+		}
+		listed, err := readArgFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if listed, err = expandArgs(fsys, listed, append(reading, name)); err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, listed...)
+	}
+	return expanded, nil
+}
+
+// readArgFile reads the sources listed in name.
+func readArgFile(fsys fs.FS, name string) ([]string, error) {
+	data, err := fs.ReadFile(fsys, fsPath(fsys, name))
+	if err != nil {
+		return nil, fmt.Errorf("reading argument file: %w", err)
+	}
+	baseDir := dirPath(fsys, name)
+	var args []string
+	for line := range strings.Lines(string(data)) {
+		// a comment starts a line or follows a space, urls keep their #fragment
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		nested, isList := strings.CutPrefix(line, "@")
+		switch {
+		case isRemote(nested) || isGit(nested) || filepath.IsAbs(nested):
+		case isList:
+			line = "@" + joinPath(fsys, baseDir, nested)
+		default:
+			line = joinPath(fsys, baseDir, line)
+		}
+		args = append(args, line)
+	}
+	return args, nil
+}
@@ -0,0 +1,139 @@
+package mok
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// withBasicAuth requires user and pass on every request, the admin API and
+// the dashboard included. preflights never get here when cors is on,
+// browsers don't send credentials with them.
+func withBasicAuth(next http.Handler, user, pass string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		// both compared in constant time, don't leak which one was wrong
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(p), []byte(pass)) == 1
+		if !ok || !userOK || !passOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mok", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AuthConfig makes a route require credentials, either a bearer token or
+// an api key in a header. requests without them get a 401, requests with
+// the wrong ones a 403, both can be answered with a fixture:
+//
+//	routes:
+//	  - path: /me
+//	    file: fixtures/me.json
+//	    auth:
+//	      bearer: secret-token # or header: X-Api-Key and key: abc
+//	      unauthorized:
+//	        file: fixtures/401.json
+//	      forbidden:
+//	        file: fixtures/403.json
+type AuthConfig struct {
+	Bearer       string          `yaml:"bearer,omitempty"`
+	Header       string          `yaml:"header,omitempty"`
+	Key          string          `yaml:"key,omitempty"`
+	Unauthorized *ResponseConfig `yaml:"unauthorized,omitempty"`
+	Forbidden    *ResponseConfig `yaml:"forbidden,omitempty"`
+}
+
+// routeAuth is the state of a route with auth, see AuthConfig.
+type routeAuth struct {
+	header string // where the credentials are, Authorization for bearer
+	want   string // the whole expected header value
+
+	unauthorized *MokFile
+	forbidden    *MokFile
+}
+
+func newRouteAuth(fsys fs.FS, remoteCfg *remoteConfig, urlPath string, cfg *AuthConfig, baseDir string) (*routeAuth, error) {
+	a := &routeAuth{}
+	switch {
+	case cfg.Bearer != "" && cfg.Header != "":
+		return nil, fmt.Errorf("auth: bearer and header cannot be used together")
+	case cfg.Bearer != "":
+		a.header, a.want = "Authorization", "Bearer "+cfg.Bearer
+	case cfg.Header != "" && cfg.Key != "":
+		a.header, a.want = cfg.Header, cfg.Key
+	default:
+		return nil, fmt.Errorf("auth: either bearer or header and key are required")
+	}
+
+	var err error
+	if a.unauthorized, err = authResponse(fsys, remoteCfg, urlPath, cfg.Unauthorized, http.StatusUnauthorized, baseDir); err != nil {
+		return nil, fmt.Errorf("auth unauthorized: %w", err)
+	}
+	if a.forbidden, err = authResponse(fsys, remoteCfg, urlPath, cfg.Forbidden, http.StatusForbidden, baseDir); err != nil {
+		return nil, fmt.Errorf("auth forbidden: %w", err)
+	}
+	return a, nil
+}
+
+// authResponse is the response to rejected credentials, status unless the
+// config says otherwise.
+func authResponse(fsys fs.FS, remoteCfg *remoteConfig, urlPath string, resp *ResponseConfig, status int, baseDir string) (*MokFile, error) {
+	if resp == nil {
+		return nil, nil
+	}
+	rc := *resp
+	if rc.Status == 0 {
+		rc.Status = status
+	}
+	return responseFile(fsys, remoteCfg, urlPath, rc, baseDir)
+}
+
+// allow serves the rejection and reports false when r lacks the expected
+// credentials.
+func (a *routeAuth) allow(w http.ResponseWriter, r *http.Request) bool {
+	got := r.Header.Get(a.header)
+	if a.header == "Authorization" {
+		// the scheme is case insensitive
+		if scheme, token, ok := strings.Cut(got, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			got = "Bearer " + token
+		}
+	}
+
+	switch {
+	case got == "":
+		if a.header == "Authorization" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mok"`)
+		}
+		a.reject(w, r, a.unauthorized, http.StatusUnauthorized)
+		return false
+	case subtle.ConstantTimeCompare([]byte(got), []byte(a.want)) != 1:
+		if a.header == "Authorization" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mok", error="invalid_token"`)
+		}
+		a.reject(w, r, a.forbidden, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (a *routeAuth) reject(w http.ResponseWriter, r *http.Request, f *MokFile, status int) {
+	if f != nil {
+		f.serve(w, r)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (a *routeAuth) files() []*MokFile {
+	var files []*MokFile
+	for _, f := range []*MokFile{a.unauthorized, a.forbidden} {
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
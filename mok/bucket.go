@@ -0,0 +1,424 @@
+package mok
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3://bucket/key.json and gs://bucket/key.json are objects of a bucket,
+// downloaded like remote urls with the credentials the cloud clis use:
+//
+//   - s3: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN,
+//     the AWS_PROFILE of ~/.aws/credentials or the container credentials
+//     of ecs, in AWS_REGION. AWS_ENDPOINT_URL_S3 points at minio & co.
+//   - gs: GOOGLE_OAUTH_ACCESS_TOKEN, the GOOGLE_APPLICATION_CREDENTIALS
+//     file or the application default credentials of gcloud, the metadata
+//     server otherwise. STORAGE_EMULATOR_HOST points at an emulator.
+//
+// without credentials the request is anonymous, for public buckets.
+
+// emptySHA256 is the hash of the empty body of a GET.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func isBucket(arg string) bool {
+	return strings.HasPrefix(arg, "s3://") || strings.HasPrefix(arg, "gs://")
+}
+
+// bucketRequest is the GET of the object at u, signed with the ambient
+// credentials.
+func bucketRequest(u *url.URL) (*http.Request, error) {
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("%s: expected %s://bucket/key", u, u.Scheme)
+	}
+	if u.Scheme == "s3" {
+		return s3Request(u.Host, key)
+	}
+	return gsRequest(u.Host, key)
+}
+
+// escapeKey escapes an object key like SigV4 expects, everything but the
+// unreserved characters and the slashes.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-._~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+type awsCredentials struct {
+	id, secret, token string
+	expires           time.Time
+}
+
+// containerCreds caches the credentials of the ecs endpoint until they
+// expire.
+var containerCreds struct {
+	sync.Mutex
+	creds *awsCredentials
+}
+
+func s3Request(bucket, key string) (*http.Request, error) {
+	region := cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	var target string
+	switch endpoint := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")); {
+	case endpoint != "":
+		target = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), bucket, escapeKey(key))
+	case strings.Contains(bucket, "."):
+		// virtual hosted buckets with dots fail the certificate check
+		target = fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", region, bucket, escapeKey(key))
+	default:
+		target = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, escapeKey(key))
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("aws credentials: %w", err)
+	}
+	if creds != nil {
+		signS3(req, creds, region, time.Now().UTC())
+	}
+	return req, nil
+}
+
+// signS3 signs req with AWS signature version 4.
+func signS3(req *http.Request, creds *awsCredentials, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	headers := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + emptySHA256 + "\nx-amz-date:" + amzDate + "\n"
+	if creds.token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.token)
+		signed += ";x-amz-security-token"
+		headers += "x-amz-security-token:" + creds.token + "\n"
+	}
+	canonical := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers, signed, emptySHA256,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + creds.secret)
+	for _, part := range []string{date, region, "s3", "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		creds.id, scope, signed, key))
+}
+
+// loadAWSCredentials finds the credentials in the environment, the shared
+// credentials file and the ecs endpoint, in this order. nil without any.
+func loadAWSCredentials() (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{id: id, secret: os.Getenv("AWS_SECRET_ACCESS_KEY"), token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if creds, err := awsSharedCredentials(); creds != nil || err != nil {
+		return creds, err
+	}
+
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		endpoint = "http://169.254.170.2" + uri
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	containerCreds.Lock()
+	defer containerCreds.Unlock()
+	if c := containerCreds.creds; c != nil && time.Until(c.expires) > time.Minute {
+		return c, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	var resp struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := getJSON(req, &resp); err != nil {
+		return nil, fmt.Errorf("container credentials: %w", err)
+	}
+	containerCreds.creds = &awsCredentials{id: resp.AccessKeyID, secret: resp.SecretAccessKey, token: resp.Token, expires: resp.Expiration}
+	return containerCreds.creds, nil
+}
+
+// awsSharedCredentials reads the AWS_PROFILE (default) section of
+// ~/.aws/credentials, or of AWS_SHARED_CREDENTIALS_FILE.
+func awsSharedCredentials() (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profile := cmp.Or(os.Getenv("AWS_PROFILE"), "default")
+	var creds awsCredentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch value = strings.TrimSpace(value); strings.TrimSpace(name) {
+		case "aws_access_key_id":
+			creds.id = value
+		case "aws_secret_access_key":
+			creds.secret = value
+		case "aws_session_token":
+			creds.token = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if creds.id == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// googleTokens caches the access token of the google credentials until it
+// expires.
+var googleTokens struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func gsRequest(bucket, key string) (*http.Request, error) {
+	endpoint := "https://storage.googleapis.com"
+	emulator := os.Getenv("STORAGE_EMULATOR_HOST")
+	if emulator != "" {
+		endpoint = strings.TrimSuffix(emulator, "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s/%s", endpoint, bucket, escapeKey(key)), nil)
+	if err != nil {
+		return nil, err
+	}
+	if emulator != "" {
+		return req, nil
+	}
+	token, err := googleToken()
+	if err != nil {
+		return nil, fmt.Errorf("google credentials: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// googleToken is an access token of the ambient google credentials, ""
+// without any.
+func googleToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	googleTokens.Lock()
+	defer googleTokens.Unlock()
+	if time.Until(googleTokens.expires) > time.Minute {
+		return googleTokens.token, nil
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	data, err := os.ReadFile(cmp.Or(path, gcloudCredentialsPath()))
+	switch {
+	case err == nil:
+		req, err := googleTokenRequest(data)
+		if err != nil {
+			return "", err
+		}
+		if err := getJSON(req, &resp); err != nil {
+			return "", fmt.Errorf("token exchange: %w", err)
+		}
+	case path != "":
+		return "", err
+	default:
+		// on google cloud the metadata server knows the service account
+		host := cmp.Or(os.Getenv("GCE_METADATA_HOST"), "metadata.google.internal")
+		req, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if getJSON(req.WithContext(ctx), &resp) != nil {
+			// anonymous, without asking again for every download
+			googleTokens.token, googleTokens.expires = "", time.Now().Add(time.Hour)
+			return "", nil
+		}
+	}
+	googleTokens.token = resp.AccessToken
+	googleTokens.expires = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return resp.AccessToken, nil
+}
+
+// gcloudCredentialsPath is where gcloud auth application-default login
+// stores the credentials.
+func gcloudCredentialsPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// googleTokenRequest exchanges a credentials file, of a service account
+// or of a gcloud user, for an access token.
+func googleTokenRequest(data []byte) (*http.Request, error) {
+	var creds struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("reading credentials: %w", err)
+	}
+	tokenURI := cmp.Or(creds.TokenURI, "https://oauth2.googleapis.com/token")
+
+	form := url.Values{}
+	switch creds.Type {
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("refresh_token", creds.RefreshToken)
+	case "service_account":
+		assertion, err := serviceAccountJWT(creds.ClientEmail, creds.PrivateKeyID, creds.PrivateKey, tokenURI)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	default:
+		return nil, fmt.Errorf("unsupported credentials type %q", creds.Type)
+	}
+	req, err := http.NewRequest(http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// serviceAccountJWT is the assertion of a service account asking for read
+// access to storage.
+func serviceAccountJWT(email, keyID, keyPEM, audience string) (string, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return "", errors.New("service account: no pem private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("service account: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("service account: not an rsa key")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   email,
+		"scope": "https://www.googleapis.com/auth/devstorage.read_only",
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := b64(header) + "." + b64(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + b64(sig), nil
+}
+
+// getJSON decodes the json answer of req, an error unless it is a 200. the
+// ambient credentials are the process's, they are fetched with the default
+// timeout.
+func getJSON(req *http.Request, v any) error {
+	resp, err := remoteClient(nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,31 @@
+package mok
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// setCaching sets Cache-Control and, for a non zero expires, Expires
+// relative to now. a negative expires dates the response in the past, an
+// easy way to make it stale right away.
+func setCaching(h http.Header, cacheControl string, expires time.Duration) {
+	if cacheControl != "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+	if expires != 0 {
+		h.Set("Expires", time.Now().Add(expires).UTC().Format(http.TimeFormat))
+	}
+}
+
+// withCaching sets the caching headers on every response, routes can still
+// override them since they are set before the route handler runs. the
+// admin API and the dashboard are never cached.
+func withCaching(next http.Handler, cacheControl string, expires time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/__mok__/") {
+			setCaching(w.Header(), cacheControl, expires)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
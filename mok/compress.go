@@ -0,0 +1,203 @@
+package mok
+
+import (
+	"cmp"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"gopkg.in/yaml.v3"
+)
+
+// Compression says when responses are compressed: "auto" negotiates with
+// Accept-Encoding, "always" compresses even when the client did not ask
+// for it (gzip then) and "never" sends responses as they are.
+type Compression string
+
+const (
+	CompressAuto   Compression = "auto"
+	CompressAlways Compression = "always"
+	CompressNever  Compression = "never"
+)
+
+func (c Compression) String() string { return string(c) }
+
+// Set implements flag.Value.
+func (c *Compression) Set(s string) error {
+	switch mode := Compression(strings.ToLower(strings.TrimSpace(s))); mode {
+	case CompressAuto, CompressAlways, CompressNever:
+		*c = mode
+		return nil
+	}
+	return fmt.Errorf("invalid compression %q, expected auto, always or never", s)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, true and false work as always
+// and never.
+func (c *Compression) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Value {
+	case "true":
+		return c.Set(string(CompressAlways))
+	case "false":
+		return c.Set(string(CompressNever))
+	}
+	return c.Set(value.Value)
+}
+
+type compressKey struct{}
+
+// compressPolicy travels in the request context, the route serving the
+// request can change the mode before anything is written.
+type compressPolicy struct {
+	mode Compression
+}
+
+// setCompression overrides the compression mode for r, see compressPolicy.
+func setCompression(r *http.Request, mode Compression) {
+	if p, ok := r.Context().Value(compressKey{}).(*compressPolicy); ok && mode != "" {
+		p.mode = mode
+	}
+}
+
+// withCompression compresses json and text responses with brotli or gzip,
+// whichever the client prefers.
+func withCompression(next http.Handler, mode Compression) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := &compressPolicy{mode: mode}
+		r = r.WithContext(context.WithValue(r.Context(), compressKey{}, policy))
+
+		cw := &compressWriter{ResponseWriter: w, r: r, policy: policy}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter decides whether to compress once the headers are known.
+type compressWriter struct {
+	http.ResponseWriter
+	r      *http.Request
+	policy *compressPolicy
+
+	wroteHeader bool
+	enc         io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if compressible(h.Get("Content-Type")) && h.Get("Content-Encoding") == "" {
+		h.Add("Vary", "Accept-Encoding")
+		// partial content would be compressed on its own, clients can't stitch it
+		if status >= 200 && status != http.StatusNoContent && status != http.StatusPartialContent &&
+			status != http.StatusNotModified && w.r.Method != http.MethodHead {
+			if encoding := w.encoding(); encoding != "" {
+				h.Set("Content-Encoding", encoding)
+				h.Del("Content-Length")
+				// the bytes differ from the uncompressed ones, like nginx does
+				if tag := h.Get("Etag"); strings.HasPrefix(tag, `"`) {
+					h.Set("Etag", "W/"+tag)
+				}
+				h.Del("Accept-Ranges")
+				if encoding == "br" {
+					w.enc = brotli.NewWriter(w.ResponseWriter)
+				} else {
+					w.enc = gzip.NewWriter(w.ResponseWriter)
+				}
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what was compressed so far, streaming responses keep working.
+func (w *compressWriter) Flush() {
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
+
+// encoding picks the content encoding for the response, empty for none.
+func (w *compressWriter) encoding() string {
+	switch w.policy.mode {
+	case CompressNever:
+		return ""
+	case CompressAlways:
+		return cmp.Or(acceptedEncoding(w.r.Header.Get("Accept-Encoding")), "gzip")
+	}
+	return acceptedEncoding(w.r.Header.Get("Accept-Encoding"))
+}
+
+// acceptedEncoding is the preferred of br and gzip in an Accept-Encoding
+// header, br wins ties.
+func acceptedEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			name = "br"
+		}
+		if (name != "br" && name != "gzip") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a content type is worth compressing, json
+// and text are, images and archives already are compressed.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" ||
+		strings.HasSuffix(mediaType, "+json") || mediaType == "application/javascript" ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+}
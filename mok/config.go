@@ -0,0 +1,409 @@
+package mok
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is picked up from the working directory by the mok
+// command when -c is not passed, so `mok` alone is enough in a project that
+// ships one.
+const DefaultConfigFile = "mok.yaml"
+
+// Config describes custom routes, it is loaded from yaml:
+//
+//	routes:
+//	  - path: /api/v1/users
+//	    file: fixtures/users.json
+//	    method: GET
+//	    status: 200
+//	    headers:
+//	      X-Total-Count: "2"
+//	    delay: 100ms±50ms
+//
+// a route with responses instead of a file is a scenario, every request
+// gets the next response in the list:
+//
+//	routes:
+//	  - path: /jobs/1
+//	    responses:
+//	      - status: 202
+//	      - file: fixtures/job-done.json
+//	      - status: 404
+//	    loop: true # start over after the last response, otherwise it sticks
+//
+// a route with rules picks the response by matching the request, the first
+// matching rule wins and the route file, if any, serves everything else:
+//
+//	routes:
+//	  - path: /rpc
+//	    method: POST
+//	    rules:
+//	      - match:
+//	          body:
+//	            $.type: premium
+//	        file: fixtures/premium.json
+//	    file: fixtures/basic.json
+//
+// a route with a host only answers requests for that Host, e.g. api.local,
+// the same path can be served differently for auth.local.
+//
+// a route with auth requires a bearer token or an api key, see AuthConfig,
+// a route with a schema (a JSON Schema file, yaml or json) answers POST,
+// PUT and PATCH bodies that do not match it with a 400 listing why,
+// a route with a rate_limit (e.g. 10/s) answers 429 once it is exceeded,
+// compress (auto, always or never) overrides the compression of a route,
+// cache_control and expires (e.g. 1h, negative for stale) its caching and
+// content_type the type guessed from the file, e.g. application/hal+json,
+// and format (xml or json) serves a json fixture as xml or the other way.
+// jq transforms the fixture before it is served, e.g. `.users | map({id})`,
+// so one fixture serves every shape clients need. echo: true instead of a
+// file answers with the request itself, method, path, query, headers and
+// body, as json.
+//
+// a route with websocket instead of a file upgrades the connection and
+// plays the script at that path, frames sent with delays and replies to
+// matching messages, or echoes with websocket: echo. a route with jsonrpc
+// answers JSON-RPC calls with the fixture named after the method in that
+// directory, e.g. eth_blockNumber.json.
+//
+// profiles are named variations of the config, selected with WithProfile
+// or at runtime, see Profile.
+type Config struct {
+	Routes   []RouteConfig      `yaml:"routes"`
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// Profile changes what the config serves while it is selected:
+//
+//	profiles:
+//	  error-day:
+//	    files: [fixtures/errors]
+//	    routes:
+//	      - path: /api/v1/users
+//	        status: 503
+//	    delay: 2s
+//	    status: 500
+//
+// files (files, directories or urls, relative to the config) and routes
+// replace the routes with the same method and path, delay applies to the
+// routes without one of their own and status to every route.
+type Profile struct {
+	Files  []string      `yaml:"files,omitempty"`
+	Routes []RouteConfig `yaml:"routes,omitempty"`
+	Delay  Delay         `yaml:"delay,omitempty"`
+	Status int           `yaml:"status,omitempty"`
+}
+
+type RouteConfig struct {
+	Path           string `yaml:"path"`
+	Method         string `yaml:"method,omitempty"`
+	Host           string `yaml:"host,omitempty"`
+	ResponseConfig `yaml:",inline"`
+
+	Responses []ResponseConfig `yaml:"responses,omitempty"`
+	Loop      bool             `yaml:"loop,omitempty"`
+
+	Rules []RuleConfig `yaml:"rules,omitempty"`
+
+	WebSocket string `yaml:"websocket,omitempty"`
+	JSONRPC   string `yaml:"jsonrpc,omitempty"`
+
+	Auth      *AuthConfig `yaml:"auth,omitempty"`
+	Schema    string      `yaml:"schema,omitempty"`
+	RateLimit RateLimit   `yaml:"rate_limit,omitempty"`
+	Compress  Compression `yaml:"compress,omitempty"`
+
+	CacheControl string        `yaml:"cache_control,omitempty"`
+	Expires      time.Duration `yaml:"expires,omitempty"`
+}
+
+type ResponseConfig struct {
+	File        string            `yaml:"file,omitempty"`
+	Status      int               `yaml:"status,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	ContentType string            `yaml:"content_type,omitempty"`
+	Format      string            `yaml:"format,omitempty"`
+	Delay       Delay             `yaml:"delay,omitempty"`
+	JQ          string            `yaml:"jq,omitempty"`
+	Echo        bool              `yaml:"echo,omitempty"`
+	Template    bool              `yaml:"template,omitempty"`
+}
+
+// loadConfig reads and parses the config at path.
+func loadConfig(fsys fs.FS, path string) (*Config, error) {
+	data, err := fs.ReadFile(fsys, fsPath(fsys, path))
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %q: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// configFiles turns config routes into served files, local files are
+// relative to the directory containing the config.
+func configFiles(fsys fs.FS, remoteCfg *remoteConfig, cfg *Config, cfgPath string) ([]*MokFile, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	baseDir := dirPath(fsys, cfgPath)
+
+	var files []*MokFile
+	for i, route := range cfg.Routes {
+		file, err := configRoute(fsys, remoteCfg, i, route, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// configRoute is the i-th route of a config, its errors name the route.
+func configRoute(fsys fs.FS, remoteCfg *remoteConfig, i int, route RouteConfig, baseDir string) (*MokFile, error) {
+	if route.Path == "" || !strings.HasPrefix(route.Path, "/") {
+		return nil, fmt.Errorf("route %d: path must start with /, got %q", i, route.Path)
+	}
+	file, err := routeFile(fsys, remoteCfg, route, baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %w", route.Path, err)
+	}
+	return file, nil
+}
+
+// profile looks the named profile up, cfg may be nil.
+func (cfg *Config) profile(name string) (*Profile, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("profile %q: profiles live in the config, there is none", name)
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, the config has %s", name, profileNames(cfg))
+	}
+	return &p, nil
+}
+
+func profileNames(cfg *Config) string {
+	if cfg == nil || len(cfg.Profiles) == 0 {
+		return "none"
+	}
+	return strings.Join(slices.Sorted(maps.Keys(cfg.Profiles)), ", ")
+}
+
+// fileArgs are the files of p, relative to the directory containing the
+// config.
+func (p *Profile) fileArgs(fsys fs.FS, cfgPath string) []string {
+	baseDir := dirPath(fsys, cfgPath)
+	args := make([]string, len(p.Files))
+	for i, arg := range p.Files {
+		if !isRemote(arg) && !isGit(arg) && !filepath.IsAbs(arg) {
+			arg = joinPath(fsys, baseDir, arg)
+		}
+		args[i] = arg
+	}
+	return args
+}
+
+// apply sets the delay and the status of p on files.
+func (p *Profile) apply(files []*MokFile) {
+	for _, f := range allFiles(files) {
+		if f.Delay.IsZero() {
+			f.Delay = p.Delay
+		}
+		if p.Status != 0 {
+			f.Status = p.Status
+		}
+	}
+}
+
+// routeFile builds the served file of a single route, local files are
+// relative to baseDir.
+func routeFile(fsys fs.FS, remoteCfg *remoteConfig, route RouteConfig, baseDir string) (*MokFile, error) {
+	var (
+		file *MokFile
+		err  error
+	)
+	switch {
+	case len(route.Responses) > 0 && len(route.Rules) > 0:
+		return nil, fmt.Errorf("responses and rules cannot be used together")
+	case route.WebSocket != "":
+		if route.File != "" || len(route.Responses) > 0 || len(route.Rules) > 0 {
+			return nil, fmt.Errorf("websocket cannot be used with file, responses or rules")
+		}
+		file, err = webSocketFile(fsys, route.Path, route.WebSocket, baseDir)
+	case route.JSONRPC != "":
+		if route.File != "" || len(route.Responses) > 0 || len(route.Rules) > 0 {
+			return nil, fmt.Errorf("jsonrpc cannot be used with file, responses or rules")
+		}
+		file, err = jsonRPCFile(fsys, route.Path, route.JSONRPC, baseDir)
+	case len(route.Responses) > 0:
+		file, err = scenarioFile(fsys, remoteCfg, route, baseDir)
+	case len(route.Rules) > 0:
+		file, err = rulesFile(fsys, remoteCfg, route, baseDir)
+	default:
+		if route.File == "" && !route.Echo {
+			return nil, fmt.Errorf("missing file")
+		}
+		file, err = responseFile(fsys, remoteCfg, route.Path, route.ResponseConfig, baseDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if route.Auth != nil {
+		if file.auth, err = newRouteAuth(fsys, remoteCfg, route.Path, route.Auth, baseDir); err != nil {
+			return nil, err
+		}
+	}
+	if route.Schema != "" {
+		schemaPath := route.Schema
+		if !filepath.IsAbs(schemaPath) {
+			schemaPath = joinPath(fsys, baseDir, schemaPath)
+		}
+		if file.schema, err = loadBodySchema(fsys, fsPath(fsys, schemaPath)); err != nil {
+			return nil, err
+		}
+	}
+
+	file.Method = strings.ToUpper(route.Method)
+	file.Host = strings.ToLower(route.Host)
+	file.RateLimit = route.RateLimit
+	file.Compress = route.Compress
+	file.CacheControl, file.Expires = route.CacheControl, route.Expires
+	return file, nil
+}
+
+func responseFile(fsys fs.FS, remoteCfg *remoteConfig, urlPath string, resp ResponseConfig, baseDir string) (*MokFile, error) {
+	if resp.Status != 0 && http.StatusText(resp.Status) == "" {
+		return nil, fmt.Errorf("invalid status %d", resp.Status)
+	}
+	if err := validFormat(resp.Format); err != nil {
+		return nil, err
+	}
+	if resp.Echo && resp.File != "" {
+		return nil, fmt.Errorf("echo and file cannot be used together")
+	}
+	if resp.Echo && resp.Template {
+		return nil, fmt.Errorf("echo and template cannot be used together")
+	}
+
+	filePath, temp := resp.File, false
+	var remote *remoteSource
+	if isRemote(filePath) {
+		var err error
+		if remote, err = downloadJSON(remoteCfg, filePath); err != nil {
+			return nil, fmt.Errorf("downloading remote file: %w", err)
+		}
+		filePath, fsys, temp = remote.path, osFS{}, remote.temp
+	} else if filePath != "" && !filepath.IsAbs(filePath) {
+		filePath = joinPath(fsys, baseDir, filePath)
+	}
+	filePath = fsPath(fsys, filePath)
+
+	if err := checkPlaceholders(urlPath, filePath); err != nil {
+		return nil, err
+	}
+	var jq *gojq.Code
+	if resp.JQ != "" {
+		var err error
+		if jq, err = compileJQ(resp.JQ); err != nil {
+			return nil, err
+		}
+	}
+
+	file := &MokFile{
+		FilePath:    filePath,
+		URLPath:     urlPath,
+		Status:      resp.Status,
+		Headers:     resp.Headers,
+		ContentType: resp.ContentType,
+		Format:      resp.Format,
+		Delay:       resp.Delay,
+		paramFile:   placeholderRe.MatchString(filePath),
+		fsys:        fsys,
+		temp:        temp,
+		remote:      remote,
+		JQ:          resp.JQ,
+		jq:          jq,
+		Template:    resp.Template,
+	}
+	if resp.Echo {
+		// answered with the request, there is no file
+		file.FilePath, file.Echo, file.inline = "echo", true, true
+		return file, nil
+	}
+	if err := file.load(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func scenarioFile(fsys fs.FS, remoteCfg *remoteConfig, route RouteConfig, baseDir string) (*MokFile, error) {
+	if route.File != "" {
+		return nil, fmt.Errorf("file and responses cannot be used together")
+	}
+
+	seq := &sequence{loop: route.Loop}
+	var sources []string
+	for i, resp := range route.Responses {
+		step, err := responseFile(fsys, remoteCfg, route.Path, resp, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("response %d: %w", i, err)
+		}
+		seq.steps = append(seq.steps, step)
+		sources = append(sources, cmp.Or(step.FilePath, strconv.Itoa(cmp.Or(step.Status, http.StatusOK))))
+	}
+
+	return &MokFile{
+		FilePath: "scenario: " + strings.Join(sources, ", "),
+		URLPath:  route.Path,
+		sequence: seq,
+	}, nil
+}
+
+func rulesFile(fsys fs.FS, remoteCfg *remoteConfig, route RouteConfig, baseDir string) (*MokFile, error) {
+	rs := &ruleSet{}
+	var sources []string
+	for i, rc := range route.Rules {
+		if err := rc.Match.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		file, err := responseFile(fsys, remoteCfg, route.Path, rc.ResponseConfig, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		rs.rules = append(rs.rules, rule{match: rc.Match, file: file})
+		sources = append(sources, cmp.Or(file.FilePath, strconv.Itoa(cmp.Or(file.Status, http.StatusOK))))
+	}
+
+	if route.File != "" {
+		fallback, err := responseFile(fsys, remoteCfg, route.Path, route.ResponseConfig, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		rs.fallback = fallback
+		sources = append(sources, "else "+fallback.FilePath)
+	}
+
+	return &MokFile{
+		FilePath: "rules: " + strings.Join(sources, ", "),
+		URLPath:  route.Path,
+		rules:    rs,
+	}, nil
+}
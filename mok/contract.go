@@ -0,0 +1,149 @@
+package mok
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// with WithContract the routes are checked against an OpenAPI document when
+// the server starts: every route has to be one of its operations, with a
+// declared status and a fixture matching the schema of the response. mock
+// data then drifts from the api it mocks loudly rather than quietly.
+
+// CheckContract lists where the served routes drift from the OpenAPI
+// document at spec: routes and statuses it does not describe and fixtures
+// not matching the schema of their response.
+func (s *Server) CheckContract(spec string) ([]Problem, error) {
+	doc, err := loadOpenAPI(s.opts.fsys, fsPath(s.opts.fsys, spec))
+	if err != nil {
+		return nil, err
+	}
+	doc.log = s.log
+	return checkContract(doc, s.Routes()), nil
+}
+
+func checkContract(doc *openAPIDoc, files []*MokFile) []Problem {
+	// the mux of the document tells which operation a route is
+	mux := http.NewServeMux()
+	operations := map[string]map[string]any{}
+	paths := asMap(doc.root["paths"])
+	for _, p := range slices.Sorted(maps.Keys(paths)) {
+		item := doc.resolve(asMap(paths[p]))
+		for _, method := range openAPIMethods {
+			op, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			pattern := strings.ToUpper(method) + " " + doc.basePath() + muxPath(p)
+			if register(mux, pattern) == nil {
+				operations[pattern] = asMap(op["responses"])
+			}
+		}
+	}
+
+	var problems []Problem
+	for _, f := range files {
+		// generated from a document, or not a fixture
+		if f.inline || f.direct || f.ws != nil || f.rpc != nil {
+			continue
+		}
+		method := cmp.Or(f.Method, http.MethodGet)
+		route := method + " " + f.URLPath
+		r := &http.Request{Method: method, Host: f.Host, URL: &url.URL{Path: samplePath(f.URLPath)}}
+		_, pattern := mux.Handler(r)
+		responses, ok := operations[pattern]
+		if !ok {
+			problems = append(problems, Problem{File: f.FilePath, Err: fmt.Sprintf("%s is not in %s", route, doc.path)})
+			continue
+		}
+
+		checked := variants(f)
+		if f.auth != nil {
+			checked = append(checked, f.auth.files()...)
+		}
+		for _, v := range checked {
+			status := cmp.Or(v.Status, http.StatusOK)
+			resp, ok := declaredResponse(responses, status)
+			if !ok {
+				problems = append(problems, Problem{File: v.FilePath, Err: fmt.Sprintf("%s: status %d is not declared", route, status)})
+				continue
+			}
+			schema, ok := doc.jsonSchema(resp)
+			if !ok {
+				continue
+			}
+			example, ok := v.jsonExample()
+			if !ok {
+				continue
+			}
+			for _, e := range doc.validate(schema, example) {
+				problems = append(problems, Problem{File: v.FilePath, Err: fmt.Sprintf("%s %d: %s", route, status, e)})
+			}
+		}
+	}
+	return problems
+}
+
+// samplePath is a path the pattern path p matches, its wildcards filled in.
+func samplePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		switch {
+		case s == "{$}":
+			segments[i] = ""
+		case wildcardRe.MatchString(s):
+			segments[i] = "1"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// declaredResponse is the response declared for status: its code, its
+// range (2XX) or the default one.
+func declaredResponse(responses map[string]any, status int) (any, bool) {
+	for _, code := range []string{strconv.Itoa(status), strconv.Itoa(status/100) + "XX", "default"} {
+		if resp, ok := responses[code]; ok {
+			return resp, true
+		}
+	}
+	return nil, false
+}
+
+// jsonSchema is the schema of the json content of a response.
+func (doc *openAPIDoc) jsonSchema(resp any) (map[string]any, bool) {
+	content := asMap(doc.resolve(asMap(resp))["content"])
+	for _, mt := range slices.Sorted(maps.Keys(content)) {
+		if isJSONType(mt) {
+			schema, ok := asMap(content[mt])["schema"].(map[string]any)
+			return schema, ok
+		}
+	}
+	return nil, false
+}
+
+// checkContract prints the drift from the contract of WithContract, it is
+// an error in strict mode.
+func (s *Server) checkContract() error {
+	problems, err := s.CheckContract(s.opts.contract)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	lines := make([]string, len(problems))
+	for i, p := range problems {
+		lines[i] = p.String()
+	}
+	if s.opts.contractStrict {
+		return fmt.Errorf("the routes drift from %s:\n  %s", s.opts.contract, strings.Join(lines, "\n  "))
+	}
+	fmt.Fprintf(s.opts.out, "  the routes drift from %s:\n    %s\n\n", s.opts.contract, strings.Join(lines, "\n    "))
+	return nil
+}
@@ -0,0 +1,321 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fixtures in other formats are converted to json when they are read, they
+// are served at the path of their json twin: users.yaml -> /users.json.
+
+// isYAML reports whether name is a yaml fixture.
+func isYAML(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// isJSONC reports whether name is a json fixture that may have comments and
+// trailing commas.
+func isJSONC(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jsonc", ".json5":
+		return true
+	}
+	return false
+}
+
+// isCSV reports whether name is a csv fixture, served as an array of
+// objects.
+func isCSV(name string) bool {
+	return strings.ToLower(path.Ext(name)) == ".csv"
+}
+
+// isConverted reports whether the fixture at name is served as json
+// converted from another format.
+func isConverted(name string) bool {
+	return isYAML(name) || isJSONC(name) || isCSV(name)
+}
+
+// isFixture reports whether a file found walking a directory is served.
+func isFixture(name string) bool {
+	return path.Ext(name) == ".json" || isConverted(name)
+}
+
+// jsonName is the name a converted fixture is served as.
+func jsonName(name string) string {
+	if !isConverted(name) {
+		return name
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + ".json"
+}
+
+// convertFixture turns the content of the fixture at name into json. json
+// files with comments or trailing commas are cleaned up as well, templates
+// and anything else that is not json are left alone. environment variables
+// are expanded first with env, see expandEnv.
+func convertFixture(name string, content []byte, env bool) ([]byte, error) {
+	if env {
+		content = expandEnv(name, content)
+	}
+	switch {
+	case isYAML(name):
+		return yamlToJSON(content)
+	case isCSV(name):
+		return csvToJSON(content)
+	case isJSONC(name), path.Ext(name) == ".json" && !json.Valid(content):
+		stripped := stripJSONC(content)
+		if !json.Valid(stripped) {
+			if isJSONC(name) {
+				return nil, fmt.Errorf("parsing json: %w", json.Unmarshal(stripped, new(any)))
+			}
+			return content, nil
+		}
+		// the comments leave holes behind, indent it again
+		var buf bytes.Buffer
+		json.Indent(&buf, stripped, "", "  ")
+		return buf.Bytes(), nil
+	}
+	return content, nil
+}
+
+// yamlToJSON converts a yaml fixture to indented json, nicer to write by
+// hand and identical on the wire.
+func yamlToJSON(content []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(content, &v); err != nil {
+		return nil, fmt.Errorf("parsing yaml: %w", err)
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// jsonValue turns the maps yaml decodes with non string keys into maps json
+// can encode, keys are formatted: {1: a} -> {"1": "a"}.
+func jsonValue(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = converted
+		}
+		return v, nil
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = converted
+		}
+		return m, nil
+	case []any:
+		for i, item := range v {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// csvToJSON turns the rows of a csv fixture into objects keyed by the
+// header row. numbers, true and false and empty cells (null) are inferred,
+// a type in the header pins a column, e.g. to keep zip codes as strings:
+//
+//	id,zip:string,active:bool,score:number,tags:json
+func csvToJSON(content []byte) ([]byte, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing csv: %w", err)
+	}
+
+	if len(rows) == 0 {
+		return []byte("[]"), nil
+	}
+
+	names := make([]string, len(rows[0]))
+	types := make([]string, len(rows[0]))
+	for i, h := range rows[0] {
+		names[i], types[i], _ = strings.Cut(strings.TrimSpace(h), ":")
+		switch types[i] {
+		case "", "string", "number", "bool", "json":
+		default:
+			return nil, fmt.Errorf("csv column %q: unknown type %q, expected string, number, bool or json", names[i], types[i])
+		}
+	}
+
+	// written by hand, maps would lose the order of the columns
+	var out bytes.Buffer
+	out.WriteByte('[')
+	for n, row := range rows[1:] {
+		if n > 0 {
+			out.WriteByte(',')
+		}
+		out.WriteByte('{')
+		for i, name := range names {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			v, err := csvValue(cell, types[i])
+			if err != nil {
+				return nil, fmt.Errorf("csv line %d, column %q: %w", n+2, name, err)
+			}
+			key, _ := json.Marshal(name)
+			value, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			out.Write(key)
+			out.WriteByte(':')
+			out.Write(value)
+		}
+		out.WriteByte('}')
+	}
+	out.WriteByte(']')
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
+
+// csvValue converts a cell to the column type, or infers one when there is
+// none.
+func csvValue(cell, typ string) (any, error) {
+	switch typ {
+	case "string":
+		return cell, nil
+	case "number":
+		if cell == "" {
+			return nil, nil
+		}
+		n := json.Number(strings.TrimSpace(cell))
+		if _, err := n.Float64(); err != nil {
+			return nil, fmt.Errorf("%q is not a number", cell)
+		}
+		return n, nil
+	case "bool":
+		if cell == "" {
+			return nil, nil
+		}
+		b, err := strconv.ParseBool(strings.TrimSpace(cell))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", cell)
+		}
+		return b, nil
+	case "json":
+		if cell == "" {
+			return nil, nil
+		}
+		var v any
+		if err := json.Unmarshal([]byte(cell), &v); err != nil {
+			return nil, fmt.Errorf("invalid json: %w", err)
+		}
+		return v, nil
+	}
+
+	switch cell {
+	case "":
+		return nil, nil
+	case "true", "false":
+		return cell == "true", nil
+	}
+	// json numbers only, 007 and 1e or 0x1f stay strings
+	if json.Valid([]byte(cell)) {
+		var n json.Number
+		if json.Unmarshal([]byte(cell), &n) == nil {
+			return n, nil
+		}
+	}
+	return cell, nil
+}
+
+// stripJSONC blanks // and /* */ comments and trailing commas outside of
+// strings, newlines stay so that errors keep their line and column.
+func stripJSONC(content []byte) []byte {
+	out := slices.Clone(content)
+	blank := func(from, to int) {
+		for j := from; j < to; j++ {
+			if out[j] != '\n' {
+				out[j] = ' '
+			}
+		}
+	}
+	for i := 0; i < len(out); i++ {
+		switch {
+		case out[i] == '"':
+			i = stringEnd(out, i) - 1
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			end := bytes.IndexByte(out[i:], '\n')
+			if end < 0 {
+				end = len(out) - i
+			}
+			blank(i, i+end)
+			i += end - 1
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(out)
+			} else {
+				end += i + 4
+			}
+			blank(i, end)
+			i = end - 1
+		}
+	}
+
+	// comments are gone, a comma followed by a closing bracket is trailing
+	for i := 0; i < len(out); i++ {
+		switch out[i] {
+		case '"':
+			i = stringEnd(out, i) - 1
+		case ',':
+			next := bytes.TrimLeft(out[i+1:], " \t\r\n")
+			if len(next) > 0 && (next[0] == '}' || next[0] == ']') {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
+
+// stringEnd returns the index after the json string starting at start.
+func stringEnd(content []byte, start int) int {
+	for i := start + 1; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(content)
+}
@@ -0,0 +1,60 @@
+package mok
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var corsMethods = strings.Join([]string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}, ", ")
+
+// withCORS allows cross origin requests and answers preflight requests
+// itself, registered routes never see them. without origins any origin may
+// read the responses, without credentials. the origins listed may send
+// credentials too, theirs is echoed since browsers refuse "*" with them,
+// other origins get no CORS headers at all.
+func withCORS(next http.Handler, origins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the admin API and the dashboard are for mok's own origin
+		if strings.HasPrefix(r.URL.Path, "/__mok__/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		if len(origins) == 0 {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if !slices.Contains(origins, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		h.Set("Access-Control-Expose-Headers", "*")
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Methods", corsMethods)
+		if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", reqHeaders)
+		} else {
+			h.Set("Access-Control-Allow-Headers", "*")
+		}
+		h.Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,329 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// crudStore is the in-memory database behind -crud, it is loaded from a json
+// file whose top-level arrays become collections:
+//
+//	{"posts": [{"id": 1, "title": "mok"}], "comments": []}
+//
+// every collection gets json-server style endpoints:
+//
+//	GET    /posts       list
+//	POST   /posts       create, ids are assigned automatically
+//	GET    /posts/{id}  read
+//	PUT    /posts/{id}  replace
+//	PATCH  /posts/{id}  merge
+//	DELETE /posts/{id}  delete
+//
+// related items are joined by foreign-key convention, see join.
+// changes live in memory only, the file is never written.
+type crudStore struct {
+	path string
+
+	mu          sync.Mutex
+	collections map[string][]map[string]any
+}
+
+func loadCRUDStore(path string, l *log.Logger) (*crudStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading crud file: %w", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing crud file %q: %w", path, err)
+	}
+
+	store := &crudStore{path: path, collections: make(map[string][]map[string]any)}
+	for name, value := range raw {
+		var items []map[string]any
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.UseNumber()
+		if err := dec.Decode(&items); err != nil {
+			logInfo(l, fmt.Sprintf("crud: skipping %q, not an array of objects", name))
+			continue
+		}
+		store.collections[name] = items
+	}
+
+	if len(store.collections) == 0 {
+		return nil, fmt.Errorf("crud file %q has no top-level arrays", path)
+	}
+	return store, nil
+}
+
+func (s *crudStore) names() []string {
+	return slices.Sorted(maps.Keys(s.collections))
+}
+
+func (s *crudStore) register(mux *http.ServeMux) {
+	for _, name := range s.names() {
+		base := "/" + name
+		mux.HandleFunc("GET "+base, s.list(name))
+		mux.HandleFunc("POST "+base, s.create(name))
+		mux.HandleFunc("GET "+base+"/{id}", s.read(name))
+		mux.HandleFunc("PUT "+base+"/{id}", s.update(name, false))
+		mux.HandleFunc("PATCH "+base+"/{id}", s.update(name, true))
+		mux.HandleFunc("DELETE "+base+"/{id}", s.delete(name))
+	}
+}
+
+func (s *crudStore) list(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		items, err := s.join(name, s.collections[name], r.URL.Query())
+		s.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// joined first, filters can look into related items
+		items, err = queryItems(w, r, items)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, items)
+	}
+}
+
+func (s *crudStore) read(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		i := s.find(name, r.PathValue("id"))
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, map[string]any{})
+			return
+		}
+		items, err := s.join(name, s.collections[name][i:i+1], r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mask, err := requestFields(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, mask.apply(items[0]))
+	}
+}
+
+func (s *crudStore) create(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		item, err := decodeItem(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if id, ok := item["id"]; ok {
+			if s.find(name, fmt.Sprint(id)) >= 0 {
+				http.Error(w, fmt.Sprintf("id %v already exists", id), http.StatusConflict)
+				return
+			}
+		} else {
+			item["id"] = s.nextID(name)
+		}
+		s.collections[name] = append(s.collections[name], item)
+
+		w.Header().Set("Location", fmt.Sprintf("/%s/%v", name, item["id"]))
+		writeJSON(w, http.StatusCreated, item)
+	}
+}
+
+func (s *crudStore) update(name string, merge bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		item, err := decodeItem(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		i := s.find(name, r.PathValue("id"))
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, map[string]any{})
+			return
+		}
+
+		current := s.collections[name][i]
+		if merge {
+			item = mergeItems(current, item)
+		}
+		// the id is part of the URL, never let the body change it
+		item["id"] = current["id"]
+		s.collections[name][i] = item
+
+		writeJSON(w, http.StatusOK, item)
+	}
+}
+
+func (s *crudStore) delete(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		i := s.find(name, r.PathValue("id"))
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, map[string]any{})
+			return
+		}
+		s.collections[name] = slices.Delete(s.collections[name], i, i+1)
+
+		writeJSON(w, http.StatusOK, map[string]any{})
+	}
+}
+
+// find returns the index of the item with id in collection name, or -1.
+// ids are compared as strings since they come from the URL.
+func (s *crudStore) find(name, id string) int {
+	return slices.IndexFunc(s.collections[name], func(item map[string]any) bool {
+		v, ok := item["id"]
+		return ok && fmt.Sprint(v) == id
+	})
+}
+
+// join adds the related items of ?_embed and ?_expand to items of the
+// collection name, comma separated or repeated. for posts:
+//
+//	?_embed=comments  the comments whose postId is the id of the post
+//	?_expand=author   the item of authors whose id is the authorId of the post
+//
+// s.mu must be held.
+func (s *crudStore) join(name string, items []map[string]any, q url.Values) ([]any, error) {
+	embeds, expands := listParam(q, "_embed"), listParam(q, "_expand")
+	for _, embed := range embeds {
+		if _, ok := s.collections[embed]; !ok {
+			return nil, fmt.Errorf("_embed: unknown collection %q, there are %s", embed, strings.Join(s.names(), ", "))
+		}
+	}
+	expanded := make([]string, len(expands))
+	for i, expand := range expands {
+		// author expands from authors
+		for _, coll := range []string{expand + "s", strings.TrimSuffix(expand, "y") + "ies", expand} {
+			if _, ok := s.collections[coll]; ok {
+				expanded[i] = coll
+				break
+			}
+		}
+		if expanded[i] == "" {
+			return nil, fmt.Errorf("_expand: no collection for %q, there are %s", expand, strings.Join(s.names(), ", "))
+		}
+	}
+
+	joined := make([]any, len(items))
+	foreignKey := singular(name) + "Id"
+	for i, item := range items {
+		if len(embeds) == 0 && len(expands) == 0 {
+			joined[i] = item
+			continue
+		}
+		// stored items are never changed in place, sharing them is fine
+		item = maps.Clone(item)
+		for _, embed := range embeds {
+			related := []any{}
+			for _, other := range s.collections[embed] {
+				if fk, ok := other[foreignKey]; ok && fmt.Sprint(fk) == fmt.Sprint(item["id"]) {
+					related = append(related, other)
+				}
+			}
+			item[embed] = related
+		}
+		for j, expand := range expands {
+			if fk, ok := item[expand+"Id"]; ok {
+				if k := s.find(expanded[j], fmt.Sprint(fk)); k >= 0 {
+					item[expand] = s.collections[expanded[j]][k]
+				}
+			}
+		}
+		joined[i] = item
+	}
+	return joined, nil
+}
+
+// listParam is a query parameter listing names, comma separated or
+// repeated.
+func listParam(q url.Values, key string) []string {
+	var names []string
+	for _, v := range q[key] {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// singular is the name of an item of a collection, post for posts and
+// category for categories.
+func singular(collection string) string {
+	if name, ok := strings.CutSuffix(collection, "ies"); ok {
+		return name + "y"
+	}
+	return strings.TrimSuffix(collection, "s")
+}
+
+// nextID is one more than the highest integer id in the collection.
+func (s *crudStore) nextID(name string) json.Number {
+	var highest int64
+	for _, item := range s.collections[name] {
+		n, ok := item["id"].(json.Number)
+		if !ok {
+			continue
+		}
+		if id, err := n.Int64(); err == nil && id > highest {
+			highest = id
+		}
+	}
+	return json.Number(fmt.Sprint(highest + 1))
+}
+
+func decodeItem(r *http.Request) (map[string]any, error) {
+	var item map[string]any
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&item); err != nil {
+		return nil, fmt.Errorf("invalid JSON object: %w", err)
+	}
+	if item == nil {
+		return nil, fmt.Errorf("invalid JSON object: null")
+	}
+	return item, nil
+}
+
+// mergeItems applies patch on top of a copy of item, the way PATCH does.
+func mergeItems(item, patch map[string]any) map[string]any {
+	merged := maps.Clone(item)
+	maps.Copy(merged, patch)
+	return merged
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,203 @@
+package mok
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardRequests is how many of the captured requests the dashboard
+// shows.
+const dashboardRequests = 100
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+// override replaces the status and delay of a route at runtime, a zero
+// status serves the route as usual.
+type override struct {
+	status   int
+	delay    Delay
+	hasDelay bool
+}
+
+type delayOverrideKey struct{}
+
+// delayFor is the delay to apply to r, the route override wins over the
+// configured one, also for the steps of scenarios and rules.
+func delayFor(r *http.Request, configured Delay) Delay {
+	if d, ok := r.Context().Value(delayOverrideKey{}).(Delay); ok {
+		return d
+	}
+	return configured
+}
+
+// handle serves a route, counting hits, enforcing its rate limit, checking
+// credentials and applying its override.
+func (f *MokFile) handle(w http.ResponseWriter, r *http.Request) {
+	f.hits.Add(1)
+	setCompression(r, f.Compress)
+	setCaching(w.Header(), f.CacheControl, f.Expires)
+
+	if f.limiter != nil && !f.limiter.allow(w, r) {
+		return
+	}
+	if f.auth != nil && !f.auth.allow(w, r) {
+		return
+	}
+	if f.schema != nil && !f.schema.allow(w, r) {
+		return
+	}
+
+	o := f.override.Load()
+	if o == nil {
+		f.serve(w, r)
+		return
+	}
+
+	if o.hasDelay {
+		r = r.WithContext(context.WithValue(r.Context(), delayOverrideKey{}, o.delay))
+	}
+	if o.status == 0 {
+		f.serve(w, r)
+		return
+	}
+
+	delayFor(r, f.Delay).sleep(r.Context())
+	http.Error(w, http.StatusText(o.status), o.status)
+}
+
+// statusRecorder remembers the status written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, the
+// fallback proxy flushes through it.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type dashboardRoute struct {
+	Route    string             `json:"route"`
+	Source   string             `json:"source"`
+	Status   int                `json:"status,omitempty"`
+	Hits     int64              `json:"hits"`
+	Override *dashboardOverride `json:"override,omitempty"`
+}
+
+type dashboardOverride struct {
+	Status int    `json:"status,omitempty"`
+	Delay  string `json:"delay,omitempty"`
+}
+
+func (s *Server) dashboardData(w http.ResponseWriter, r *http.Request) {
+	routes := []dashboardRoute{}
+	for _, f := range s.Routes() {
+		route := dashboardRoute{
+			Route:  f.pattern(),
+			Source: f.FilePath,
+			Status: f.Status,
+			Hits:   f.hits.Load(),
+		}
+		if o := f.override.Load(); o != nil {
+			route.Override = &dashboardOverride{Status: o.status}
+			if o.hasDelay {
+				route.Override.Delay = o.delay.String()
+			}
+		}
+		routes = append(routes, route)
+	}
+
+	requests := s.requests.list()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"routes":   routes,
+		"requests": requests[:min(dashboardRequests, len(requests))],
+	})
+}
+
+// setOverride takes {"route": "GET /users", "status": 500, "delay": "2s"},
+// a delay of 0 turns the delay of the route off.
+func (s *Server) setOverride(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Route  string  `json:"route"`
+		Status int     `json:"status"`
+		Delay  *string `json:"delay"`
+	}
+	data, err := readAdminBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		http.Error(w, "parsing override: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Status != 0 && http.StatusText(body.Status) == "" {
+		http.Error(w, fmt.Sprintf("invalid status %d", body.Status), http.StatusBadRequest)
+		return
+	}
+
+	o := &override{status: body.Status}
+	if body.Delay != nil {
+		d, err := parseDelay(*body.Delay)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		o.delay, o.hasDelay = d, true
+	}
+
+	f := s.route(body.Route)
+	if f == nil {
+		http.Error(w, fmt.Sprintf("no route %s", body.Route), http.StatusNotFound)
+		return
+	}
+	f.override.Store(o)
+	logInfo(s.log, fmt.Sprintf("dashboard: override for %s", body.Route))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearOverride takes the route as ?route=GET /users.
+func (s *Server) clearOverride(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+	f := s.route(route)
+	if f == nil {
+		http.Error(w, fmt.Sprintf("no route %s", route), http.StatusNotFound)
+		return
+	}
+	f.override.Store(nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// route finds the served file with the given pattern.
+func (s *Server) route(pattern string) *MokFile {
+	for _, f := range s.Routes() {
+		if f.pattern() == pattern {
+			return f
+		}
+	}
+	return nil
+}
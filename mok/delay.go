@@ -0,0 +1,100 @@
+package mok
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Delay is a response latency, either fixed ("100ms") or jittered
+// ("100ms±50ms", or the ascii "100ms+-50ms") in which case every request
+// waits a random duration in [Base-Jitter, Base+Jitter].
+type Delay struct {
+	Base   time.Duration
+	Jitter time.Duration
+}
+
+func parseDelay(s string) (Delay, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Delay{}, nil
+	}
+
+	base, jitter, found := strings.Cut(s, "±")
+	if !found {
+		base, jitter, found = strings.Cut(s, "+-")
+	}
+
+	var d Delay
+	var err error
+	if d.Base, err = time.ParseDuration(strings.TrimSpace(base)); err != nil {
+		return Delay{}, fmt.Errorf("invalid delay %q: %w", s, err)
+	}
+	if found {
+		if d.Jitter, err = time.ParseDuration(strings.TrimSpace(jitter)); err != nil {
+			return Delay{}, fmt.Errorf("invalid delay jitter %q: %w", s, err)
+		}
+	}
+	if d.Base < 0 || d.Jitter < 0 {
+		return Delay{}, fmt.Errorf("invalid delay %q: must not be negative", s)
+	}
+
+	return d, nil
+}
+
+// IsZero reports whether there is no delay, yaml omitempty relies on it.
+func (d Delay) IsZero() bool { return d.Base == 0 && d.Jitter == 0 }
+
+func (d Delay) String() string {
+	if d.Jitter == 0 {
+		return d.Base.String()
+	}
+	return d.Base.String() + "±" + d.Jitter.String()
+}
+
+// Set implements flag.Value.
+func (d *Delay) Set(s string) error {
+	parsed, err := parseDelay(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Delay) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Delay) UnmarshalYAML(value *yaml.Node) error {
+	return d.Set(value.Value)
+}
+
+func (d Delay) duration() time.Duration {
+	if d.Jitter == 0 {
+		return d.Base
+	}
+	dur := d.Base - d.Jitter + rand.N(2*d.Jitter+1)
+	return max(dur, 0)
+}
+
+// sleep waits for the delay or until the client goes away.
+func (d Delay) sleep(ctx context.Context) {
+	dur := d.duration()
+	if dur <= 0 {
+		return
+	}
+
+	t := time.NewTimer(dur)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
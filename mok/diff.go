@@ -0,0 +1,138 @@
+package mok
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// Diff requests the GET routes from target, the live api the fixtures stand
+// in for, and lists where its responses drift from the fixtures: statuses,
+// fields missing on either side and values changing type. header is sent
+// with every request, e.g. the Authorization of the api. the other methods
+// are not requested, they could change the live api. checked is how many
+// routes were requested.
+func (s *Server) Diff(ctx context.Context, target string, header http.Header) (problems []Problem, checked int, err error) {
+	base, err := url.Parse(target)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, 0, fmt.Errorf("invalid target %q, expected a url such as https://api.example.com", target)
+	}
+	client := remoteClient(s.remote)
+	for _, f := range s.Routes() {
+		if f.inline || f.direct || f.ws != nil || f.rpc != nil {
+			continue
+		}
+		if f.Method != "" && f.Method != http.MethodGet {
+			continue
+		}
+		fixture := plainResponse(f)
+		if fixture == nil {
+			continue
+		}
+		checked++
+		route := "GET " + f.URLPath
+		drift, err := diffRoute(ctx, client, base.JoinPath(samplePath(f.URLPath)), header, fixture)
+		if err != nil {
+			problems = append(problems, Problem{File: fixture.FilePath, Err: route + ": " + err.Error()})
+			continue
+		}
+		for _, d := range drift {
+			problems = append(problems, Problem{File: fixture.FilePath, Err: route + ": " + d})
+		}
+	}
+	return problems, checked, nil
+}
+
+// plainResponse is the response of f to a request matching no rule, the
+// first step of a scenario, nil when no rule catches it.
+func plainResponse(f *MokFile) *MokFile {
+	switch {
+	case f.sequence != nil:
+		return f.sequence.steps[0]
+	case f.rules != nil:
+		return f.rules.fallback
+	}
+	return f
+}
+
+// diffRoute requests u and compares the response with fixture, the shape of
+// the bodies only when the statuses agree.
+func diffRoute(ctx context.Context, client *http.Client, u *url.URL, header http.Header, fixture *MokFile) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header.Clone()
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if want := cmp.Or(fixture.Status, http.StatusOK); resp.StatusCode != want {
+		return []string{fmt.Sprintf("status %d live, %d in the fixture", resp.StatusCode, want)}, nil
+	}
+	example, ok := fixture.jsonExample()
+	if !ok {
+		return nil, nil
+	}
+	if ctype := resp.Header.Get("Content-Type"); !isJSONType(ctype) {
+		return []string{fmt.Sprintf("the live response is not json (%s)", cmp.Or(ctype, "no Content-Type"))}, nil
+	}
+	var live any
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&live); err != nil {
+		return nil, fmt.Errorf("decoding the live response: %w", err)
+	}
+
+	var errs []schemaError
+	shapeDiff("$", example, live, &errs)
+	drift := make([]string, len(errs))
+	for i, e := range errs {
+		drift[i] = e.String()
+	}
+	return drift, nil
+}
+
+// shapeDiff compares the structure of two decoded values, not their values:
+// the fields of objects, the types and the items of arrays, by their first
+// item. null goes with every type, optional fields often are.
+func shapeDiff(at string, fixture, live any, errs *[]schemaError) {
+	if fixture == nil || live == nil {
+		return
+	}
+	if ft, lt := jsonType(fixture), jsonType(live); ft != lt {
+		*errs = append(*errs, schemaError{Path: at, Err: fmt.Sprintf("%s live, %s in the fixture", lt, ft)})
+		return
+	}
+	switch fixture := fixture.(type) {
+	case map[string]any:
+		live := live.(map[string]any)
+		for _, name := range slices.Sorted(maps.Keys(fixture)) {
+			if v, ok := live[name]; ok {
+				shapeDiff(fieldPath(at, name), fixture[name], v, errs)
+			} else {
+				*errs = append(*errs, schemaError{Path: fieldPath(at, name), Err: "missing live"})
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(live)) {
+			if _, ok := fixture[name]; !ok {
+				*errs = append(*errs, schemaError{Path: fieldPath(at, name), Err: "missing in the fixture"})
+			}
+		}
+	case []any:
+		live := live.([]any)
+		if len(fixture) > 0 && len(live) > 0 {
+			shapeDiff(at+"[0]", fixture[0], live[0], errs)
+		}
+	}
+}
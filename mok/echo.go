@@ -0,0 +1,86 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+)
+
+// echoedRequest is a request the way /echo and the routes with echo answer
+// it, for seeing what a client really sends.
+type echoedRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   map[string]any    `json:"query"`
+	Headers map[string]string `json:"headers"`
+	// Body is parsed when it is json or a form, a string otherwise,
+	// base64 encoded when it is not text.
+	Body         any    `json:"body"`
+	BodyEncoding string `json:"body_encoding,omitempty"`
+}
+
+// serveEcho answers /echo with the request.
+func serveEcho(w http.ResponseWriter, r *http.Request) {
+	content, err := echoRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// echoRequest describes r as json, see echoedRequest.
+func echoRequest(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMatchBody))
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	echo := echoedRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   flatten(r.URL.Query()),
+		Headers: requestHeaders(r),
+	}
+
+	ctype, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case len(body) == 0:
+	case isJSONType(ctype) && json.Valid(body):
+		echo.Body = json.RawMessage(body)
+	case ctype == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("parsing form: %w", err)
+		}
+		echo.Body = flatten(form)
+	case ctype == "multipart/form-data":
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(maxMatchBody)
+		if err != nil {
+			return nil, fmt.Errorf("parsing multipart body: %w", err)
+		}
+		defer form.RemoveAll()
+		fields := flatten(form.Value)
+		// files by their name and size, not their content
+		for name, headers := range form.File {
+			files := make([]map[string]any, len(headers))
+			for i, h := range headers {
+				files[i] = map[string]any{"filename": h.Filename, "size": h.Size}
+			}
+			fields[name] = files
+		}
+		echo.Body = fields
+	case utf8.Valid(body):
+		echo.Body = string(body)
+	default:
+		echo.Body, echo.BodyEncoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	return json.Marshal(echo)
+}
@@ -0,0 +1,89 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+)
+
+// local fixtures are read with their environment variables expanded, so
+// tokens, hosts and ids change per environment without editing files:
+//
+//	{"token": "${API_TOKEN}", "host": "${API_HOST:-localhost}"}
+//
+// ${NAME:-default} falls back to default when NAME is unset or empty,
+// ${NAME} without a default is left alone when NAME is unset and $${NAME}
+// is a literal ${NAME}. values within json strings are escaped, elsewhere
+// they are inserted as they are. downloaded fixtures are not expanded, see
+// MokFile.local: they would read the secrets of the environment.
+
+var envRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces the environment variables in content, the fixture at
+// name.
+func expandEnv(name string, content []byte) []byte {
+	if !bytes.Contains(content, []byte("${")) {
+		return content
+	}
+	// yaml and csv quote strings their own way
+	quoting := !isYAML(name) && !isCSV(name)
+
+	var out []byte
+	var str stringScan
+	last := 0
+	for _, loc := range envRe.FindAllSubmatchIndex(content, -1) {
+		if quoting {
+			str.scan(content[last:loc[0]])
+		}
+		out = append(out, content[last:loc[0]]...)
+		last = loc[1]
+
+		m := content[loc[0]:loc[1]]
+		if m[1] == '$' {
+			out = append(out, m[1:]...)
+			continue
+		}
+		value, set := os.LookupEnv(string(content[loc[2]:loc[3]]))
+		switch {
+		case loc[4] < 0 && set, value != "":
+			if quoting && str.in {
+				out = append(out, escapeJSON(value)...)
+			} else {
+				out = append(out, value...)
+			}
+		case loc[4] >= 0:
+			out = append(out, content[loc[6]:loc[7]]...)
+		default:
+			out = append(out, m...)
+		}
+	}
+	return append(out, content[last:]...)
+}
+
+// stringScan follows whether a scan of json is within a string.
+type stringScan struct {
+	in, escaped bool
+}
+
+func (s *stringScan) scan(b []byte) {
+	for _, c := range b {
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case s.in && c == '\\':
+			s.escaped = true
+		case c == '"':
+			s.in = !s.in
+		}
+	}
+}
+
+// escapeJSON is s as the content of a json string, without the quotes.
+func escapeJSON(s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return bytes.TrimSuffix(buf.Bytes()[1:], []byte("\"\n"))
+}
@@ -0,0 +1,300 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serveOpenAPI describes the served routes as an OpenAPI 3 document,
+// schemas are inferred from the fixtures themselves.
+func serveOpenAPI(files []*MokFile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, buildOpenAPI(files))
+	}
+}
+
+func buildOpenAPI(files []*MokFile) map[string]any {
+	paths := map[string]any{}
+
+	for _, f := range files {
+		p := openAPIPath(f.URLPath)
+		item, _ := paths[p].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[p] = item
+		}
+
+		// routes without a method answer anything, GET is the honest subset
+		method := strings.ToLower(cmp.Or(f.Method, http.MethodGet))
+		op := map[string]any{
+			"operationId": method + strings.Map(operationIDRune, p),
+			"responses":   openAPIResponses(f),
+		}
+		if params := openAPIParams(f.URLPath); len(params) > 0 {
+			op["parameters"] = params
+		}
+		item[method] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "mok",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+	}
+}
+
+// openAPIPath turns a ServeMux pattern path back into an OpenAPI path.
+func openAPIPath(p string) string {
+	p = strings.TrimSuffix(p, "{$}")
+	return strings.ReplaceAll(p, "...}", "}")
+}
+
+func operationIDRune(r rune) rune {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return r
+	}
+	return '_'
+}
+
+func openAPIParams(urlPath string) []any {
+	var params []any
+	for _, name := range patternWildcards(urlPath) {
+		params = append(params, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	return params
+}
+
+// variants are the responses of a route, the steps of a scenario and the
+// files of rules included.
+func variants(f *MokFile) []*MokFile {
+	switch {
+	case f.sequence != nil:
+		return f.sequence.steps
+	case f.rules != nil:
+		return f.rules.files()
+	}
+	return []*MokFile{f}
+}
+
+func openAPIResponses(f *MokFile) map[string]any {
+	responses := map[string]any{}
+	for _, v := range variants(f) {
+		code := strconv.Itoa(cmp.Or(v.Status, http.StatusOK))
+		if _, exists := responses[code]; exists {
+			continue
+		}
+
+		resp := map[string]any{"description": cmp.Or(http.StatusText(cmp.Or(v.Status, http.StatusOK)), "response")}
+		if example, ok := v.jsonExample(); ok {
+			resp["content"] = map[string]any{
+				"application/json": map[string]any{
+					"schema":  inferSchema(example),
+					"example": example,
+				},
+			}
+		}
+		responses[code] = resp
+	}
+	return responses
+}
+
+// jsonExample decodes the loaded content, templates and per request files
+// have no static example.
+func (f *MokFile) jsonExample() (any, bool) {
+	f.mu.RLock()
+	content := f.content
+	f.mu.RUnlock()
+
+	if len(content) == 0 || f.Template && isTemplate(content) {
+		return nil, false
+	}
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// inferSchema derives a json schema from an example value, arrays are
+// described by their first item.
+func inferSchema(v any) map[string]any {
+	switch v := v.(type) {
+	case map[string]any:
+		props := map[string]any{}
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			props[k] = inferSchema(v[k])
+		}
+		return map[string]any{"type": "object", "properties": props}
+	case []any:
+		schema := map[string]any{"type": "array", "items": map[string]any{}}
+		if len(v) > 0 {
+			schema["items"] = inferSchema(v[0])
+		}
+		return schema
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return map[string]any{"type": "integer"}
+		}
+		return map[string]any{"type": "number"}
+	case float64:
+		return map[string]any{"type": "number"}
+	case bool:
+		return map[string]any{"type": "boolean"}
+	case string:
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{"nullable": true}
+	}
+}
+
+// Export describes the served routes in format: openapi, a document with
+// the schemas inferred from the fixtures, postman, a collection with a
+// saved response per fixture, or har, an exchange per fixture. baseURL is
+// where the requests go, {{baseUrl}} in the postman collection.
+func (s *Server) Export(format, baseURL string, w io.Writer) error {
+	var doc any
+	switch format {
+	case "openapi":
+		doc = buildOpenAPI(s.Routes())
+	case "postman":
+		doc = buildPostman(s.Routes(), baseURL)
+	case "har":
+		doc = map[string]any{"log": map[string]any{
+			"version": "1.2",
+			"creator": map[string]string{"name": "mok", "version": ""},
+			"entries": buildHAREntries(s.Routes(), baseURL),
+		}}
+	default:
+		return fmt.Errorf("cannot export %q, expected openapi, postman or har", format)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// exported is a response of a route as the exports show it.
+func exported(v *MokFile) (status int, header http.Header, body []byte) {
+	v.mu.RLock()
+	body = v.content
+	v.mu.RUnlock()
+
+	header = http.Header{}
+	for k, val := range v.Headers {
+		header.Set(k, val)
+	}
+	if header.Get("Content-Type") == "" && len(body) > 0 {
+		header.Set("Content-Type", cmp.Or(v.ContentType, contentType(v.FilePath, body)))
+	}
+	return cmp.Or(v.Status, http.StatusOK), header, body
+}
+
+func buildPostman(files []*MokFile, baseURL string) map[string]any {
+	items := []any{}
+	for _, f := range files {
+		method := cmp.Or(f.Method, http.MethodGet)
+		// postman path variables are :id
+		var segments []string
+		for _, s := range strings.Split(strings.TrimPrefix(openAPIPath(f.URLPath), "/"), "/") {
+			if name, ok := strings.CutPrefix(s, "{"); ok {
+				s = ":" + strings.TrimSuffix(name, "}")
+			}
+			segments = append(segments, s)
+		}
+		request := map[string]any{
+			"method": method,
+			"url": map[string]any{
+				"raw":  "{{baseUrl}}/" + strings.Join(segments, "/"),
+				"host": []string{"{{baseUrl}}"},
+				"path": segments,
+			},
+		}
+
+		var responses []any
+		for _, v := range variants(f) {
+			status, header, body := exported(v)
+			var headers []any
+			for _, k := range slices.Sorted(maps.Keys(header)) {
+				headers = append(headers, map[string]string{"key": k, "value": header.Get(k)})
+			}
+			responses = append(responses, map[string]any{
+				"name":            cmp.Or(v.FilePath, strconv.Itoa(status)),
+				"originalRequest": request,
+				"code":            status,
+				"status":          http.StatusText(status),
+				"header":          headers,
+				"body":            string(body),
+			})
+		}
+		items = append(items, map[string]any{
+			"name":     method + " " + openAPIPath(f.URLPath),
+			"request":  request,
+			"response": responses,
+		})
+	}
+
+	return map[string]any{
+		"info": map[string]any{
+			"name":   "mok",
+			"schema": "https://schema.getpostman.com/json/collection/v2.1.0/collection.json",
+		},
+		"item":     items,
+		"variable": []any{map[string]string{"key": "baseUrl", "value": strings.TrimSuffix(baseURL, "/")}},
+	}
+}
+
+func buildHAREntries(files []*MokFile, baseURL string) []harEntry {
+	entries := []harEntry{}
+	now := time.Now()
+	for _, f := range files {
+		for _, v := range variants(f) {
+			status, header, body := exported(v)
+			entries = append(entries, harEntry{
+				StartedDateTime: now,
+				Request: harRequest{
+					Method:      cmp.Or(f.Method, http.MethodGet),
+					URL:         strings.TrimSuffix(baseURL, "/") + openAPIPath(f.URLPath),
+					HTTPVersion: "HTTP/1.1",
+					Cookies:     []harNameValue{},
+					Headers:     []harNameValue{},
+					QueryString: []harNameValue{},
+					HeadersSize: -1,
+				},
+				Response: harResponse{
+					Status:      status,
+					StatusText:  http.StatusText(status),
+					HTTPVersion: "HTTP/1.1",
+					Cookies:     []harNameValue{},
+					Headers:     harHeaders(header),
+					Content:     harBody(body, header.Get("Content-Type")),
+					HeadersSize: -1,
+					BodySize:    len(body),
+				},
+			})
+		}
+	}
+	return entries
+}
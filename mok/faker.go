@@ -0,0 +1,120 @@
+package mok
+
+import (
+	"crypto/rand"
+	"fmt"
+	"maps"
+	mrand "math/rand/v2"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	firstNames = []string{
+		"Ada", "Alan", "Barbara", "Brian", "Dennis", "Edsger", "Frances", "Grace",
+		"Hedy", "Jean", "John", "Ken", "Linus", "Margaret", "Niklaus", "Radia",
+		"Rob", "Robert", "Russ", "Sophie", "Tim", "Werner", "Yukihiro", "Donald",
+	}
+	lastNames = []string{
+		"Allen", "Backus", "Berners-Lee", "Dijkstra", "Goldberg", "Griesemer",
+		"Hamilton", "Hopper", "Kernighan", "Knuth", "Lamarr", "Liskov", "Lovelace",
+		"Matsumoto", "Perlman", "Pike", "Ritchie", "Stroustrup", "Thompson",
+		"Torvalds", "Turing", "Wilson", "Wirth", "Cox",
+	}
+	cities = []string{
+		"Amsterdam", "Berlin", "Bologna", "Buenos Aires", "Cape Town", "Lisbon",
+		"Melbourne", "Milan", "Montreal", "Munich", "Nairobi", "New York", "Osaka",
+		"Paris", "Seoul", "Stockholm", "Tokyo", "Zurich",
+	}
+	domains    = []string{"example.com", "example.org", "example.net", "mok.dev"}
+	loremWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing
+		elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua ut
+		enim ad minim veniam quis nostrud exercitation ullamco laboris nisi ut
+		aliquip ex ea commodo consequat duis aute irure dolor in reprehenderit in
+		voluptate velit esse cillum dolore eu fugiat nulla pariatur excepteur sint
+		occaecat cupidatat non proident sunt in culpa qui officia deserunt mollit
+		anim id est laborum`)
+)
+
+// fakerFuncs generate random data inside templates, a list of fake users:
+//
+//	[{{ range $i := repeat 3 }}{{ if $i }},{{ end }}
+//	  {"id": {{ add $i 1 }}, "name": "{{ name }}", "email": "{{ email }}"}
+//	{{ end }}]
+var fakerFuncs = template.FuncMap{
+	"firstName": func() string { return pick(firstNames) },
+	"lastName":  func() string { return pick(lastNames) },
+	"name":      func() string { return pick(firstNames) + " " + pick(lastNames) },
+	"email":     fakeEmail,
+	"city":      func() string { return pick(cities) },
+	"uuid":      uuid,
+	"now":       func() string { return time.Now().UTC().Format(time.RFC3339) },
+	// date is a random moment within the last year
+	"date": func() string {
+		ago := mrand.N(365 * 24 * time.Hour)
+		return time.Now().Add(-ago).UTC().Format(time.RFC3339)
+	},
+	"int":       func(lo, hi int) int { return lo + mrand.IntN(max(hi-lo, 0)+1) },
+	"float":     func(lo, hi float64) float64 { return lo + mrand.Float64()*(hi-lo) },
+	"bool":      func() bool { return mrand.IntN(2) == 0 },
+	"pick":      func(values ...any) any { return pick(values) },
+	"lorem":     func(n int) string { return lorem(n) },
+	"sentence":  func() string { return sentence(4 + mrand.IntN(8)) },
+	"paragraph": paragraph,
+	// repeat returns 0..n-1, to range over when generating arrays
+	"repeat": func(n int) []int {
+		s := make([]int, max(n, 0))
+		for i := range s {
+			s[i] = i
+		}
+		return s
+	},
+	"add": func(a, b int) int { return a + b },
+}
+
+func init() {
+	maps.Copy(templateFuncs, fakerFuncs)
+}
+
+func pick[T any](values []T) T {
+	return values[mrand.IntN(len(values))]
+}
+
+func fakeEmail() string {
+	user := strings.ToLower(pick(firstNames) + "." + pick(lastNames))
+	return strings.ReplaceAll(user, "-", "") + "@" + pick(domains)
+}
+
+// uuid returns a random version 4 uuid.
+func uuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func lorem(n int) string {
+	words := make([]string, max(n, 0))
+	for i := range words {
+		words[i] = pick(loremWords)
+	}
+	return strings.Join(words, " ")
+}
+
+func sentence(n int) string {
+	s := lorem(n)
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:] + "."
+}
+
+func paragraph() string {
+	sentences := make([]string, 3+mrand.IntN(4))
+	for i := range sentences {
+		sentences[i] = sentence(4 + mrand.IntN(8))
+	}
+	return strings.Join(sentences, " ")
+}
@@ -0,0 +1,142 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// fieldMask is a parsed ?fields=, Google style partial responses: the
+// selected fields of an object and the ones selected below them, a nil
+// mask keeps a field whole.
+//
+//	?fields=id,name,address.city   a.b and a/b select b within a
+//	?fields=items(id,author/name)  a(b,c) selects b and c within a
+//
+// arrays have the fields of their items selected.
+type fieldMask map[string]fieldMask
+
+// requestFields is the mask of ?fields=, nil when there is none.
+func requestFields(r *http.Request) (fieldMask, error) {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		return nil, nil
+	}
+	p := fieldParser{s: fields}
+	mask := fieldMask{}
+	if err := p.list(mask); err != nil {
+		return nil, err
+	}
+	if p.i < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.i])
+	}
+	return mask, nil
+}
+
+// apply keeps the fields of v selected by m, the ones v does not have are
+// left out.
+func (m fieldMask) apply(v any) any {
+	if m == nil {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(m))
+		for name, sub := range m {
+			if field, ok := v[name]; ok {
+				out[name] = sub.apply(field)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = m.apply(item)
+		}
+		return out
+	}
+	return v
+}
+
+// selectFields applies the ?fields= of r to a json document, anything else
+// is served as it is.
+func selectFields(r *http.Request, content []byte) ([]byte, error) {
+	mask, err := requestFields(r)
+	if err != nil || mask == nil {
+		return content, err
+	}
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil {
+		return content, nil
+	}
+	return json.Marshal(mask.apply(v))
+}
+
+type fieldParser struct {
+	s string
+	i int
+}
+
+func (p *fieldParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid fields %q at %d: %s", p.s, p.i, fmt.Sprintf(format, args...))
+}
+
+// list parses comma separated fields into m, up to a closing parenthesis
+// or the end.
+func (p *fieldParser) list(m fieldMask) error {
+	for {
+		if err := p.field(m); err != nil {
+			return err
+		}
+		if p.i == len(p.s) || p.s[p.i] != ',' {
+			return nil
+		}
+		p.i++
+	}
+}
+
+// field parses a field and what it selects below, .b, /b or (b,c), into m.
+func (p *fieldParser) field(m fieldMask) error {
+	start := p.i
+	for p.i < len(p.s) && !strings.ContainsRune(",()./", rune(p.s[p.i])) {
+		p.i++
+	}
+	name := strings.TrimSpace(p.s[start:p.i])
+	if name == "" {
+		return p.errorf("expected a field name")
+	}
+
+	var next byte
+	if p.i < len(p.s) {
+		next = p.s[p.i]
+	}
+	if next != '.' && next != '/' && next != '(' {
+		m[name] = nil
+		return nil
+	}
+	p.i++
+
+	sub, ok := m[name]
+	if !ok {
+		sub = fieldMask{}
+		m[name] = sub
+	} else if sub == nil {
+		// kept whole already, what is below is parsed and dropped
+		sub = fieldMask{}
+	}
+	if next != '(' {
+		return p.field(sub)
+	}
+	if err := p.list(sub); err != nil {
+		return err
+	}
+	if p.i == len(p.s) || p.s[p.i] != ')' {
+		return p.errorf("expected )")
+	}
+	p.i++
+	return nil
+}
@@ -0,0 +1,51 @@
+package mok
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// osFS reads the files of the operating system with paths used as they
+// are, relative to the working directory or absolute. unlike os.DirFS it is
+// not rooted anywhere, it is what mok reads from unless WithFS is used.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+func (osFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+func (osFS) ReadFile(name string) ([]byte, error) { return os.ReadFile(name) }
+
+func isOS(fsys fs.FS) bool {
+	_, ok := fsys.(osFS)
+	return ok
+}
+
+// fsPath turns a path from the command line or a config into a name fsys
+// accepts, names in an fs.FS are slash separated and unrooted.
+func fsPath(fsys fs.FS, name string) string {
+	if isOS(fsys) {
+		return name
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+// joinPath joins path elements the way fsys expects them.
+func joinPath(fsys fs.FS, elem ...string) string {
+	if isOS(fsys) {
+		return filepath.Join(elem...)
+	}
+	return path.Join(elem...)
+}
+
+// dirPath is the directory of name the way fsys expects it.
+func dirPath(fsys fs.FS, name string) string {
+	if isOS(fsys) {
+		return filepath.Dir(name)
+	}
+	return path.Dir(name)
+}
@@ -0,0 +1,151 @@
+package mok
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxGenerated caps the payloads of /bytes and /json, they are streamed but
+// a typo should not keep a client busy for hours.
+const maxGenerated = 10e9
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"gb", 1e9},
+	{"mb", 1e6},
+	{"kb", 1e3},
+	{"b", 1},
+}
+
+// parseSize reads a payload size in bytes, 1024 or with a unit, 64KB or
+// 10MB, powers of 1000 like Bandwidth.
+func parseSize(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if num, found := strings.CutSuffix(lower, u.suffix); found {
+			lower, unit = strings.TrimSpace(num), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 1024, 64KB or 10MB", s)
+	}
+	if size := n * float64(unit); size <= maxGenerated {
+		return int64(size), nil
+	}
+	return 0, fmt.Errorf("size %q is over 10GB", s)
+}
+
+// generator is the source of the random payloads of r, reproducible with
+// ?seed=.
+func generator(r *http.Request) (*rand.ChaCha8, error) {
+	var seed [32]byte
+	if s := r.URL.Query().Get("seed"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed %q", s)
+		}
+		binary.LittleEndian.PutUint64(seed[:], n)
+	} else {
+		binary.LittleEndian.PutUint64(seed[:], rand.Uint64())
+		binary.LittleEndian.PutUint64(seed[8:], rand.Uint64())
+	}
+	return rand.NewChaCha8(seed), nil
+}
+
+// serveBytes streams n random bytes, or the text of ?fill= repeated.
+func serveBytes(w http.ResponseWriter, r *http.Request) {
+	n, err := parseSize(r.PathValue("n"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var src io.Reader
+	if fill := r.URL.Query().Get("fill"); fill != "" {
+		src = &repeatReader{pattern: []byte(fill)}
+	} else if src, err = generator(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	if r.Method != http.MethodHead {
+		io.CopyN(w, src, n)
+	}
+}
+
+// repeatReader reads pattern over and over.
+type repeatReader struct {
+	pattern []byte
+	off     int
+}
+
+func (rr *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], rr.pattern[rr.off:])
+		n += copied
+		rr.off = (rr.off + copied) % len(rr.pattern)
+	}
+	return n, nil
+}
+
+// serveJSON streams a json array of made up objects of exactly n bytes,
+// whitespace makes up for the last object that does not fit.
+func serveJSON(w http.ResponseWriter, r *http.Request) {
+	n, err := parseSize(r.PathValue("n"))
+	if err == nil && n < 2 {
+		err = fmt.Errorf("a json array takes at least 2 bytes, []")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	src, err := generator(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	rng := rand.New(src)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	bw.WriteByte('[')
+	written := int64(1)
+	var item []byte
+	for id := 1; ; id++ {
+		item = item[:0]
+		if id > 1 {
+			item = append(item, ',')
+		}
+		item = fmt.Appendf(item, `{"id":%d,"name":"item-%08x","score":%.4f,"active":%t}`,
+			id, rng.Uint32(), rng.Float64()*100, rng.IntN(2) == 1)
+		// room for the closing bracket
+		if written+int64(len(item))+1 > n {
+			break
+		}
+		if _, err := bw.Write(item); err != nil {
+			return
+		}
+		written += int64(len(item))
+	}
+	for ; written < n-1; written++ {
+		bw.WriteByte(' ')
+	}
+	bw.WriteByte(']')
+}
@@ -0,0 +1,141 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// git+https://github.com/acme/fixtures.git#mocks/users@v2 serves the
+// mocks/users directory of the v2 branch, tag or commit of the repository,
+// cloned shallowly by the git command into a temp directory. without a
+// path the whole repository is served, without a ref its default branch.
+// git+ssh:// and git+file:// work too, with the credentials of git.
+//
+// with WithRefresh the ref is polled, the routes are rebuilt when it moves.
+
+// gitCheckout is a shallow clone of a git source, removed with its files.
+type gitCheckout struct {
+	repo, ref string
+	dir       string
+	commit    string
+}
+
+var commitRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+func isGit(arg string) bool {
+	return strings.HasPrefix(arg, "git+")
+}
+
+// parseGitSource splits arg in the repository, the path inside it and the
+// ref.
+func parseGitSource(arg string) (repo, subdir, ref string, err error) {
+	repo, fragment, _ := strings.Cut(strings.TrimPrefix(arg, "git+"), "#")
+	if i := strings.LastIndex(fragment, "@"); i >= 0 {
+		fragment, ref = fragment[:i], fragment[i+1:]
+	}
+	if repo == "" {
+		return "", "", "", fmt.Errorf("%s: expected git+<repository>#<path>@<ref>", arg)
+	}
+	// git would take them for options, --upload-pack=... runs commands
+	if strings.HasPrefix(repo, "-") || strings.HasPrefix(ref, "-") {
+		return "", "", "", fmt.Errorf("%s: repository and ref cannot start with -", arg)
+	}
+	// the path stays inside the repository
+	return repo, strings.TrimPrefix(path.Clean("/"+fragment), "/"), ref, nil
+}
+
+// gitFiles clones the repository of arg and serves the files at its path.
+func gitFiles(remoteCfg *remoteConfig, arg string) ([]*MokFile, error) {
+	repo, subdir, ref, err := parseGitSource(arg)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "mok-git-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	co := &gitCheckout{repo: repo, ref: ref, dir: dir}
+	logInfo(remoteCfg.logger(), fmt.Sprintf("cloning: %q", co.repo))
+	if err := co.clone(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("cloning %s: %w", repo, err)
+	}
+
+	files, err := resolveFile(osFS{}, remoteCfg, filepath.Join(dir, filepath.FromSlash(subdir)))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("%s: %w", arg, err)
+	}
+	for _, f := range files {
+		f.checkout = co
+	}
+	return files, nil
+}
+
+// clone fetches the ref, and nothing before it, into the checkout.
+func (co *gitCheckout) clone() error {
+	if _, err := git(co.dir, "init", "-q"); err != nil {
+		return err
+	}
+	if _, err := git(co.dir, "fetch", "-q", "--depth", "1", "--", co.repo, cmp.Or(co.ref, "HEAD")); err != nil {
+		return err
+	}
+	if _, err := git(co.dir, "checkout", "-q", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	commit, err := git(co.dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	co.commit = commit
+	// the history is not needed anymore, and not walked either
+	return os.RemoveAll(filepath.Join(co.dir, ".git"))
+}
+
+// moved reports whether the ref points to another commit than the checkout,
+// commits never move.
+func (co *gitCheckout) moved() (bool, error) {
+	if commitRe.MatchString(co.ref) {
+		return false, nil
+	}
+	out, err := git("", "ls-remote", "--", co.repo, cmp.Or(co.ref, "HEAD"))
+	if err != nil {
+		return false, err
+	}
+	commit := ""
+	for line := range strings.Lines(out) {
+		hash, name, _ := strings.Cut(strings.TrimSpace(line), "\t")
+		// the commit of an annotated tag is on its peeled line
+		if commit == "" || strings.HasSuffix(name, "^{}") {
+			commit = hash
+		}
+	}
+	if commit == "" {
+		return false, fmt.Errorf("%s: no ref %s", co.repo, cmp.Or(co.ref, "HEAD"))
+	}
+	return commit != co.commit, nil
+}
+
+// git runs the git command in dir, never prompting for credentials.
+func git(dir string, args ...string) (string, error) {
+	name := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
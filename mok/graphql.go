@@ -0,0 +1,568 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	mrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// the graphql mock serves /graphql from a schema, queries and mutations
+// are answered with the data of a json fixture keyed by root field:
+//
+//	{"user": [{"id": "1", "name": "rob"}, {"id": "2", "name": "ann"}]}
+//
+// a field that is not a list picks the item matching its arguments, so
+// user(id: "2") is ann. whatever the data leaves out is made up from the
+// field type and name, introspection works as usual.
+
+// graphQL answers graphql requests.
+type graphQL struct {
+	fsys   fs.FS
+	schema *ast.Schema
+	data   string
+}
+
+func loadGraphQL(fsys fs.FS, schemaPath, data string) (*graphQL, error) {
+	input, err := fs.ReadFile(fsys, fsPath(fsys, schemaPath))
+	if err != nil {
+		return nil, fmt.Errorf("reading graphql schema: %w", err)
+	}
+	schema, err := gqlparser.LoadSchema(&ast.Source{Name: schemaPath, Input: string(input)})
+	if err != nil {
+		return nil, fmt.Errorf("parsing graphql schema: %w", err)
+	}
+	return &graphQL{fsys: fsys, schema: schema, data: data}, nil
+}
+
+func (g *graphQL) register(mux *http.ServeMux) {
+	mux.Handle("/graphql", g)
+}
+
+// gqlRequest is a graphql request, as json in POST bodies or in the query
+// string of GETs.
+type gqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+func (g *graphQL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req gqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				http.Error(w, "invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.Query = string(body)
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid graphql request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, errs := g.execute(req)
+	resp := map[string]any{"data": data}
+	if errs != nil {
+		resp = map[string]any{"errors": errs}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// execute runs the operation of req, errors are the ones graphql clients
+// expect in the errors field.
+func (g *graphQL) execute(req gqlRequest) (any, gqlerror.List) {
+	doc, errs := gqlparser.LoadQuery(g.schema, req.Query)
+	if errs != nil {
+		return nil, errs
+	}
+	op := doc.Operations.ForName(req.OperationName)
+	if op == nil {
+		return nil, gqlerror.List{gqlerror.Errorf("operation %q not found", req.OperationName)}
+	}
+	vars, err := validator.VariableValues(g.schema, op, req.Variables)
+	if err != nil {
+		return nil, gqlerror.List{gqlerror.WrapIfUnwrapped(err)}
+	}
+
+	root := g.schema.Query
+	switch op.Operation {
+	case ast.Mutation:
+		root = g.schema.Mutation
+	case ast.Subscription:
+		return nil, gqlerror.List{gqlerror.Errorf("subscriptions are not supported")}
+	}
+
+	data, err := g.fixtures()
+	if err != nil {
+		return nil, gqlerror.List{gqlerror.WrapIfUnwrapped(err)}
+	}
+	e := &gqlExec{schema: g.schema, vars: vars}
+	return e.selectionSet(root, op.SelectionSet, data), nil
+}
+
+// fixtures reads the data, on every request so that edits show up right
+// away.
+func (g *graphQL) fixtures() (map[string]any, error) {
+	data := map[string]any{}
+	if g.data == "" {
+		return data, nil
+	}
+	content, err := fs.ReadFile(g.fsys, fsPath(g.fsys, g.data))
+	if err != nil {
+		return nil, fmt.Errorf("reading graphql data: %w", err)
+	}
+	if content, err = convertFixture(g.data, content, true); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("parsing graphql data %q: %w", g.data, err)
+	}
+	return data, nil
+}
+
+// gqlExec resolves an operation against fixture data.
+type gqlExec struct {
+	schema *ast.Schema
+	vars   map[string]any
+}
+
+// gqlObject is a result object, graphql keeps the order of the query.
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value any
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, e := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.key)
+		value, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// gqlIntro is introspection data, the fields it leaves out are null rather
+// than made up.
+type gqlIntro map[string]any
+
+// gqlResolver computes a field from its arguments.
+type gqlResolver func(args map[string]any) any
+
+func (e *gqlExec) selectionSet(typ *ast.Definition, set ast.SelectionSet, parent any) gqlObject {
+	obj := gqlObject{}
+	for _, group := range e.collect(typ, set, nil) {
+		obj = append(obj, gqlEntry{group.key, e.field(typ, group, parent)})
+	}
+	return obj
+}
+
+// gqlField is the fields of a selection set sharing a response key, their
+// selections are merged.
+type gqlField struct {
+	key   string
+	field *ast.Field
+	set   ast.SelectionSet
+}
+
+// collect flattens fragments and drops what @skip and @include exclude.
+func (e *gqlExec) collect(typ *ast.Definition, set ast.SelectionSet, fields []*gqlField) []*gqlField {
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if !e.included(sel.Directives) {
+				continue
+			}
+			key := cmp.Or(sel.Alias, sel.Name)
+			i := slices.IndexFunc(fields, func(f *gqlField) bool { return f.key == key })
+			if i < 0 {
+				fields = append(fields, &gqlField{key: key, field: sel})
+				i = len(fields) - 1
+			}
+			fields[i].set = append(fields[i].set, sel.SelectionSet...)
+		case *ast.InlineFragment:
+			if e.included(sel.Directives) && e.applies(sel.TypeCondition, typ) {
+				fields = e.collect(typ, sel.SelectionSet, fields)
+			}
+		case *ast.FragmentSpread:
+			if e.included(sel.Directives) && sel.Definition != nil && e.applies(sel.Definition.TypeCondition, typ) {
+				fields = e.collect(typ, sel.Definition.SelectionSet, fields)
+			}
+		}
+	}
+	return fields
+}
+
+func (e *gqlExec) included(directives ast.DirectiveList) bool {
+	if d := directives.ForName("skip"); d != nil && d.ArgumentMap(e.vars)["if"] == true {
+		return false
+	}
+	if d := directives.ForName("include"); d != nil && d.ArgumentMap(e.vars)["if"] == false {
+		return false
+	}
+	return true
+}
+
+// applies reports whether a fragment on condition applies to typ.
+func (e *gqlExec) applies(condition string, typ *ast.Definition) bool {
+	if condition == "" || condition == typ.Name {
+		return true
+	}
+	cond := e.schema.Types[condition]
+	return cond != nil && slices.Contains(e.schema.GetPossibleTypes(cond), typ)
+}
+
+func (e *gqlExec) field(typ *ast.Definition, f *gqlField, parent any) any {
+	args := f.field.ArgumentMap(e.vars)
+
+	var (
+		v       any
+		present bool
+	)
+	switch f.field.Name {
+	case "__typename":
+		return typ.Name
+	case "__schema":
+		v, present = e.schemaInfo(), true
+	case "__type":
+		name, _ := args["name"].(string)
+		v, present = e.typeInfo(e.schema.Types[name]), true
+	default:
+		switch p := parent.(type) {
+		case gqlIntro:
+			v, present = p[f.field.Name], true
+		case map[string]any:
+			v, present = p[f.field.Name]
+		}
+	}
+
+	if resolve, ok := v.(gqlResolver); ok {
+		v = resolve(args)
+	}
+	if items, ok := v.([]any); ok && f.field.Definition.Type.Elem == nil {
+		v = matchArgs(items, args)
+	}
+	return e.complete(f.field.Definition.Type, f.field.Name, f.set, v, present)
+}
+
+// matchArgs picks the item whose fields equal the arguments, arguments
+// the items don't have are ignored.
+func matchArgs(items []any, args map[string]any) any {
+	for _, item := range items {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		matches := true
+		for k, arg := range args {
+			if v, ok := m[k]; ok && fmt.Sprint(v) != fmt.Sprint(arg) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return m
+		}
+	}
+	return nil
+}
+
+// complete shapes v after t, making it up when it is not present.
+func (e *gqlExec) complete(t *ast.Type, name string, set ast.SelectionSet, v any, present bool) any {
+	if present && v == nil {
+		return nil
+	}
+
+	if t.Elem != nil {
+		items, ok := v.([]any)
+		switch {
+		case !present:
+			items = make([]any, 2)
+		case !ok:
+			items = []any{v}
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			out[i] = e.complete(t.Elem, name, set, item, present)
+		}
+		return out
+	}
+
+	def := e.schema.Types[t.NamedType]
+	switch def.Kind {
+	case ast.Scalar:
+		if present {
+			return v
+		}
+		return fakeScalar(def.Name, name)
+	case ast.Enum:
+		if present {
+			return v
+		}
+		return pick(def.EnumValues).Name
+	case ast.Interface, ast.Union:
+		def = e.concreteType(def, v)
+	}
+	return e.selectionSet(def, set, v)
+}
+
+// concreteType is the object type of an interface or union value, the
+// data can say with __typename.
+func (e *gqlExec) concreteType(def *ast.Definition, v any) *ast.Definition {
+	possible := e.schema.GetPossibleTypes(def)
+	if m, ok := v.(map[string]any); ok {
+		name, _ := m["__typename"].(string)
+		if i := slices.IndexFunc(possible, func(d *ast.Definition) bool { return d.Name == name }); i >= 0 {
+			return possible[i]
+		}
+	}
+	return possible[0]
+}
+
+// fakeScalar makes up a value of the scalar typ, strings look like what the
+// field name says.
+func fakeScalar(typ, field string) any {
+	switch typ {
+	case "ID":
+		return uuid()
+	case "Int":
+		return 1 + mrand.IntN(100)
+	case "Float":
+		return float64(mrand.IntN(10000)) / 100
+	case "Boolean":
+		return mrand.IntN(2) == 0
+	}
+
+	name := strings.ToLower(field)
+	switch {
+	case strings.Contains(name, "email"):
+		return fakeEmail()
+	case name == "firstname":
+		return pick(firstNames)
+	case name == "lastname":
+		return pick(lastNames)
+	case strings.Contains(name, "name"):
+		return pick(firstNames) + " " + pick(lastNames)
+	case strings.Contains(name, "city"):
+		return pick(cities)
+	case strings.Contains(name, "url"):
+		return "https://" + pick(domains) + "/" + pick(loremWords)
+	case strings.Contains(name, "date") || strings.HasSuffix(field, "At"):
+		return time.Now().Add(-mrand.N(365 * 24 * time.Hour)).UTC().Format(time.RFC3339)
+	}
+	return lorem(3)
+}
+
+func (e *gqlExec) schemaInfo() gqlIntro {
+	var types []any
+	for _, name := range slices.Sorted(maps.Keys(e.schema.Types)) {
+		types = append(types, e.typeInfo(e.schema.Types[name]))
+	}
+	var directives []any
+	for _, name := range slices.Sorted(maps.Keys(e.schema.Directives)) {
+		d := e.schema.Directives[name]
+		var locations []any
+		for _, l := range d.Locations {
+			locations = append(locations, string(l))
+		}
+		directives = append(directives, gqlIntro{
+			"name":         d.Name,
+			"description":  optional(d.Description),
+			"locations":    locations,
+			"args":         e.argsInfo(d.Arguments),
+			"isRepeatable": d.IsRepeatable,
+		})
+	}
+
+	return gqlIntro{
+		"description":      optional(e.schema.Description),
+		"queryType":        e.typeInfo(e.schema.Query),
+		"mutationType":     e.typeInfo(e.schema.Mutation),
+		"subscriptionType": e.typeInfo(e.schema.Subscription),
+		"types":            types,
+		"directives":       directives,
+	}
+}
+
+// typeInfo is the __Type of def, its fields are resolved when asked for,
+// types refer to each other.
+func (e *gqlExec) typeInfo(def *ast.Definition) any {
+	if def == nil {
+		return nil
+	}
+	t := gqlIntro{
+		"kind":        string(def.Kind),
+		"name":        def.Name,
+		"description": optional(def.Description),
+		"isOneOf":     def.Directives.ForName("oneOf") != nil,
+	}
+
+	switch def.Kind {
+	case ast.Object, ast.Interface:
+		t["fields"] = gqlResolver(func(args map[string]any) any {
+			fields := []any{}
+			for _, f := range def.Fields {
+				if strings.HasPrefix(f.Name, "__") || (isDeprecated(f.Directives) && args["includeDeprecated"] != true) {
+					continue
+				}
+				fields = append(fields, gqlIntro{
+					"name":              f.Name,
+					"description":       optional(f.Description),
+					"args":              e.argsInfo(f.Arguments),
+					"type":              e.typeRef(f.Type),
+					"isDeprecated":      isDeprecated(f.Directives),
+					"deprecationReason": deprecationReason(f.Directives),
+				})
+			}
+			return fields
+		})
+		t["interfaces"] = gqlResolver(func(map[string]any) any {
+			interfaces := []any{}
+			for _, name := range def.Interfaces {
+				interfaces = append(interfaces, e.typeInfo(e.schema.Types[name]))
+			}
+			return interfaces
+		})
+	case ast.Enum:
+		t["enumValues"] = gqlResolver(func(args map[string]any) any {
+			values := []any{}
+			for _, v := range def.EnumValues {
+				if isDeprecated(v.Directives) && args["includeDeprecated"] != true {
+					continue
+				}
+				values = append(values, gqlIntro{
+					"name":              v.Name,
+					"description":       optional(v.Description),
+					"isDeprecated":      isDeprecated(v.Directives),
+					"deprecationReason": deprecationReason(v.Directives),
+				})
+			}
+			return values
+		})
+	case ast.InputObject:
+		t["inputFields"] = gqlResolver(func(args map[string]any) any {
+			fields := []any{}
+			for _, f := range def.Fields {
+				fields = append(fields, e.inputValue(f.Name, f.Description, f.Type, f.DefaultValue, f.Directives))
+			}
+			return fields
+		})
+	case ast.Scalar:
+		if d := def.Directives.ForName("specifiedBy"); d != nil {
+			t["specifiedByURL"] = d.ArgumentMap(nil)["url"]
+		}
+	}
+
+	if def.Kind == ast.Interface || def.Kind == ast.Union {
+		t["possibleTypes"] = gqlResolver(func(map[string]any) any {
+			possible := []any{}
+			for _, p := range e.schema.GetPossibleTypes(def) {
+				possible = append(possible, e.typeInfo(p))
+			}
+			return possible
+		})
+	}
+	return t
+}
+
+// typeRef is the __Type of a field or argument type, lists and non null
+// types wrap the named type.
+func (e *gqlExec) typeRef(t *ast.Type) any {
+	switch {
+	case t.NonNull:
+		return gqlIntro{"kind": "NON_NULL", "ofType": e.typeRef(&ast.Type{NamedType: t.NamedType, Elem: t.Elem})}
+	case t.Elem != nil:
+		return gqlIntro{"kind": "LIST", "ofType": e.typeRef(t.Elem)}
+	}
+	return e.typeInfo(e.schema.Types[t.NamedType])
+}
+
+func (e *gqlExec) argsInfo(args ast.ArgumentDefinitionList) gqlResolver {
+	return func(map[string]any) any {
+		out := []any{}
+		for _, a := range args {
+			out = append(out, e.inputValue(a.Name, a.Description, a.Type, a.DefaultValue, a.Directives))
+		}
+		return out
+	}
+}
+
+func (e *gqlExec) inputValue(name, description string, typ *ast.Type, def *ast.Value, directives ast.DirectiveList) gqlIntro {
+	v := gqlIntro{
+		"name":              name,
+		"description":       optional(description),
+		"type":              e.typeRef(typ),
+		"isDeprecated":      isDeprecated(directives),
+		"deprecationReason": deprecationReason(directives),
+	}
+	if def != nil {
+		v["defaultValue"] = def.String()
+	}
+	return v
+}
+
+func isDeprecated(directives ast.DirectiveList) bool {
+	return directives.ForName("deprecated") != nil
+}
+
+func deprecationReason(directives ast.DirectiveList) any {
+	d := directives.ForName("deprecated")
+	if d == nil {
+		return nil
+	}
+	if reason, ok := d.ArgumentMap(nil)["reason"].(string); ok {
+		return reason
+	}
+	return "No longer supported"
+}
+
+// optional is s, or null when it is empty.
+func optional(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,283 @@
+package mok
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// the grpc mock serves the methods of a .proto file, or of a descriptor set
+// made with protoc --descriptor_set_out --include_imports, from json
+// fixtures named after the method path:
+//
+//	grpc/helloworld.Greeter/SayHello.json  -> /helloworld.Greeter/SayHello
+//
+// methods streaming their responses send every element of an array fixture
+// as a message, the requests of client streams are read and ignored.
+
+// gRPC status codes, see google.golang.org/grpc/codes.
+const (
+	grpcOK            = 0
+	grpcInvalidArg    = 3
+	grpcUnimplemented = 12
+	grpcInternal      = 13
+)
+
+// grpcMaxMessage is the largest request message, like grpc-go.
+const grpcMaxMessage = 4 << 20
+
+// grpcMock answers gRPC calls with fixtures.
+type grpcMock struct {
+	log      *log.Logger
+	fsys     fs.FS
+	fixtures string
+	methods  map[string]protoreflect.MethodDescriptor
+	types    *dynamicpb.Types
+}
+
+// loadGRPC reads the services of schema, fixtures are looked up in the
+// fixtures directory.
+func loadGRPC(fsys fs.FS, schema, fixtures string, l *log.Logger) (*grpcMock, error) {
+	files, err := loadDescriptors(fsys, schema)
+	if err != nil {
+		return nil, fmt.Errorf("loading grpc schema: %w", err)
+	}
+
+	g := &grpcMock{
+		log:      l,
+		fsys:     fsys,
+		fixtures: fixtures,
+		methods:  make(map[string]protoreflect.MethodDescriptor),
+		types:    dynamicpb.NewTypes(files),
+	}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := range fd.Services().Len() {
+			svc := fd.Services().Get(i)
+			for j := range svc.Methods().Len() {
+				m := svc.Methods().Get(j)
+				g.methods["/"+string(svc.FullName())+"/"+string(m.Name())] = m
+			}
+		}
+		return true
+	})
+	if len(g.methods) == 0 {
+		return nil, fmt.Errorf("loading grpc schema: no services in %s", schema)
+	}
+	return g, nil
+}
+
+// loadDescriptors compiles a .proto file, imports are relative to its
+// directory, or reads a binary descriptor set.
+func loadDescriptors(fsys fs.FS, schema string) (*protoregistry.Files, error) {
+	if path.Ext(schema) != ".proto" {
+		data, err := fs.ReadFile(fsys, fsPath(fsys, schema))
+		if err != nil {
+			return nil, err
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("parsing descriptor set %q: %w", schema, err)
+		}
+		return protodesc.NewFiles(&set)
+	}
+
+	dir := dirPath(fsys, schema)
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: func(name string) (io.ReadCloser, error) {
+				return fsys.Open(fsPath(fsys, joinPath(fsys, dir, name)))
+			},
+		}),
+	}
+	compiled, err := compiler.Compile(context.Background(), path.Base(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	files := new(protoregistry.Files)
+	for _, fd := range compiled {
+		if err := registerFile(files, fd); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// registerFile adds fd and its imports to files, once.
+func registerFile(files *protoregistry.Files, fd protoreflect.FileDescriptor) error {
+	if _, err := files.FindFileByPath(fd.Path()); err == nil {
+		return nil
+	}
+	for i := range fd.Imports().Len() {
+		if err := registerFile(files, fd.Imports().Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+	return files.RegisterFile(fd)
+}
+
+// paths lists the served methods, sorted.
+func (g *grpcMock) paths() []string {
+	return slices.Sorted(maps.Keys(g.methods))
+}
+
+func (g *grpcMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "grpc requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	m, ok := g.methods[r.URL.Path]
+	if !ok {
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	logInfo(g.log, fmt.Sprintf("grpc %s", r.URL.Path))
+
+	// the requests are not looked at, reading them keeps client streams happy
+	if err := g.readRequests(r, m); err != nil {
+		grpcStatus(w, grpcInvalidArg, err.Error())
+		return
+	}
+
+	responses, err := g.responses(m, r.URL.Path)
+	if err != nil {
+		code := grpcInternal
+		if errors.Is(err, fs.ErrNotExist) {
+			code = grpcUnimplemented
+		}
+		grpcStatus(w, code, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	for _, msg := range responses {
+		if err := writeGRPCMessage(w, msg); err != nil {
+			return
+		}
+		http.NewResponseController(w).Flush()
+	}
+	grpcStatus(w, grpcOK, "")
+}
+
+// readRequests reads the request messages until the client is done sending.
+func (g *grpcMock) readRequests(r *http.Request, m protoreflect.MethodDescriptor) error {
+	for {
+		data, err := readGRPCMessage(r.Body, r.Header.Get("Grpc-Encoding"))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := proto.Unmarshal(data, dynamicpb.NewMessage(m.Input())); err != nil {
+			return fmt.Errorf("decoding %s: %w", m.Input().FullName(), err)
+		}
+	}
+}
+
+// responses reads the fixture of the method at methodPath and encodes its
+// messages.
+func (g *grpcMock) responses(m protoreflect.MethodDescriptor, methodPath string) ([][]byte, error) {
+	name := joinPath(g.fsys, g.fixtures, strings.TrimPrefix(methodPath, "/")+".json")
+	content, err := fs.ReadFile(g.fsys, fsPath(g.fsys, name))
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for %s: %w", methodPath, err)
+	}
+	if content, err = convertFixture(name, content, true); err != nil {
+		return nil, err
+	}
+
+	fixtures := []json.RawMessage{content}
+	if m.IsStreamingServer() {
+		if err := json.Unmarshal(content, &fixtures); err != nil {
+			return nil, fmt.Errorf("%s streams, its fixture must be an array: %w", methodPath, err)
+		}
+	}
+
+	opts := protojson.UnmarshalOptions{Resolver: g.types}
+	var messages [][]byte
+	for i, fixture := range fixtures {
+		msg := dynamicpb.NewMessage(m.Output())
+		if err := opts.Unmarshal(fixture, msg); err != nil {
+			return nil, fmt.Errorf("fixture %s message %d: %w", name, i, err)
+		}
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, data)
+	}
+	return messages, nil
+}
+
+// readGRPCMessage reads a length-prefixed message, compressed ones are
+// inflated with encoding.
+func readGRPCMessage(r io.Reader, encoding string) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated message")
+		}
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessage {
+		return nil, fmt.Errorf("message of %d bytes is too large", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.New("truncated message")
+	}
+	if prefix[0] == 0 {
+		return data, nil
+	}
+
+	if encoding != "gzip" {
+		return nil, fmt.Errorf("unsupported grpc-encoding %q", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+func writeGRPCMessage(w io.Writer, data []byte) error {
+	prefix := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(data)))
+	_, err := w.Write(append(prefix, data...))
+	return err
+}
+
+// grpcStatus ends the call with code in the trailers, gRPC errors are
+// http 200s.
+func grpcStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(msg))
+	}
+}
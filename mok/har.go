@@ -0,0 +1,260 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// with WithRecordHAR every exchange of the session is kept, bodies
+// included, and written as a HAR 1.2 file on shutdown, to be opened in the
+// network panel of a browser or shared. /__mok__/har serves it meanwhile.
+
+// maxHARBody caps how much of every request and response body is kept,
+// the entries of longer ones say they were truncated.
+const maxHARBody = 1 << 20
+
+type harLog struct {
+	mu      sync.Mutex
+	entries []harEntry
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harWriter keeps a copy of the first maxHARBody bytes of the response
+// body, size counts all of them.
+type harWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+	size int
+}
+
+func (w *harWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.body.Write(b[:min(n, max(maxHARBody-w.body.Len(), 0))])
+	w.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *harWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// readBody reads the first maxHARBody bytes of the request body and puts
+// them back, like captureBody. truncated is set when there is more.
+func readBody(r *http.Request) (body []byte, truncated bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+	body, _ = io.ReadAll(io.LimitReader(r.Body, maxHARBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if len(body) > maxHARBody {
+		return body[:maxHARBody], true
+	}
+	return body, false
+}
+
+// add records an exchange, reqBody is the request body, reqTruncated set
+// when it is only its start, and w the writer the response went through.
+func (l *harLog) add(r *http.Request, reqBody []byte, reqTruncated bool, w *harWriter, status int, start time.Time) {
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	req := harRequest{
+		Method:      r.Method,
+		URL:         scheme + "://" + r.Host + r.RequestURI,
+		HTTPVersion: r.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(r.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(reqBody),
+	}
+	var truncated []string
+	if reqTruncated {
+		// -1 is unknown in HAR
+		req.BodySize = int(r.ContentLength)
+		truncated = append(truncated, "request")
+	}
+	for name, values := range r.URL.Query() {
+		for _, v := range values {
+			req.QueryString = append(req.QueryString, harNameValue{name, v})
+		}
+	}
+	for _, c := range r.Cookies() {
+		req.Cookies = append(req.Cookies, harNameValue{c.Name, c.Value})
+	}
+	if len(reqBody) > 0 {
+		req.PostData = &harPostData{MimeType: r.Header.Get("Content-Type"), Text: string(reqBody)}
+	}
+
+	header := w.Header()
+	body := w.body.Bytes()
+	resp := harResponse{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: r.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(header),
+		Content:     harBody(body, header.Get("Content-Type")),
+		RedirectURL: header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    w.size,
+	}
+	resp.Content.Size = w.size
+	if w.size > len(body) {
+		truncated = append(truncated, "response")
+	}
+	comment := ""
+	if len(truncated) > 0 {
+		comment = fmt.Sprintf("%s body truncated to %d bytes", strings.Join(truncated, " and "), maxHARBody)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, harEntry{
+		StartedDateTime: start,
+		Time:            elapsed,
+		Request:         req,
+		Response:        resp,
+		Timings:         harTimings{Wait: elapsed},
+		Comment:         comment,
+	})
+}
+
+// harBody is the content of a response, base64 when it is not text.
+func harBody(body []byte, ctype string) harContent {
+	content := harContent{Size: len(body), MimeType: ctype}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text, content.Encoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	return content
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		for _, v := range header[name] {
+			headers = append(headers, harNameValue{name, v})
+		}
+	}
+	return headers
+}
+
+// WriteHAR writes the exchanges recorded so far as a HAR file, see
+// WithRecordHAR.
+func (s *Server) WriteHAR(w io.Writer) error {
+	if s.har == nil {
+		return errors.New("not recording a HAR, see WithRecordHAR")
+	}
+	s.har.mu.Lock()
+	entries := slices.Clone(s.har.entries)
+	s.har.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{
+		"log": map[string]any{
+			"version": "1.2",
+			"creator": map[string]string{"name": "mok", "version": ""},
+			"entries": entries,
+		},
+	})
+}
+
+// saveHAR writes the session to the WithRecordHAR file.
+func (s *Server) saveHAR() error {
+	f, err := os.Create(s.opts.harPath)
+	if err != nil {
+		return fmt.Errorf("saving HAR: %w", err)
+	}
+	if err := s.WriteHAR(f); err != nil {
+		f.Close()
+		return fmt.Errorf("saving HAR: %w", err)
+	}
+	return f.Close()
+}
+
+func (s *Server) serveHAR(w http.ResponseWriter, r *http.Request) {
+	if s.har == nil {
+		http.Error(w, "not recording a HAR, start mok with -record-har", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	s.WriteHAR(w)
+}
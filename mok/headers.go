@@ -0,0 +1,14 @@
+package mok
+
+import "net/http"
+
+// withHeaders adds header to every response, routes can still override
+// single headers since they are set before the route handler runs.
+func withHeaders(next http.Handler, header http.Header) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range header {
+			w.Header()[k] = append(w.Header()[k], v...)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,301 @@
+package mok
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Import converts the document at path, of format openapi, postman (a
+// collection with saved responses) or har, into fixtures and a mok.yaml
+// routing them in dir, the layout of a recording: serve it with mok replay.
+// it returns the files it wrote.
+func Import(format, path, dir string) ([]string, error) {
+	var (
+		routes []importedRoute
+		err    error
+	)
+	switch format {
+	case "openapi":
+		routes, err = importOpenAPI(path)
+	case "postman":
+		routes, err = importPostman(path)
+	case "har":
+		routes, err = importHAR(path)
+	default:
+		return nil, fmt.Errorf("cannot import %q, expected openapi, postman or har", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("%s: no responses to import", path)
+	}
+	return writeImport(dir, routes)
+}
+
+// importedRoute is a response of another tool, about to become a fixture.
+type importedRoute struct {
+	method string
+	path   string
+	status int
+	header http.Header
+	body   []byte
+	isJSON bool
+}
+
+// importOpenAPI takes the response mok would serve for every operation.
+func importOpenAPI(path string) ([]importedRoute, error) {
+	files, err := openAPIFiles(osFS{}, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]importedRoute, len(files))
+	for i, f := range files {
+		routes[i] = importedRoute{
+			method: f.Method,
+			path:   f.URLPath,
+			status: f.Status,
+			header: http.Header{},
+			body:   f.content,
+			isJSON: len(f.content) > 0,
+		}
+	}
+	return routes, nil
+}
+
+// postmanCollection is the part of a v2 collection mok reads, items nest in
+// folders.
+type postmanCollection struct {
+	Item []postmanItem `json:"item"`
+}
+
+type postmanItem struct {
+	Item     []postmanItem     `json:"item"`
+	Request  *postmanRequest   `json:"request"`
+	Response []postmanResponse `json:"response"`
+}
+
+type postmanRequest struct {
+	Method string          `json:"method"`
+	URL    json.RawMessage `json:"url"`
+}
+
+type postmanResponse struct {
+	Code   int               `json:"code"`
+	Header []postmanKeyValue `json:"header"`
+	Body   string            `json:"body"`
+}
+
+type postmanKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// importPostman takes the saved responses of the requests of a collection,
+// the lowest 2xx when a request has several.
+func importPostman(path string) ([]importedRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var collection postmanCollection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, fmt.Errorf("parsing postman collection %q: %w", path, err)
+	}
+
+	var routes []importedRoute
+	var walk func(items []postmanItem)
+	walk = func(items []postmanItem) {
+		for _, item := range items {
+			walk(item.Item)
+			if item.Request == nil || len(item.Response) == 0 {
+				continue
+			}
+			resp := slices.MinFunc(item.Response, func(a, b postmanResponse) int {
+				return cmp.Compare(statusRank(a.Code), statusRank(b.Code))
+			})
+			header := http.Header{}
+			for _, h := range resp.Header {
+				header.Add(h.Key, h.Value)
+			}
+			routes = append(routes, importedRoute{
+				method: strings.ToUpper(cmp.Or(item.Request.Method, http.MethodGet)),
+				path:   postmanPath(item.Request.URL),
+				status: cmp.Or(resp.Code, http.StatusOK),
+				header: header,
+				body:   []byte(resp.Body),
+				isJSON: json.Valid([]byte(resp.Body)),
+			})
+		}
+	}
+	walk(collection.Item)
+	return routes, nil
+}
+
+// statusRank orders 2xx first, lowest first, then the rest.
+func statusRank(status int) int {
+	if status >= 200 && status < 300 {
+		return status
+	}
+	return status + 1000
+}
+
+// postmanVarRe matches {{variables}} and :variables of postman urls.
+var postmanVarRe = regexp.MustCompile(`^(?:\{\{(.+)\}\}|:(.+))$`)
+
+// postmanPath is the path of a request url, a string or an object with the
+// path segments, {{id}} and :id become {id}.
+func postmanPath(raw json.RawMessage) string {
+	var u struct {
+		Raw  string   `json:"raw"`
+		Path []string `json:"path"`
+	}
+	if json.Unmarshal(raw, &u) != nil {
+		json.Unmarshal(raw, &u.Raw)
+	}
+	segments := u.Path
+	if segments == nil {
+		// {{baseUrl}}/users/:id?page=1
+		rest := u.Raw
+		if i := strings.Index(rest, "://"); i >= 0 {
+			rest = rest[i+3:]
+		}
+		rest, _, _ = strings.Cut(rest, "?")
+		segments = strings.Split(rest, "/")[1:]
+	}
+	for i, s := range segments {
+		if m := postmanVarRe.FindStringSubmatch(s); m != nil {
+			segments[i] = "{" + invalidWildcardRe.ReplaceAllString(cmp.Or(m[1], m[2]), "_") + "}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// importHAR takes the first response of every method and path of a HAR,
+// the ones of -record-har included, query strings are not part of the route.
+func importHAR(path string) ([]importedRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var har struct {
+		Log struct {
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("parsing HAR %q: %w", path, err)
+	}
+
+	var routes []importedRoute
+	seen := map[string]bool{}
+	for _, e := range har.Log.Entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			continue
+		}
+		key := e.Request.Method + " " + u.Path
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		body := []byte(e.Response.Content.Text)
+		if e.Response.Content.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		header := http.Header{}
+		for _, h := range e.Response.Headers {
+			header.Add(h.Name, h.Value)
+		}
+		routes = append(routes, importedRoute{
+			method: e.Request.Method,
+			path:   cmp.Or(u.Path, "/"),
+			status: e.Response.Status,
+			header: header,
+			body:   body,
+			isJSON: json.Valid(body),
+		})
+	}
+	return routes, nil
+}
+
+// writeImport writes routes like a recording: the fixtures named after
+// their request, users/1.GET.json, and the mok.yaml routing them.
+func writeImport(dir string, routes []importedRoute) ([]string, error) {
+	var cfg Config
+	var written []string
+	seen := map[string]bool{}
+	for _, r := range routes {
+		if seen[r.method+" "+r.path] {
+			continue
+		}
+		seen[r.method+" "+r.path] = true
+
+		file := fixtureName(r.path, r.method)
+		ctype := r.header.Get("Content-Type")
+		if !r.isJSON {
+			ext := ".txt"
+			if exts, _ := mime.ExtensionsByType(ctype); len(exts) > 0 {
+				ext = exts[0]
+			}
+			file = strings.TrimSuffix(file, ".json") + ext
+		}
+		target := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(target, r.body, 0o644); err != nil {
+			return written, err
+		}
+		written = append(written, target)
+
+		// paths ending in / are subtrees for ServeMux, imports are exact
+		route := RouteConfig{Path: r.path, Method: r.method}
+		if strings.HasSuffix(route.Path, "/") {
+			route.Path += "{$}"
+		}
+		route.File = filepath.ToSlash(file)
+		if r.status != http.StatusOK {
+			route.Status = r.status
+		}
+		headers := map[string]string{}
+		for _, k := range slices.Sorted(maps.Keys(r.header)) {
+			if !skippedHeaders[k] && k != "Content-Type" {
+				headers[k] = strings.Join(r.header[k], ", ")
+			}
+		}
+		if len(headers) > 0 {
+			route.Headers = headers
+		}
+		if !r.isJSON && ctype != "" {
+			route.ContentType = ctype
+		}
+		cfg.Routes = append(cfg.Routes, route)
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return written, err
+	}
+	target := filepath.Join(dir, DefaultConfigFile)
+	if err := os.WriteFile(target, data, 0o644); err != nil {
+		return written, err
+	}
+	return append(written, target), nil
+}
@@ -0,0 +1,135 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// JSONStyle is how json responses are laid out whatever their fixture
+// looks like: "pretty" indents them, "minify" strips their whitespace and
+// "" serves them as they are. ?_pretty=1 (or 0) picks one per request.
+type JSONStyle string
+
+const (
+	JSONAsIs   JSONStyle = ""
+	JSONPretty JSONStyle = "pretty"
+	JSONMinify JSONStyle = "minify"
+)
+
+// withJSONStyle lays json responses out in style, or in the one of the
+// _pretty query parameter of the request.
+func withJSONStyle(next http.Handler, style JSONStyle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		style := style
+		if pretty, err := strconv.ParseBool(r.URL.Query().Get("_pretty")); err == nil {
+			style = JSONMinify
+			if pretty {
+				style = JSONPretty
+			}
+		}
+		if style == JSONAsIs {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &styleWriter{ResponseWriter: w, r: r, style: style}
+		defer sw.close()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// maxStyled caps the json responses styleWriter holds back, the larger ones,
+// /json/300MB or a dump of a database, are served as they are instead of
+// sitting in memory.
+const maxStyled = 10 << 20
+
+// styleWriter holds json responses back until they are complete, the
+// others go through untouched.
+type styleWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	style JSONStyle
+
+	wroteHeader bool
+	status      int
+	buf         *bytes.Buffer // set while holding a json response back
+}
+
+func (w *styleWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	// ranges and conditional requests describe the bytes on disk
+	if isJSONType(w.Header().Get("Content-Type")) && status != http.StatusNoContent &&
+		status != http.StatusPartialContent && status != http.StatusNotModified && w.r.Method != http.MethodHead {
+		w.status, w.buf = status, &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *styleWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buf != nil && w.buf.Len()+len(b) > maxStyled {
+		w.release()
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// release gives up on laying out the response held back, what was held
+// goes out as it is and the rest follows it.
+func (w *styleWriter) release() {
+	held := w.buf.Bytes()
+	w.buf = nil
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(held)
+}
+
+// Flush is a no-op for the json responses held back.
+func (w *styleWriter) Flush() {
+	if w.buf == nil {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *styleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *styleWriter) close() {
+	if w.buf == nil {
+		return
+	}
+	var out bytes.Buffer
+	var err error
+	if w.style == JSONPretty {
+		err = json.Indent(&out, w.buf.Bytes(), "", "  ")
+		out.WriteByte('\n')
+	} else {
+		err = json.Compact(&out, w.buf.Bytes())
+	}
+	body := out.Bytes()
+	if err != nil {
+		// not json after all, served as it is
+		body = w.buf.Bytes()
+	} else if tag := w.Header().Get("Etag"); strings.HasPrefix(tag, `"`) {
+		// the bytes differ from the fixture, like compression does
+		w.Header().Set("Etag", "W/"+tag)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Del("Accept-Ranges")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
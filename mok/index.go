@@ -0,0 +1,84 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// IndexRoute is a route as the template of WithIndexTemplate sees it: the
+// fields of its RouteInfo, .Method, .Path, .Status..., and its hit count.
+type IndexRoute struct {
+	RouteInfo
+	Hits int64
+}
+
+// indexPage is the data of the index template, {{range .Routes}}.
+type indexPage struct {
+	Routes []IndexRoute
+}
+
+func loadIndexTemplate(path string) (*template.Template, error) {
+	t, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("parsing index template: %w", err)
+	}
+	return t, nil
+}
+
+// serveIndex renders the index template with the routes of files, a
+// template failing halfway is a 500 rather than half a page.
+func serveIndex(t *template.Template, files []*MokFile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var page indexPage
+		for i, route := range routeTable(files) {
+			page.Routes = append(page.Routes, IndexRoute{route, files[i].hits.Load()})
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, page); err != nil {
+			http.Error(w, "mok: index template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
+
+// indexEntry is a route of the json index: the fields of its MokFile and
+// what tooling wants to know of the fixture without requesting it, its
+// size, a hash of its content and the schema inferred from it. Method is *
+// for routes answering every method, scenarios, rules and templates have
+// no content of their own.
+type indexEntry struct {
+	*MokFile
+	Method string
+	Size   int
+	Hash   string         `json:",omitempty"`
+	Schema map[string]any `json:",omitempty"`
+}
+
+// serveJSONIndex lists the routes of files for Accept: application/json.
+func serveJSONIndex(w http.ResponseWriter, files []*MokFile) {
+	entries := make([]indexEntry, len(files))
+	for i, f := range files {
+		f.mu.RLock()
+		content := f.content
+		f.mu.RUnlock()
+
+		entries[i] = indexEntry{MokFile: f, Method: cmp.Or(f.Method, "*"), Size: len(content)}
+		if len(content) > 0 {
+			sum := sha256.Sum256(content)
+			entries[i].Hash = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		if example, ok := f.jsonExample(); ok {
+			entries[i].Schema = inferSchema(example)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,73 @@
+package mok
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+// jqTimeout and maxJQResults bound a jq query, ?_query comes from clients
+// and `repeat(.)` never ends.
+const (
+	jqTimeout    = time.Second
+	maxJQResults = 1 << 16
+)
+
+// compileJQ parses a jq expression, e.g. `.users | map({id, name})`.
+func compileJQ(expr string) (*gojq.Code, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing jq %q: %w", expr, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("compiling jq %q: %w", expr, err)
+	}
+	return code, nil
+}
+
+// runJQ transforms a json document with code, a query yielding several
+// values serves them as an array and one yielding none serves null.
+func runJQ(ctx context.Context, code *gojq.Code, content []byte) ([]byte, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("jq: the response is not json: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, jqTimeout)
+	defer cancel()
+
+	var results []any
+	iter := code.RunWithContext(ctx, v)
+	for {
+		result, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := result.(error); ok {
+			if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
+				break
+			}
+			return nil, fmt.Errorf("jq: %w", err)
+		}
+		if results = append(results, result); len(results) > maxJQResults {
+			return nil, fmt.Errorf("jq: more than %d results", maxJQResults)
+		}
+	}
+
+	var out any
+	switch len(results) {
+	case 0:
+	case 1:
+		out = results[0]
+	default:
+		out = results
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,177 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// jsonrpc routes answer JSON-RPC 2.0 calls with the fixture named after
+// the method in their directory, eth_blockNumber.json answers
+// eth_blockNumber and textDocument/hover.json textDocument/hover. the
+// fixture is the result, a fixture holding only an error object is the
+// error:
+//
+//	{"error": {"code": -32000, "message": "execution reverted"}}
+//
+// batches and notifications work as the spec says.
+
+// JSON-RPC error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInternalError  = -32603
+)
+
+// jsonRPC answers the calls of a jsonrpc route.
+type jsonRPC struct {
+	fsys fs.FS
+	dir  string
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// jsonRPCFile builds the route answering the methods in dir, relative to
+// baseDir.
+func jsonRPCFile(fsys fs.FS, urlPath, dir, baseDir string) (*MokFile, error) {
+	if !filepath.IsAbs(dir) {
+		dir = joinPath(fsys, baseDir, dir)
+	}
+	if fi, err := fs.Stat(fsys, fsPath(fsys, dir)); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("jsonrpc methods: %s is not a directory", dir)
+	}
+	return &MokFile{
+		FilePath: "jsonrpc: " + dir,
+		URLPath:  urlPath,
+		rpc:      &jsonRPC{fsys: fsys, dir: dir},
+	}, nil
+}
+
+func (rpc *jsonRPC) serve(w http.ResponseWriter, r *http.Request, delay Delay) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "jsonrpc calls are POSTs", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	delay.sleep(r.Context())
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, http.StatusOK, rpcFailure(nil, rpcParseError, err.Error()))
+			return
+		}
+		if len(batch) == 0 {
+			writeJSON(w, http.StatusOK, rpcFailure(nil, rpcInvalidRequest, "empty batch"))
+			return
+		}
+		responses := []*rpcResponse{}
+		for _, call := range batch {
+			if resp := rpc.call(call); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		// a batch of notifications gets nothing back
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, responses)
+		return
+	}
+
+	resp := rpc.call(body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// call answers a single call, nil for notifications.
+func (rpc *jsonRPC) call(data []byte) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return rpcFailure(nil, rpcParseError, err.Error())
+		}
+		return rpcFailure(nil, rpcInvalidRequest, err.Error())
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(req.ID, rpcInvalidRequest, `expected "jsonrpc": "2.0" and a method`)
+	}
+
+	resp := rpc.result(req.Method)
+	if req.ID == nil {
+		return nil
+	}
+	resp.ID = req.ID
+	return resp
+}
+
+// result reads the fixture of method.
+func (rpc *jsonRPC) result(method string) *rpcResponse {
+	if strings.Contains(method, "..") || strings.HasPrefix(method, "/") {
+		return rpcFailure(nil, rpcMethodNotFound, "method not found: "+method)
+	}
+	name := joinPath(rpc.fsys, rpc.dir, method+".json")
+	content, err := fs.ReadFile(rpc.fsys, fsPath(rpc.fsys, name))
+	if err != nil {
+		return rpcFailure(nil, rpcMethodNotFound, "method not found: "+method)
+	}
+	if content, err = convertFixture(name, content, true); err != nil {
+		return rpcFailure(nil, rpcInternalError, err.Error())
+	}
+	if !json.Valid(content) {
+		return rpcFailure(nil, rpcInternalError, fmt.Sprintf("fixture %s is not json", name))
+	}
+
+	var failure struct {
+		Error *rpcError `json:"error"`
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(content, &fields) == nil && len(fields) == 1 &&
+		json.Unmarshal(content, &failure) == nil && failure.Error != nil && failure.Error.Message != "" {
+		return &rpcResponse{JSONRPC: "2.0", Error: failure.Error}
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: content}
+}
+
+func rpcFailure(id json.RawMessage, code int, msg string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: msg}, ID: id}
+}
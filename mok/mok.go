@@ -0,0 +1,713 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/itchyny/gojq"
+)
+
+// MokFile is a served route and the fixture answering it.
+type MokFile struct {
+	FilePath string
+	URLPath  string
+	Host     string            `json:",omitempty"`
+	Method   string            `json:",omitempty"`
+	Status   int               `json:",omitempty"`
+	Headers  map[string]string `json:",omitempty"`
+	Delay    Delay             `json:"-"`
+
+	// ContentType replaces the type guessed from the file extension and
+	// the content, Headers still win.
+	ContentType string `json:",omitempty"`
+	// Format converts the fixture to json or xml when it is served.
+	Format string `json:",omitempty"`
+	// JQ transforms the fixture before it is served, see compileJQ.
+	JQ string `json:",omitempty"`
+	jq *gojq.Code
+	// Echo answers with the request instead of a fixture, see echoRequest.
+	Echo bool `json:",omitempty"`
+	// Template renders the fixture on every request, see renderTemplate.
+	Template bool `json:",omitempty"`
+
+	RateLimit RateLimit   `json:"-"`
+	Compress  Compression `json:"-"`
+	limiter   *tokenBucket
+
+	CacheControl string        `json:"-"`
+	Expires      time.Duration `json:"-"`
+
+	// sequence is set for scenario routes, every request is served by the
+	// next of its steps.
+	sequence *sequence
+	// rules is set for routes matching on the request, see Matcher.
+	rules *ruleSet
+	// auth is set for routes requiring credentials, see AuthConfig.
+	auth *routeAuth
+	// schema is set for routes validating request bodies, see bodySchema.
+	schema *bodySchema
+	// ws is set for websocket routes, see wsScript.
+	ws *wsScript
+	// rpc is set for routes answering JSON-RPC calls, see jsonRPC.
+	rpc *jsonRPC
+
+	// direct is set for the route serving the direct input, its content
+	// changes with SetDirectInput.
+	direct bool
+
+	// alias is a second path answered by the route, the path with its
+	// extension when pretty urls keep it, see WithPrettyURLs.
+	alias string
+
+	// inline is set when content was generated in memory (e.g. from an
+	// OpenAPI document), there is no file to load.
+	inline bool
+
+	// paramFile is set when FilePath contains {name} placeholders, the file
+	// is then resolved and read on every request, see paramFilePath.
+	paramFile bool
+
+	// fsys is where FilePath is read from, the operating system when nil.
+	fsys fs.FS
+
+	// temp is set when FilePath is a downloaded copy of a remote file, it is
+	// removed once the route is gone, see removeTemp.
+	temp bool
+	// remote is set for downloaded remote files, see WithRefresh.
+	remote *remoteSource
+	// checkout is set for the files of a git source, its clone is removed
+	// with them, see gitFiles.
+	checkout *gitCheckout
+
+	mu      sync.RWMutex
+	content []byte
+	modTime time.Time
+	size    int64 // of the file, content differs for yaml fixtures
+
+	// hits and override back the dashboard, they live as long as the route.
+	hits     atomic.Int64
+	override atomic.Pointer[override]
+}
+
+// load reads the file contents in memory, handlers serve from there so
+// files can be swapped underneath a running server (see -w).
+func (f *MokFile) load() error {
+	if f.inline || f.paramFile || f.FilePath == "" {
+		return nil
+	}
+
+	info, err := fs.Stat(f.source(), f.FilePath)
+	if err != nil {
+		return fmt.Errorf("checking file: %w", err)
+	}
+	content, err := fs.ReadFile(f.source(), f.FilePath)
+	if err != nil {
+		return fmt.Errorf("reading file: %w", err)
+	}
+	if content, err = convertFixture(f.FilePath, content, f.local()); err != nil {
+		return fmt.Errorf("%s: %w", f.FilePath, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.content = content
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+func (f *MokFile) serve(w http.ResponseWriter, r *http.Request) {
+	if f.sequence != nil {
+		f.sequence.step().serve(w, r)
+		return
+	}
+	if f.rules != nil {
+		f.rules.serve(w, r)
+		return
+	}
+	if f.ws != nil {
+		f.ws.serve(w, r)
+		return
+	}
+	if f.rpc != nil {
+		f.rpc.serve(w, r, delayFor(r, f.Delay))
+		return
+	}
+
+	f.mu.RLock()
+	content, modTime := f.content, f.modTime
+	f.mu.RUnlock()
+
+	name := f.FilePath
+	if f.paramFile {
+		var err error
+		if name, err = f.paramFilePath(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if content, modTime, err = readParamFile(f.source(), name, f.local()); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	// ServeContent picks the type from the name
+	name = jsonName(name)
+
+	if f.Echo {
+		// the request is not a template, whatever it contains
+		var err error
+		if content, err = echoRequest(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, modTime = "echo.json", time.Time{}
+	} else if f.Template && isTemplate(content) {
+		var err error
+		if content, err = renderTemplate(f.URLPath, content, r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// rendered per request, Last-Modified would be a lie
+		modTime = time.Time{}
+	}
+
+	// jq of the route first, then the one of the request
+	if f.jq != nil {
+		var err error
+		if content, err = runJQ(r.Context(), f.jq, content); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		modTime = time.Time{}
+	}
+	if expr := r.URL.Query().Get("_query"); expr != "" {
+		code, err := compileJQ(expr)
+		if err == nil {
+			content, err = runJQ(r.Context(), code, content)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		modTime = time.Time{}
+	}
+
+	// arrays have their fields selected after they are filtered
+	if r.URL.Query().Has("fields") {
+		if _, isArray := decodeArray(content); !isArray {
+			var err error
+			if content, err = selectFields(r, content); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			modTime = time.Time{}
+		}
+	}
+
+	// query the json before it becomes xml
+	if hasArrayQuery(r) && f.Format == formatXML {
+		if items, ok := decodeArray(content); ok {
+			items, err := queryItems(w, r, items)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			content, _ = json.Marshal(items)
+			modTime = time.Time{}
+		}
+	}
+	if f.Format != "" {
+		var err error
+		if content, name, err = convertFormat(f.Format, name, content); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	delayFor(r, f.Delay).sleep(r.Context())
+
+	if f.ContentType != "" {
+		w.Header().Set("Content-Type", f.ContentType)
+	}
+	for k, v := range f.Headers {
+		w.Header().Set(k, v)
+	}
+
+	if hasArrayQuery(r) && f.Format != formatXML {
+		if items, ok := decodeArray(content); ok {
+			items, err := queryItems(w, r, items)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// like writeJSON, without replacing a configured Content-Type
+			w.Header().Set("Content-Type", cmp.Or(w.Header().Get("Content-Type"), "application/json"))
+			w.WriteHeader(cmp.Or(f.Status, http.StatusOK))
+			json.NewEncoder(w).Encode(items)
+			return
+		}
+	}
+
+	if f.Status == 0 || f.Status == http.StatusOK {
+		// ServeContent answers If-None-Match and If-Modified-Since with 304
+		if w.Header().Get("Etag") == "" {
+			w.Header().Set("Etag", etag(content))
+		}
+		http.ServeContent(w, r, name, modTime, bytes.NewReader(content))
+		return
+	}
+
+	// ServeContent only knows about 200, write custom statuses ourselves
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentType(name, content))
+	}
+	w.WriteHeader(f.Status)
+	w.Write(content)
+}
+
+// etag is a strong validator for content, rendered templates get a new
+// one whenever their output changes.
+func etag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// local reports whether the fixture of f is a local file rather than a
+// download, a git checkout or a member of an archive, see expandEnv.
+func (f *MokFile) local() bool {
+	_, archived := f.fsys.(archiveFS)
+	return f.remote == nil && f.checkout == nil && !archived
+}
+
+// source is the file system FilePath is read from.
+func (f *MokFile) source() fs.FS {
+	if f.fsys == nil {
+		return osFS{}
+	}
+	return f.fsys
+}
+
+func readParamFile(fsys fs.FS, name string, env bool) ([]byte, time.Time, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	content, err := fs.ReadFile(fsys, name)
+	if err == nil {
+		content, err = convertFixture(name, content, env)
+	}
+	return content, info.ModTime(), err
+}
+
+// allFiles flattens scenario, rule and auth routes into the files they
+// serve.
+func allFiles(files []*MokFile) []*MokFile {
+	var all []*MokFile
+	for _, f := range files {
+		if f.auth != nil {
+			all = append(all, f.auth.files()...)
+		}
+		switch {
+		case f.sequence != nil:
+			all = append(all, f.sequence.steps...)
+		case f.rules != nil:
+			all = append(all, f.rules.files()...)
+		default:
+			all = append(all, f)
+		}
+	}
+	return all
+}
+
+// pattern is the ServeMux pattern the file is registered with.
+func (f *MokFile) pattern() string {
+	if f.Method == "" {
+		return f.Host + f.URLPath
+	}
+	return f.Method + " " + f.Host + f.URLPath
+}
+
+// patterns are the mux patterns of the route, its alias included.
+func (f *MokFile) patterns() []string {
+	if f.alias == "" {
+		return []string{f.pattern()}
+	}
+	alias := MokFile{URLPath: f.alias, Host: f.Host, Method: f.Method}
+	return []string{f.pattern(), alias.pattern()}
+}
+
+func contentType(name string, content []byte) string {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype
+	}
+	return http.DetectContentType(content)
+}
+
+// removeTemp deletes the downloaded copies among files.
+func removeTemp(l *log.Logger, files []*MokFile) {
+	for _, f := range allFiles(files) {
+		if f.checkout != nil {
+			if err := os.RemoveAll(f.checkout.dir); err != nil {
+				logInfo(l, fmt.Sprintf("cannot remove checkout %q: %s", f.checkout.dir, err))
+			}
+		}
+		if !f.temp {
+			continue
+		}
+		if err := os.Remove(f.FilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logInfo(l, fmt.Sprintf("cannot remove temp file %q: %s", f.FilePath, err))
+		}
+	}
+}
+
+// logInfo logs msg with l, the logger of a verbose server, nil logs
+// nothing, see WithVerbose.
+func logInfo(l *log.Logger, msg string) {
+	if l != nil {
+		l.Println(msg)
+	}
+}
+
+// processFileArgs resolves the files, directories and urls in args, @file
+// arguments are expanded first, see expandArgFiles. files
+// ending up at the same route are an error, unless namespace is set: they
+// are then mounted under the name of their directory, a/users.json at
+// /a/users.json and b/users.json at /b/users.json.
+func processFileArgs(fsys fs.FS, remoteCfg *remoteConfig, args []string, namespace bool) ([]*MokFile, error) {
+	args, err := expandArgFiles(fsys, args)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	spaces := make(map[*MokFile]string)
+	var files []*MokFile
+
+	downloads, err := downloadAll(remoteCfg, slices.DeleteFunc(slices.Clone(args), func(arg string) bool { return !isRemote(arg) }))
+	if err != nil {
+		return nil, fmt.Errorf("downloading remote file: %w", err)
+	}
+	remote := make(map[string][]*MokFile, len(downloads))
+	for u, src := range downloads {
+		if remote[u], err = remoteFiles(src); err != nil {
+			removeTemp(remoteCfg.logger(), slices.Concat(slices.Collect(maps.Values(remote))...))
+			for _, src := range downloads {
+				if src.temp {
+					os.Remove(src.path)
+				}
+			}
+			return nil, err
+		}
+	}
+	// the downloads not served yet are removed as well
+	fail := func(err error) ([]*MokFile, error) {
+		removeTemp(remoteCfg.logger(), append(files, slices.Concat(slices.Collect(maps.Values(remote))...)...))
+		return nil, err
+	}
+
+	for _, arg := range args {
+		resolved := remote[arg]
+		if !isRemote(arg) {
+			if resolved, err = resolveFile(fsys, remoteCfg, arg); err != nil {
+				return fail(err)
+			}
+		}
+
+		for _, file := range resolved {
+			if _, exists := seen[file.FilePath]; exists {
+				continue
+			}
+
+			if err := file.load(); err != nil {
+				return fail(err)
+			}
+
+			seen[file.FilePath] = struct{}{}
+			spaces[file] = argNamespace(fsys, arg)
+			files = append(files, file)
+		}
+	}
+
+	if namespace {
+		namespaceCollisions(files, spaces)
+	}
+	if err := routeConflict(files); err != nil {
+		removeTemp(remoteCfg.logger(), files)
+		return nil, err
+	}
+	return files, nil
+}
+
+// argNamespace is the name the files of arg are namespaced under: the
+// directory of a file, the directory itself, the host of a url, the name
+// of an archive or the last directory of a git source.
+func argNamespace(fsys fs.FS, arg string) string {
+	if isGit(arg) {
+		repo, subdir, _, _ := parseGitSource(arg)
+		return strings.TrimSuffix(path.Base(cmp.Or(subdir, repo)), ".git")
+	}
+	if kind := archiveKind(arg, ""); kind != "" && !isRemote(arg) {
+		return strings.TrimSuffix(strings.TrimSuffix(path.Base(filepath.ToSlash(arg)), ".tgz"), "."+kind)
+	}
+	if isRemote(arg) {
+		if u, err := url.Parse(arg); err == nil {
+			return u.Hostname()
+		}
+		return ""
+	}
+	name := filepath.ToSlash(fsPath(fsys, arg))
+	if info, err := fs.Stat(fsys, fsPath(fsys, arg)); err != nil || !info.IsDir() {
+		name = path.Dir(name)
+	}
+	if name = path.Base(name); name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// namespaceCollisions mounts the files sharing a route under their
+// namespace.
+func namespaceCollisions(files []*MokFile, spaces map[*MokFile]string) {
+	count := make(map[string]int)
+	for _, f := range files {
+		count[f.pattern()]++
+	}
+	var colliding []*MokFile
+	for _, f := range files {
+		if count[f.pattern()] > 1 && spaces[f] != "" {
+			colliding = append(colliding, f)
+		}
+	}
+	for _, f := range colliding {
+		f.URLPath = "/" + spaces[f] + f.URLPath
+	}
+}
+
+// resolveFile resolves a local file, directory, archive, OpenAPI document
+// or git source, remote files are downloaded together beforehand, see downloadAll.
+func resolveFile(fsys fs.FS, remoteCfg *remoteConfig, arg string) ([]*MokFile, error) {
+	if isGit(arg) {
+		return gitFiles(remoteCfg, arg)
+	}
+	arg = fsPath(fsys, arg)
+	info, err := fs.Stat(fsys, arg)
+	if err != nil {
+		return nil, fmt.Errorf("checking file: %w", err)
+	}
+	if info.IsDir() {
+		return walkDir(fsys, arg)
+	}
+	if kind := archiveKind(arg, ""); kind != "" {
+		data, err := fs.ReadFile(fsys, arg)
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+		return archiveFiles(arg, kind, data)
+	}
+	if isOpenAPI(fsys, arg) {
+		return openAPIFiles(fsys, arg, remoteCfg.logger())
+	}
+
+	file := newMokFile(arg, "/"+path.Base(filepath.ToSlash(arg)))
+	file.fsys = fsys
+	return []*MokFile{file}, nil
+}
+
+// remoteFiles serves a downloaded remote file at its name, or the fixtures
+// of a downloaded archive. archives are read in memory, their temp file is
+// removed right away.
+func remoteFiles(src *remoteSource) ([]*MokFile, error) {
+	if src.archive == "" {
+		return []*MokFile{remoteFile(src)}, nil
+	}
+	data, err := os.ReadFile(src.path)
+	if src.temp {
+		os.Remove(src.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	u, err := url.Parse(src.url)
+	if err != nil {
+		return nil, fmt.Errorf("parse URL: %w", err)
+	}
+	return archiveFiles(u.Host+u.Path, src.archive, data)
+}
+
+// remoteFile serves a downloaded remote file at its name.
+func remoteFile(src *remoteSource) *MokFile {
+	file := newMokFile(src.path, "/"+filepath.Base(src.path))
+	file.temp, file.remote = src.temp, src
+	return file
+}
+
+var httpMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// newMokFile mounts filePath at urlPath, applying the file name conventions:
+//
+//	users.json           -> /users.json (any method)
+//	users.POST.json      -> POST /users.json
+//	error.500.json       -> /error.json, responds with 500
+//	users.POST.201.json  -> POST /users.json, responds with 201
+//	users/{id}.json      -> /users/{id}, a path parameter route
+//	users.yaml           -> /users.json, converted to json, see convertFixture
+func newMokFile(filePath, urlPath string) *MokFile {
+	file := &MokFile{FilePath: filePath}
+
+	dir, base := path.Split(urlPath)
+	ext := path.Ext(base)
+	parts := strings.Split(strings.TrimSuffix(base, ext), ".")
+	if isConverted(base) {
+		ext = ".json"
+	}
+
+	for n := len(parts); n > 1; n = len(parts) {
+		last := parts[n-1]
+		if status, err := strconv.Atoi(last); err == nil && len(last) == 3 && file.Status == 0 {
+			if status < 100 || status > 599 {
+				break
+			}
+			file.Status = status
+		} else if httpMethods[last] && file.Method == "" {
+			file.Method = last
+		} else {
+			break
+		}
+		parts = parts[:n-1]
+	}
+
+	name := strings.Join(parts, ".")
+	if wildcardRe.MatchString(name) {
+		// wildcards must be whole path segments
+		ext = ""
+	}
+
+	file.URLPath = dir + name + ext
+	return file
+}
+
+// prettyURLs serves the fixtures without their extension, users.json at
+// /users, and at the path with the extension too when keepExt is set.
+// routes generated in memory keep their paths.
+func prettyURLs(files []*MokFile, keepExt bool) {
+	for _, f := range files {
+		ext := path.Ext(f.URLPath)
+		if f.inline || ext == "" || strings.Contains(ext, "/") {
+			continue
+		}
+		if keepExt {
+			f.alias = f.URLPath
+		}
+		f.URLPath = strings.TrimSuffix(f.URLPath, ext)
+	}
+}
+
+// routeConflict reports the first two routes answering the same pattern,
+// the mux would refuse the second.
+func routeConflict(files []*MokFile) error {
+	served := make(map[string]*MokFile)
+	for _, f := range files {
+		for _, p := range f.patterns() {
+			if other, ok := served[p]; ok && other != f {
+				return fmt.Errorf("%s and %s are both served at %s", other.FilePath, f.FilePath, p)
+			}
+			served[p] = f
+		}
+	}
+	return nil
+}
+
+func isRemote(arg string) bool {
+	return strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") || isBucket(arg)
+}
+
+// walkDir mounts every fixture below root, json and the converted formats, the URL path is the
+// file path relative to root: fixtures/users/list.json -> /users/list.json.
+// configs and OpenAPI documents are not fixtures, they are skipped.
+func walkDir(fsys fs.FS, root string) ([]*MokFile, error) {
+	var files []*MokFile
+
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isFixture(name) {
+			return nil
+		}
+		if isYAML(name) && (path.Base(name) == DefaultConfigFile || isOpenAPI(fsys, name)) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		file := newMokFile(name, "/"+filepath.ToSlash(rel))
+		file.fsys = fsys
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking directory: %w", err)
+	}
+
+	return files, nil
+}
+
+// inlineFile serves content, json passed on the command line, at urlPath.
+func inlineFile(urlPath string, content []byte) (*MokFile, error) {
+	if !strings.HasPrefix(urlPath, "/") {
+		return nil, fmt.Errorf("inline json: path must start with /, got %q", urlPath)
+	}
+	if !json.Valid(content) {
+		return nil, fmt.Errorf("inline json for %s is not valid json", urlPath)
+	}
+	if urlPath == "/" {
+		urlPath = "/{$}"
+	}
+	return &MokFile{
+		FilePath: "inline json",
+		URLPath:  urlPath,
+		Headers:  map[string]string{"Content-Type": "application/json"},
+		inline:   true,
+		content:  content,
+	}, nil
+}
+
+func serveDirectInput(w http.ResponseWriter, input []byte) {
+	var dat map[string]any
+	if err := json.Unmarshal(input, &dat); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(dat)
+}
@@ -0,0 +1,90 @@
+package mok
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ClientCerts says what happens to clients without a valid certificate
+// when WithClientCerts is on: "require" fails the tls handshake, "optional"
+// only fails it for invalid certificates and "status" lets every client
+// in and answers 401 to the ones without a certificate and 403 to the ones
+// with an invalid one, for clients that should see an http error.
+type ClientCerts string
+
+const (
+	ClientCertsRequire  ClientCerts = "require"
+	ClientCertsOptional ClientCerts = "optional"
+	ClientCertsStatus   ClientCerts = "status"
+)
+
+func (c ClientCerts) String() string { return string(c) }
+
+// Set implements flag.Value.
+func (c *ClientCerts) Set(s string) error {
+	switch mode := ClientCerts(strings.ToLower(strings.TrimSpace(s))); mode {
+	case ClientCertsRequire, ClientCertsOptional, ClientCertsStatus:
+		*c = mode
+		return nil
+	}
+	return fmt.Errorf("invalid client cert mode %q, expected require, optional or status", s)
+}
+
+// LoadCertPool reads the pem certificates in path, e.g. the CA signing the
+// client certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no pem certificates in %s", path)
+	}
+	return pool, nil
+}
+
+// clientAuthTLS is cfg asking for client certificates signed by cas.
+func clientAuthTLS(cfg *tls.Config, cas *x509.CertPool, mode ClientCerts) *tls.Config {
+	cfg = cfg.Clone()
+	cfg.ClientCAs = cas
+	switch mode {
+	case ClientCertsOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientCertsStatus:
+		// verified by withClientCerts, the handshake takes anything
+		cfg.ClientAuth = tls.RequestClientCert
+	default:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// withClientCerts answers 401 to requests without a client certificate and
+// 403 to the ones whose certificate is not signed by cas.
+func withClientCerts(next http.Handler, cas *x509.CertPool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		certs := r.TLS.PeerCertificates
+		opts := x509.VerifyOptions{
+			Roots:         cas,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		if _, err := certs[0].Verify(opts); err != nil {
+			http.Error(w, "invalid client certificate: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
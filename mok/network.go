@@ -0,0 +1,74 @@
+package mok
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Network is a network condition, the latency and jitter of every
+// response, the bandwidth it is written with and the share of requests
+// whose connection is dropped without an answer.
+type Network struct {
+	Name      string
+	Delay     Delay
+	Bandwidth Bandwidth
+	Drop      float64
+}
+
+// networks are the -network presets, loosely based on the throttling
+// profiles of browser dev tools.
+var networks = map[string]Network{
+	"offline":    {Drop: 1},
+	"2g":         {Delay: Delay{650 * time.Millisecond, 150 * time.Millisecond}, Bandwidth: mustBandwidth("250kbps"), Drop: 0.02},
+	"slow-3g":    {Delay: Delay{2 * time.Second, 300 * time.Millisecond}, Bandwidth: mustBandwidth("400kbps"), Drop: 0.02},
+	"3g":         {Delay: Delay{560 * time.Millisecond, 100 * time.Millisecond}, Bandwidth: mustBandwidth("1.6mbps"), Drop: 0.01},
+	"4g":         {Delay: Delay{70 * time.Millisecond, 20 * time.Millisecond}, Bandwidth: mustBandwidth("9mbps"), Drop: 0.005},
+	"wifi":       {Delay: Delay{20 * time.Millisecond, 5 * time.Millisecond}, Bandwidth: mustBandwidth("30mbps")},
+	"flaky-wifi": {Delay: Delay{80 * time.Millisecond, 70 * time.Millisecond}, Bandwidth: mustBandwidth("5mbps"), Drop: 0.05},
+}
+
+func mustBandwidth(s string) Bandwidth {
+	b, err := parseBandwidth(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// NetworkNames lists the presets accepted by Network.Set.
+func NetworkNames() []string {
+	return slices.Sorted(maps.Keys(networks))
+}
+
+func (n Network) String() string { return n.Name }
+
+// Set implements flag.Value, s is the name of a preset.
+func (n *Network) Set(s string) error {
+	preset, ok := networks[strings.ToLower(s)]
+	if !ok {
+		return fmt.Errorf("unknown network %q, expected one of %s", s, strings.Join(NetworkNames(), ", "))
+	}
+	*n = preset
+	n.Name = strings.ToLower(s)
+	return nil
+}
+
+// withDrop drops the connection of a share of the requests without
+// answering, like a lost packet the client only notices through its
+// timeouts or a reset. the admin API and the dashboard always answer.
+func withDrop(next http.Handler, share float64, l *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/__mok__/") && rand.Float64() < share {
+			logInfo(l, fmt.Sprintf("network: dropping %s %s", r.Method, r.URL))
+			// net/http closes the connection quietly
+			panic(http.ErrAbortHandler)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,229 @@
+package mok
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// codeLifetime is how long authorization codes can be exchanged for tokens.
+const codeLifetime = 10 * time.Minute
+
+// OAuthClient is a client of the authorization code flow, see WithOAuthClients:
+//
+//	clients:
+//	  - id: web
+//	    secret: s3cret # public clients using PKCE have none
+//	    redirect_uris: [http://localhost:3000/callback]
+//	    claims: {role: admin}
+type OAuthClient struct {
+	ID           string         `yaml:"id" json:"id"`
+	Secret       string         `yaml:"secret,omitempty" json:"secret,omitempty"`
+	RedirectURIs []string       `yaml:"redirect_uris,omitempty" json:"redirect_uris,omitempty"`
+	Claims       map[string]any `yaml:"claims,omitempty" json:"claims,omitempty"`
+}
+
+// oauthGrant is what an authorization code or a refresh token stands for.
+type oauthGrant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	method      string
+	claims      map[string]any
+	expires     time.Time
+}
+
+// oauthFlow is the state of the authorization code flow, codes can be used
+// once, refresh tokens until mok stops.
+type oauthFlow struct {
+	clients map[string]OAuthClient
+
+	mu      sync.Mutex
+	codes   map[string]oauthGrant
+	refresh map[string]oauthGrant
+}
+
+func newOAuthFlow(clients []OAuthClient) *oauthFlow {
+	f := &oauthFlow{
+		clients: make(map[string]OAuthClient),
+		codes:   make(map[string]oauthGrant),
+		refresh: make(map[string]oauthGrant),
+	}
+	for _, c := range clients {
+		f.clients[c.ID] = c
+	}
+	return f
+}
+
+// client looks up id, any client is welcome when none are configured.
+func (f *oauthFlow) client(id string) (OAuthClient, bool) {
+	if len(f.clients) == 0 {
+		return OAuthClient{ID: id}, id != ""
+	}
+	c, ok := f.clients[id]
+	return c, ok
+}
+
+// authorize approves every request right away and redirects back with a
+// code, there is no login page. login_hint becomes the subject:
+//
+//	GET /oauth/authorize?response_type=code&client_id=web&redirect_uri=...&state=xyz
+func (o *oidcIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	client, ok := o.flow.client(q.Get("client_id"))
+	if !ok {
+		// never redirect to an unverified uri, tell the user instead
+		oauthError(w, http.StatusBadRequest, "invalid_client", fmt.Sprintf("unknown client_id %q", q.Get("client_id")))
+		return
+	}
+
+	redirectURI := q.Get("redirect_uri")
+	switch {
+	case redirectURI == "" && len(client.RedirectURIs) == 1:
+		redirectURI = client.RedirectURIs[0]
+	case redirectURI == "":
+		oauthError(w, http.StatusBadRequest, "invalid_request", "missing redirect_uri")
+		return
+	case len(client.RedirectURIs) > 0 && !slices.Contains(client.RedirectURIs, redirectURI):
+		oauthError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("redirect_uri %q is not registered for %s", redirectURI, client.ID))
+		return
+	}
+	target, err := url.Parse(redirectURI)
+	if err != nil || !target.IsAbs() {
+		oauthError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid redirect_uri %q", redirectURI))
+		return
+	}
+
+	params := url.Values{}
+	if state := q.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	method := q.Get("code_challenge_method")
+	switch {
+	case q.Get("response_type") != "code":
+		params.Set("error", "unsupported_response_type")
+	case q.Get("code_challenge") != "" && method != "" && method != "plain" && method != "S256":
+		params.Set("error", "invalid_request")
+		params.Set("error_description", "unsupported code_challenge_method "+method)
+	default:
+		claims := maps.Clone(client.Claims)
+		if claims == nil {
+			claims = map[string]any{}
+		}
+		for name, param := range map[string]string{"sub": "login_hint", "scope": "scope", "nonce": "nonce"} {
+			if v := q.Get(param); v != "" {
+				claims[name] = v
+			}
+		}
+		claims["aud"] = client.ID
+
+		code := randomToken()
+		o.flow.mu.Lock()
+		o.flow.codes[code] = oauthGrant{
+			clientID:    client.ID,
+			redirectURI: q.Get("redirect_uri"),
+			challenge:   q.Get("code_challenge"),
+			method:      method,
+			claims:      claims,
+			expires:     time.Now().Add(codeLifetime),
+		}
+		o.flow.mu.Unlock()
+		params.Set("code", code)
+	}
+
+	query := target.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// exchange handles the authorization_code and refresh_token grants.
+func (o *oidcIssuer) exchange(w http.ResponseWriter, r *http.Request, clientID string) {
+	grantType := r.PostForm.Get("grant_type")
+
+	o.flow.mu.Lock()
+	var (
+		grant oauthGrant
+		ok    bool
+	)
+	if grantType == "authorization_code" {
+		code := r.PostForm.Get("code")
+		grant, ok = o.flow.codes[code]
+		delete(o.flow.codes, code)
+	} else {
+		grant, ok = o.flow.refresh[r.PostForm.Get("refresh_token")]
+	}
+	o.flow.mu.Unlock()
+
+	switch {
+	case !ok || (grantType == "authorization_code" && time.Now().After(grant.expires)):
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired "+grantType)
+		return
+	case clientID != "" && clientID != grant.clientID:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the grant was issued to another client")
+		return
+	case grantType == "authorization_code" && r.PostForm.Get("redirect_uri") != grant.redirectURI:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	case grantType == "authorization_code" && !verifyChallenge(grant, r.PostForm.Get("code_verifier")):
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
+		return
+	}
+
+	refreshToken := randomToken()
+	o.flow.mu.Lock()
+	o.flow.refresh[refreshToken] = grant
+	o.flow.mu.Unlock()
+
+	o.issue(w, r, grant.claims, tokenLifetime, map[string]any{"refresh_token": refreshToken})
+}
+
+// authenticate checks the client credentials of a token request, from basic
+// auth or the form. configured clients with a secret must send it.
+func (o *oidcIssuer) authenticate(r *http.Request) (string, error) {
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if len(o.flow.clients) == 0 {
+		return id, nil
+	}
+
+	client, ok := o.flow.clients[id]
+	if !ok {
+		return "", fmt.Errorf("unknown client_id %q", id)
+	}
+	if client.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1 {
+		return "", fmt.Errorf("invalid client_secret for %s", id)
+	}
+	return id, nil
+}
+
+// verifyChallenge checks the PKCE code_verifier against the challenge of the
+// authorization request, if it had one.
+func verifyChallenge(grant oauthGrant, verifier string) bool {
+	if grant.challenge == "" {
+		return true
+	}
+	if grant.method == "S256" {
+		sum := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(verifier), []byte(grant.challenge)) == 1
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
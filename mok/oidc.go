@@ -0,0 +1,270 @@
+package mok
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenLifetime is how long minted tokens are valid unless the token
+// request asks for something else with expires_in.
+const tokenLifetime = time.Hour
+
+// oidcIssuer is a fake OpenID Connect provider behind -oidc, it mints RS256
+// JWTs that verify against its JWKS:
+//
+//	GET  /.well-known/openid-configuration  discovery document
+//	GET  /.well-known/jwks.json             the signing key
+//	GET  /oauth/authorize                   the authorization code flow, see authorize
+//	POST /oauth/token                       mints tokens
+//	GET  /oauth/userinfo                    the claims of the bearer token
+//
+// the issuer is the url the request came in on, so tokens stay valid with
+// -p 0 and behind -host. the key is generated on start and never stored.
+type oidcIssuer struct {
+	key    *rsa.PrivateKey
+	kid    string
+	claims map[string]any
+	flow   *oauthFlow
+}
+
+func newOIDCIssuer(claims map[string]any, clients []OAuthClient) (*oidcIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: generate key: %w", err)
+	}
+	sum := sha256.Sum256(key.PublicKey.N.Bytes())
+	return &oidcIssuer{
+		key:    key,
+		kid:    base64.RawURLEncoding.EncodeToString(sum[:8]),
+		claims: claims,
+		flow:   newOAuthFlow(clients),
+	}, nil
+}
+
+func (o *oidcIssuer) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /.well-known/openid-configuration", o.discovery)
+	mux.HandleFunc("GET /.well-known/jwks.json", o.jwks)
+	mux.HandleFunc("GET /oauth/authorize", o.authorize)
+	mux.HandleFunc("POST /oauth/token", o.token)
+	mux.HandleFunc("GET /oauth/userinfo", o.userinfo)
+}
+
+// issuerURL is the base url r was sent to.
+func issuerURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (o *oidcIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	iss := issuerURL(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss,
+		"jwks_uri":                              iss + "/.well-known/jwks.json",
+		"authorization_endpoint":                iss + "/oauth/authorize",
+		"token_endpoint":                        iss + "/oauth/token",
+		"userinfo_endpoint":                     iss + "/oauth/userinfo",
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials", "password", "refresh_token"},
+		"response_types_supported":              []string{"code"},
+		"code_challenge_methods_supported":      []string{"plain", "S256"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+	})
+}
+
+func (o *oidcIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := o.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]any{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": o.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// token mints an access and an id token. authorization codes and refresh
+// tokens are exchanged for the claims they were issued with, any other
+// grant is accepted without checking the user credentials, its claims are
+// the configured ones, then sub, scope and the json object in claims from
+// the form:
+//
+//	curl -d sub=rob -d scope=openid -d 'claims={"role":"admin"}' localhost:9172/oauth/token
+func (o *oidcIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	clientID, err := o.authenticate(r)
+	if err != nil {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code", "refresh_token":
+		o.exchange(w, r, clientID)
+		return
+	}
+
+	lifetime := tokenLifetime
+	if raw := r.PostForm.Get("expires_in"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			oauthError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid expires_in %q", raw))
+			return
+		}
+		lifetime = time.Duration(n) * time.Second
+	}
+
+	extra := map[string]any{}
+	if client, ok := o.flow.clients[clientID]; ok {
+		maps.Copy(extra, client.Claims)
+	}
+	if raw := r.PostForm.Get("claims"); raw != "" {
+		// null decodes into a nil map
+		var requested map[string]any
+		if err := json.Unmarshal([]byte(raw), &requested); err != nil || requested == nil {
+			oauthError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("claims must be a json object, got %s", raw))
+			return
+		}
+		maps.Copy(extra, requested)
+	}
+	for _, name := range []string{"sub", "scope", "nonce"} {
+		if v := r.PostForm.Get(name); v != "" {
+			extra[name] = v
+		}
+	}
+	if clientID != "" {
+		extra["aud"] = clientID
+	}
+
+	o.issue(w, r, extra, lifetime, nil)
+}
+
+// issue answers a token request with tokens carrying claims on top of the
+// configured ones, fields are added to the response.
+func (o *oidcIssuer) issue(w http.ResponseWriter, r *http.Request, claims map[string]any, lifetime time.Duration, fields map[string]any) {
+	now := time.Now()
+	all := map[string]any{
+		"iss": issuerURL(r),
+		"sub": "mok-user",
+		"aud": "mok",
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+	}
+	maps.Copy(all, o.claims)
+	maps.Copy(all, claims)
+
+	token, err := o.sign(all)
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	resp := map[string]any{
+		"access_token": token,
+		"id_token":     token,
+		"token_type":   "Bearer",
+		"expires_in":   int(lifetime.Seconds()),
+	}
+	if scope, ok := all["scope"]; ok {
+		resp["scope"] = scope
+	}
+	maps.Copy(resp, fields)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (o *oidcIssuer) userinfo(w http.ResponseWriter, r *http.Request) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mok"`)
+		oauthError(w, http.StatusUnauthorized, "invalid_token", "missing bearer token")
+		return
+	}
+	claims, err := o.verify(token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mok", error="invalid_token"`)
+		oauthError(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, claims)
+}
+
+// sign encodes claims as an RS256 JWT.
+func (o *oidcIssuer) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": o.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, o.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + b64(sig), nil
+}
+
+// verify checks that token was signed by o and has not expired.
+func (o *oidcIssuer) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&o.key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, errors.New("invalid signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() > int64(exp) {
+		return nil, errors.New("token expired")
+	}
+	return claims, nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oauthError writes an RFC 6749 error response.
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
@@ -0,0 +1,35 @@
+package mok
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTokenClaims(t *testing.T) {
+	o, err := newOIDCIssuer(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		claims string
+		status int
+	}{
+		{`{"role":"admin"}`, http.StatusOK},
+		{`null`, http.StatusBadRequest},
+		{`[1, 2]`, http.StatusBadRequest},
+		{`"admin"`, http.StatusBadRequest},
+		{`42`, http.StatusBadRequest},
+	} {
+		form := url.Values{"sub": {"rob"}, "claims": {tc.claims}}
+		r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		o.token(w, r)
+		if w.Code != tc.status {
+			t.Errorf("claims=%s: got %d, want %d: %s", tc.claims, w.Code, tc.status, w.Body)
+		}
+	}
+}
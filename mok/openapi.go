@@ -0,0 +1,404 @@
+package mok
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIDoc is an OpenAPI 3 document, kept as generic maps since mok only
+// needs a handful of fields and has to follow $refs anyway.
+type openAPIDoc struct {
+	path string
+	root map[string]any
+	// log is where the $refs that cannot be resolved are logged.
+	log *log.Logger
+}
+
+// openAPIOperation is a single path and method of the document, together
+// with the response mok serves for it.
+type openAPIOperation struct {
+	Method    string
+	Path      string
+	Status    int
+	MediaType string
+	Schema    map[string]any
+	Example   any
+	// HasExample is false when Example was synthesized from the schema.
+	HasExample bool
+}
+
+// isOpenAPI reports whether the file at path looks like an OpenAPI 3
+// document, only yaml and json files are considered.
+func isOpenAPI(fsys fs.FS, path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return false
+	}
+
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return false
+	}
+	var head struct {
+		OpenAPI string `yaml:"openapi"`
+	}
+	if yaml.Unmarshal(data, &head) != nil {
+		return false
+	}
+	return strings.HasPrefix(head.OpenAPI, "3.")
+}
+
+func loadOpenAPI(fsys fs.FS, path string) (*openAPIDoc, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading openapi document: %w", err)
+	}
+
+	// yaml is a superset of json, one parser for both
+	var root any
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parsing openapi document %q: %w", path, err)
+	}
+	return &openAPIDoc{path: path, root: asMap(normalizeYAML(root))}, nil
+}
+
+// normalizeYAML converts the map[any]any yaml produces for mappings with
+// non-string keys (responses: {200: ...}) into map[string]any.
+func normalizeYAML(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalizeYAML(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = normalizeYAML(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// resolve follows local $refs (#/components/schemas/User), external
+// documents are not supported.
+func (doc *openAPIDoc) resolve(v map[string]any) map[string]any {
+	for seen := 0; seen < 32; seen++ {
+		ref, ok := v["$ref"].(string)
+		if !ok {
+			return v
+		}
+		target, ok := doc.pointer(ref).(map[string]any)
+		if !ok {
+			logInfo(doc.log, fmt.Sprintf("openapi: cannot resolve $ref %q", ref))
+			return map[string]any{}
+		}
+		v = target
+	}
+	return v
+}
+
+// pointer evaluates a local json pointer such as #/components/schemas/User.
+func (doc *openAPIDoc) pointer(ref string) any {
+	p, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil
+	}
+
+	var cur any = doc.root
+	for _, token := range strings.Split(p, "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[token]
+	}
+	return cur
+}
+
+// basePath is the path of the first server url, e.g. /v1 for
+// https://api.example.com/v1.
+func (doc *openAPIDoc) basePath() string {
+	servers, _ := doc.root["servers"].([]any)
+	if len(servers) == 0 {
+		return ""
+	}
+	server, _ := servers[0].(map[string]any)
+	raw, _ := server["url"].(string)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch"}
+
+// operations lists every path and method with its preferred response: the
+// lowest 2xx, otherwise default, otherwise the first one declared.
+func (doc *openAPIDoc) operations() []openAPIOperation {
+	paths, _ := doc.root["paths"].(map[string]any)
+	base := doc.basePath()
+
+	var ops []openAPIOperation
+	for _, p := range slices.Sorted(maps.Keys(paths)) {
+		item, _ := paths[p].(map[string]any)
+		item = doc.resolve(item)
+
+		for _, method := range openAPIMethods {
+			op, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			responses, _ := op["responses"].(map[string]any)
+			code := preferredResponse(responses)
+			if code == "" {
+				continue
+			}
+
+			resp := doc.resolve(asMap(responses[code]))
+			operation := openAPIOperation{
+				Method: strings.ToUpper(method),
+				Path:   base + p,
+			}
+			if status, err := strconv.Atoi(code); err == nil {
+				operation.Status = status
+			}
+			doc.responseBody(resp, &operation)
+			ops = append(ops, operation)
+		}
+	}
+	return ops
+}
+
+func preferredResponse(responses map[string]any) string {
+	codes := slices.Sorted(maps.Keys(responses))
+	for _, code := range codes {
+		if strings.HasPrefix(code, "2") {
+			return code
+		}
+	}
+	if _, ok := responses["default"]; ok {
+		return "default"
+	}
+	if len(codes) > 0 {
+		return codes[0]
+	}
+	return ""
+}
+
+// responseBody picks the json media type of resp and fills the example,
+// synthesizing one from the schema when the document has none.
+func (doc *openAPIDoc) responseBody(resp map[string]any, op *openAPIOperation) {
+	content := asMap(resp["content"])
+	mediaType := ""
+	for _, mt := range slices.Sorted(maps.Keys(content)) {
+		if mt == "application/json" || strings.HasSuffix(mt, "+json") {
+			mediaType = mt
+			break
+		}
+	}
+	if mediaType == "" {
+		return
+	}
+
+	media := asMap(content[mediaType])
+	op.MediaType = mediaType
+	op.Schema = doc.resolve(asMap(media["schema"]))
+
+	if example, ok := media["example"]; ok {
+		op.Example, op.HasExample = example, true
+		return
+	}
+	if examples := asMap(media["examples"]); len(examples) > 0 {
+		first := doc.resolve(asMap(examples[slices.Sorted(maps.Keys(examples))[0]]))
+		if value, ok := first["value"]; ok {
+			op.Example, op.HasExample = value, true
+			return
+		}
+	}
+	op.Example = doc.synthesize(op.Schema, 0)
+}
+
+// synthesize builds a value conforming to schema, preferring the values
+// the schema suggests (example, default, enum) over made up ones.
+func (doc *openAPIDoc) synthesize(schema map[string]any, depth int) any {
+	schema = doc.resolve(schema)
+	if depth > 8 {
+		return nil
+	}
+
+	if v, ok := schema["example"]; ok {
+		return v
+	}
+	if v, ok := schema["default"]; ok {
+		return v
+	}
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		return enum[0]
+	}
+
+	if all, ok := schema["allOf"].([]any); ok {
+		merged := map[string]any{}
+		for _, s := range all {
+			if obj, ok := doc.synthesize(asMap(s), depth+1).(map[string]any); ok {
+				maps.Copy(merged, obj)
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alts, ok := schema[key].([]any); ok && len(alts) > 0 {
+			return doc.synthesize(asMap(alts[0]), depth+1)
+		}
+	}
+
+	typ, _ := schema["type"].(string)
+	if types, ok := schema["type"].([]any); ok && len(types) > 0 {
+		// 3.1 allows a list of types
+		typ, _ = types[0].(string)
+	}
+	if typ == "" {
+		switch {
+		case schema["properties"] != nil:
+			typ = "object"
+		case schema["items"] != nil:
+			typ = "array"
+		}
+	}
+
+	switch typ {
+	case "object":
+		obj := map[string]any{}
+		props := asMap(schema["properties"])
+		for _, name := range slices.Sorted(maps.Keys(props)) {
+			obj[name] = doc.synthesize(asMap(props[name]), depth+1)
+		}
+		return obj
+	case "array":
+		return []any{doc.synthesize(asMap(schema["items"]), depth+1)}
+	case "integer":
+		return cmp.Or(toInt(schema["minimum"]), 1)
+	case "number":
+		return cmp.Or(float64(toInt(schema["minimum"])), 1.5)
+	case "boolean":
+		return true
+	case "string":
+		return exampleString(schema)
+	default:
+		return nil
+	}
+}
+
+func exampleString(schema map[string]any) string {
+	format, _ := schema["format"].(string)
+	switch format {
+	case "date-time":
+		return "2006-01-02T15:04:05Z"
+	case "date":
+		return "2006-01-02"
+	case "email":
+		return "rob@example.com"
+	case "uuid":
+		return "0b6f1c9e-6ad8-4a57-9b2e-3f6f0c5f2a1d"
+	case "uri", "url":
+		return "https://example.com"
+	case "ipv4":
+		return "127.0.0.1"
+	}
+	return "string"
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	if m == nil {
+		return map[string]any{}
+	}
+	return m
+}
+
+func toInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// invalidWildcardRe matches characters ServeMux does not allow in wildcard
+// names, OpenAPI is more lenient: /users/{user-id}.
+var invalidWildcardRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+var openAPIParamRe = regexp.MustCompile(`\{([^}/]+)\}`)
+
+// muxPath turns an OpenAPI path template into a ServeMux pattern path.
+func muxPath(p string) string {
+	return openAPIParamRe.ReplaceAllStringFunc(p, func(m string) string {
+		name := invalidWildcardRe.ReplaceAllString(m[1:len(m)-1], "_")
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+		return "{" + name + "}"
+	})
+}
+
+// openAPIFiles mounts every operation of the document at path.
+func openAPIFiles(fsys fs.FS, path string, l *log.Logger) ([]*MokFile, error) {
+	doc, err := loadOpenAPI(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*MokFile
+	for _, op := range doc.operations() {
+		var content []byte
+		if op.MediaType != "" {
+			if content, err = json.MarshalIndent(op.Example, "", "  "); err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %w", op.Method, op.Path, err)
+			}
+		}
+
+		file := &MokFile{
+			FilePath: fmt.Sprintf("%s %s %s", path, op.Method, op.Path),
+			URLPath:  muxPath(op.Path),
+			Method:   op.Method,
+			Status:   op.Status,
+			inline:   true,
+			content:  content,
+		}
+		if file.Status == http.StatusOK {
+			file.Status = 0
+		}
+		if op.MediaType != "" {
+			file.Headers = map[string]string{"Content-Type": op.MediaType}
+		}
+		files = append(files, file)
+	}
+
+	logInfo(l, fmt.Sprintf("openapi: %d routes from %q", len(files), path))
+	return files, nil
+}
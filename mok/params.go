@@ -0,0 +1,50 @@
+package mok
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// placeholderRe matches {name} placeholders in route file paths, e.g.
+//
+//	path: /users/{id}
+//	file: fixtures/users/{id}.json
+//
+// serves fixtures/users/42.json on /users/42.
+var placeholderRe = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// wildcardRe matches a ServeMux wildcard segment such as {id} or {path...}.
+var wildcardRe = regexp.MustCompile(`^\{[A-Za-z_][A-Za-z0-9_]*(\.\.\.)?\}$`)
+
+// checkPlaceholders makes sure every placeholder in filePath has a matching
+// wildcard in urlPath.
+func checkPlaceholders(urlPath, filePath string) error {
+	wildcards := make(map[string]bool)
+	for _, name := range patternWildcards(urlPath) {
+		wildcards[name] = true
+	}
+
+	for _, m := range placeholderRe.FindAllStringSubmatch(filePath, -1) {
+		if !wildcards[m[1]] {
+			return fmt.Errorf("file placeholder %s has no matching wildcard in path", m[0])
+		}
+	}
+	return nil
+}
+
+// paramFilePath resolves the placeholders in the file path with the path
+// parameters of r.
+func (f *MokFile) paramFilePath(r *http.Request) (string, error) {
+	var err error
+	filePath := placeholderRe.ReplaceAllStringFunc(f.FilePath, func(m string) string {
+		value := r.PathValue(m[1 : len(m)-1])
+		// path params end up in a file path, don't let them walk out of it
+		if value == "" || value == "." || value == ".." || strings.ContainsAny(value, `/\`) {
+			err = fmt.Errorf("invalid path parameter %q", value)
+		}
+		return value
+	})
+	return filePath, err
+}
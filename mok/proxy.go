@@ -0,0 +1,35 @@
+package mok
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+func parseTarget(target string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid target %q, expected an http:// or https:// URL", target)
+	}
+	return u, nil
+}
+
+// newProxy forwards requests to target, the request path is appended to
+// the target path.
+func newProxy(target *url.URL, l *log.Logger) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			if s := spanFrom(pr.In.Context()); s != nil {
+				pr.Out.Header.Set("traceparent", s.traceparent())
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logInfo(l, fmt.Sprintf("proxy: %s %s: %s", r.Method, r.URL, err))
+			http.Error(w, "mok: upstream error: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+}
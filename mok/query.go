@@ -0,0 +1,271 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultPageLimit is the page size when only _page is passed.
+const defaultPageLimit = 10
+
+// hasArrayQuery reports whether r may ask to reshape an array response, any
+// query parameter might: everything that is not a _directive is a filter on
+// the field of the items it names, see filterItems.
+func hasArrayQuery(r *http.Request) bool {
+	return r.URL.RawQuery != ""
+}
+
+// queryItems filters, sorts and paginates items, json-server style, and
+// selects their fields:
+//
+//	?title=mok&author.name=rob  keep items whose fields match (repeat for OR)
+//	?views_gte=10&views_lte=20   range filters, also _ne and _like (regexp)
+//	?_sort=views,title&_order=desc,asc
+//	?_page=2&_limit=10
+//	?fields=id,title             see fieldMask
+func queryItems(w http.ResponseWriter, r *http.Request, items []any) ([]any, error) {
+	mask, err := requestFields(r)
+	if err != nil {
+		return nil, err
+	}
+	if items, err = filterItems(items, arrayQuery(r)); err != nil {
+		return nil, err
+	}
+	if err := sortItems(items, r.URL.Query()); err != nil {
+		return nil, err
+	}
+	if items, err = paginate(w, r, items); err != nil {
+		return nil, err
+	}
+	return mask.apply(items).([]any), nil
+}
+
+// arrayQuery is the query of r without the parameters a rule matched on,
+// ?debug=1 picking a rule does not filter its response, templates and echo
+// still see them.
+func arrayQuery(r *http.Request) url.Values {
+	query := r.URL.Query()
+	matched, _ := r.Context().Value(matchedQueryKey{}).(map[string]string)
+	for name := range matched {
+		query.Del(name)
+	}
+	return query
+}
+
+type itemFilter struct {
+	field  []string
+	op     string
+	values []string
+	re     []*regexp.Regexp
+}
+
+func filterItems(items []any, q url.Values) ([]any, error) {
+	var filters []itemFilter
+	for key, values := range q {
+		if strings.HasPrefix(key, "_") || key == "fields" {
+			continue
+		}
+
+		f := itemFilter{op: "eq", values: values}
+		for _, op := range []string{"_gte", "_lte", "_ne", "_like"} {
+			if name, found := strings.CutSuffix(key, op); found {
+				key, f.op = name, op[1:]
+				break
+			}
+		}
+		if f.op == "like" {
+			for _, v := range values {
+				re, err := regexp.Compile("(?i)" + v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s_like %q: %w", key, v, err)
+				}
+				f.re = append(f.re, re)
+			}
+		}
+		f.field = strings.Split(key, ".")
+		// a field no item has is a cache buster or an api key, not a filter
+		if !slices.ContainsFunc(items, func(item any) bool { _, ok := lookupField(item, f.field); return ok }) {
+			continue
+		}
+		filters = append(filters, f)
+	}
+	if len(filters) == 0 {
+		return items, nil
+	}
+
+	filtered := []any{}
+	for _, item := range items {
+		if slices.IndexFunc(filters, func(f itemFilter) bool { return !f.match(item) }) < 0 {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered, nil
+}
+
+func (f itemFilter) match(item any) bool {
+	value, ok := lookupField(item, f.field)
+	if !ok {
+		// only "not equal" matches missing fields
+		return f.op == "ne"
+	}
+
+	switch f.op {
+	case "ne":
+		return !slices.ContainsFunc(f.values, func(v string) bool { return fmt.Sprint(value) == v })
+	case "gte":
+		return slices.ContainsFunc(f.values, func(v string) bool { return compareValues(value, v) >= 0 })
+	case "lte":
+		return slices.ContainsFunc(f.values, func(v string) bool { return compareValues(value, v) <= 0 })
+	case "like":
+		return slices.ContainsFunc(f.re, func(re *regexp.Regexp) bool { return re.MatchString(fmt.Sprint(value)) })
+	default:
+		return slices.ContainsFunc(f.values, func(v string) bool { return fmt.Sprint(value) == v })
+	}
+}
+
+// lookupField follows a dotted path (author.name) into nested objects.
+func lookupField(item any, path []string) (any, bool) {
+	for _, key := range path {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if item, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return item, true
+}
+
+// compareValues compares numerically when both sides are numbers and as
+// strings otherwise.
+func compareValues(a, b any) int {
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	af, aerr := strconv.ParseFloat(as, 64)
+	bf, berr := strconv.ParseFloat(bs, 64)
+	if aerr == nil && berr == nil {
+		return cmp.Compare(af, bf)
+	}
+	return strings.Compare(as, bs)
+}
+
+func sortItems(items []any, q url.Values) error {
+	if !q.Has("_sort") {
+		return nil
+	}
+
+	fields := strings.Split(q.Get("_sort"), ",")
+	var orders []string
+	if q.Has("_order") {
+		orders = strings.Split(q.Get("_order"), ",")
+	}
+	for _, order := range orders {
+		if order != "asc" && order != "desc" {
+			return fmt.Errorf("invalid _order %q, expected asc or desc", order)
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b any) int {
+		for i, field := range fields {
+			path := strings.Split(field, ".")
+			av, aok := lookupField(a, path)
+			bv, bok := lookupField(b, path)
+
+			c := 0
+			switch {
+			case !aok && !bok:
+			case !aok:
+				c = 1 // missing fields sort last, whatever the order
+			case !bok:
+				c = -1
+			default:
+				c = compareValues(av, bv)
+				// with fewer orders than fields the last order applies
+				if len(orders) > 0 && orders[min(i, len(orders)-1)] == "desc" {
+					c = -c
+				}
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return nil
+}
+
+// decodeArray parses content as a json array, ok is false for anything else
+// so callers can fall back to serving content as is.
+func decodeArray(content []byte) (items []any, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&items); err != nil || items == nil {
+		return nil, false
+	}
+	return items, true
+}
+
+// paginate slices items according to ?_page= (1-based) and ?_limit=, the
+// total count and the links to the other pages are reported in the
+// X-Total-Count and Link headers.
+func paginate(w http.ResponseWriter, r *http.Request, items []any) ([]any, error) {
+	q := r.URL.Query()
+	if !q.Has("_page") && !q.Has("_limit") {
+		return items, nil
+	}
+
+	page, limit := 1, len(items)
+	if q.Has("_page") {
+		limit = defaultPageLimit
+	}
+
+	var err error
+	if q.Has("_page") {
+		if page, err = strconv.Atoi(q.Get("_page")); err != nil || page < 1 {
+			return nil, fmt.Errorf("invalid _page %q", q.Get("_page"))
+		}
+	}
+	if q.Has("_limit") {
+		if limit, err = strconv.Atoi(q.Get("_limit")); err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid _limit %q", q.Get("_limit"))
+		}
+	}
+
+	total := len(items)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	lastPage := max((total+limit-1)/limit, 1)
+	links := []string{pageLink(r, 1, limit, "first")}
+	if page > 1 {
+		links = append(links, pageLink(r, min(page-1, lastPage), limit, "prev"))
+	}
+	if page < lastPage {
+		links = append(links, pageLink(r, page+1, limit, "next"))
+	}
+	links = append(links, pageLink(r, lastPage, limit, "last"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+
+	start := min((page-1)*limit, total)
+	end := min(start+limit, total)
+	return items[start:end], nil
+}
+
+func pageLink(r *http.Request, page, limit int, rel string) string {
+	q := r.URL.Query()
+	q.Set("_page", strconv.Itoa(page))
+	q.Set("_limit", strconv.Itoa(limit))
+
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: q.Encode()}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+}
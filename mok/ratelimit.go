@@ -0,0 +1,138 @@
+package mok
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimit is how many requests a route accepts per period, "10/s",
+// "100/m" or "5/30s". it is a token bucket holding Requests tokens, refilled
+// at Requests per Per, so bursts up to Requests are allowed.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+var rateUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+func parseRateLimit(s string) (RateLimit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return RateLimit{}, nil
+	}
+
+	n, per, found := strings.Cut(s, "/")
+	if !found {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected requests/period, e.g. 10/s", s)
+	}
+	var l RateLimit
+	var err error
+	if l.Requests, err = strconv.Atoi(strings.TrimSpace(n)); err != nil || l.Requests <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, requests must be a positive integer", s)
+	}
+	per = strings.TrimSpace(per)
+	if unit, ok := rateUnits[per]; ok {
+		l.Per = unit
+	} else if l.Per, err = time.ParseDuration(per); err != nil || l.Per <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit period %q", s)
+	}
+	return l, nil
+}
+
+// IsZero reports whether there is no limit, yaml omitempty relies on it.
+func (l RateLimit) IsZero() bool { return l.Requests == 0 }
+
+func (l RateLimit) String() string {
+	if l.IsZero() {
+		return ""
+	}
+	for unit, d := range rateUnits {
+		if l.Per == d {
+			return fmt.Sprintf("%d/%s", l.Requests, unit)
+		}
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// Set implements flag.Value.
+func (l *RateLimit) Set(s string) error {
+	parsed, err := parseRateLimit(s)
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (l RateLimit) MarshalYAML() (any, error) {
+	return l.String(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *RateLimit) UnmarshalYAML(value *yaml.Node) error {
+	return l.Set(value.Value)
+}
+
+// tokenBucket enforces a RateLimit, every request takes a token.
+type tokenBucket struct {
+	limit RateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(l RateLimit) *tokenBucket {
+	return &tokenBucket{limit: l, tokens: float64(l.Requests), last: time.Now()}
+}
+
+// take reports whether a token was available, how many are left and how
+// long until the next one, or until the bucket is full again when it was.
+func (b *tokenBucket) take() (ok bool, remaining int, wait, reset time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	perToken := b.limit.Per / time.Duration(b.limit.Requests)
+	b.tokens = min(float64(b.limit.Requests), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		wait = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	reset = time.Duration((float64(b.limit.Requests) - b.tokens) * float64(perToken))
+	return ok, int(b.tokens), wait, reset
+}
+
+// allow takes a token for r, answering 429 with Retry-After when there is
+// none. X-RateLimit-* headers are set either way.
+func (b *tokenBucket) allow(w http.ResponseWriter, r *http.Request) bool {
+	ok, remaining, wait, reset := b.take()
+
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(b.limit.Requests))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(reset).Unix(), 10))
+	if ok {
+		return true
+	}
+
+	h.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return false
+}
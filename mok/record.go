@@ -0,0 +1,167 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// skippedHeaders never make it into the recorded config, they are either
+// recomputed when replaying or transport noise.
+var skippedHeaders = map[string]bool{
+	"Accept-Ranges":     true,
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Length":    true,
+	"Date":              true,
+	"Keep-Alive":        true,
+	"Set-Cookie":        true,
+	"Transfer-Encoding": true,
+}
+
+type recordPathKey struct{}
+
+// NewRecorder proxies every request to target and saves json responses
+// in dir as fixtures, together with a mok.yaml describing the routes. what
+// was recorded is reported to out.
+func NewRecorder(target, dir string, out io.Writer) (http.Handler, error) {
+	u, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &recorder{dir: dir, out: out, routes: make(map[string]RouteConfig)}
+	proxy := newProxy(u, nil)
+	rewrite := proxy.Rewrite
+	proxy.Rewrite = func(pr *httputil.ProxyRequest) {
+		rewrite(pr)
+		// fixtures are saved as plain json
+		pr.Out.Header.Del("Accept-Encoding")
+	}
+	proxy.ModifyResponse = rec.save
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), recordPathKey{}, r.URL.Path)
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	}), nil
+}
+
+type recorder struct {
+	dir string
+	out io.Writer
+
+	mu     sync.Mutex
+	routes map[string]RouteConfig
+}
+
+// save writes the response body as a fixture, the request path decides the
+// file name: GET /users/1 -> users/1.GET.json. only the latest response of
+// every method and path is kept, query strings are not part of the route.
+func (rec *recorder) save(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	method := resp.Request.Method
+	urlPath, _ := resp.Request.Context().Value(recordPathKey{}).(string)
+	urlPath = cmp.Or(urlPath, resp.Request.URL.Path)
+
+	if !json.Valid(body) {
+		fmt.Fprintf(rec.out, "  skipped %s %s, not json\n", method, urlPath)
+		return nil
+	}
+
+	file := fixtureName(urlPath, method)
+	if err := os.MkdirAll(filepath.Join(rec.dir, filepath.Dir(file)), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(rec.dir, file), body, 0o644); err != nil {
+		return err
+	}
+
+	headers := make(map[string]string)
+	for k, v := range resp.Header {
+		if !skippedHeaders[k] {
+			headers[k] = strings.Join(v, ", ")
+		}
+	}
+
+	// paths ending in / are subtrees for ServeMux, recordings are exact
+	routePath := urlPath
+	if strings.HasSuffix(routePath, "/") {
+		routePath += "{$}"
+	}
+
+	route := RouteConfig{Path: routePath, Method: method}
+	route.File = file
+	route.Headers = headers
+	if resp.StatusCode != http.StatusOK {
+		route.Status = resp.StatusCode
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.routes[method+" "+urlPath] = route
+	if err := rec.writeConfig(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(rec.out, "  recorded %s %s -> %s\n", method, urlPath, filepath.Join(rec.dir, file))
+	return nil
+}
+
+func (rec *recorder) writeConfig() error {
+	var cfg Config
+	for _, key := range slices.Sorted(maps.Keys(rec.routes)) {
+		cfg.Routes = append(cfg.Routes, rec.routes[key])
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(rec.dir, DefaultConfigFile), data, 0o644)
+}
+
+var unsafeSegmentRe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// fixtureName derives a relative file name from a request path, segments
+// are sanitized so that they can't escape the recordings directory.
+func fixtureName(urlPath, method string) string {
+	var segments []string
+	for _, s := range strings.Split(path.Clean("/"+urlPath), "/") {
+		if s == "" {
+			continue
+		}
+		s = unsafeSegmentRe.ReplaceAllString(s, "_")
+		if strings.Trim(s, ".") == "" {
+			s = strings.ReplaceAll(s, ".", "_")
+		}
+		segments = append(segments, s)
+	}
+	if len(segments) == 0 || strings.HasSuffix(urlPath, "/") {
+		segments = append(segments, "index")
+	}
+
+	last := strings.TrimSuffix(segments[len(segments)-1], ".json")
+	segments[len(segments)-1] = last + "." + method + ".json"
+	return filepath.Join(segments...)
+}
@@ -0,0 +1,497 @@
+package mok
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// remoteParallel is how many remote files are downloaded at once.
+	remoteParallel = 4
+	// remoteAttempts is how many times a download failing on the network,
+	// a 5xx or a 429 is tried, waiting remoteBackoff between the first two
+	// and twice as long every time after.
+	remoteAttempts = 3
+	remoteBackoff  = 500 * time.Millisecond
+)
+
+// DefaultRemoteTimeout bounds every download of a remote file, see
+// WithRemoteTimeout.
+const DefaultRemoteTimeout = 30 * time.Second
+
+// remoteConfig is how a server fetches remote files, see WithRemoteHeaders,
+// WithNetrc, WithRemoteCache, WithRemoteAcceptAny and WithRemoteTimeout.
+// nil fetches them with the defaults.
+type remoteConfig struct {
+	// log is the logger of the server, see logInfo.
+	log       *log.Logger
+	header    http.Header
+	netrc     []netrcEntry
+	cacheDir  string
+	acceptAny bool
+	timeout   time.Duration
+}
+
+// logger is the logger of cfg, nil when cfg is.
+func (cfg *remoteConfig) logger() *log.Logger {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.log
+}
+
+// transientError is a download failure worth trying again.
+type transientError struct{ error }
+
+func (e transientError) Unwrap() error { return e.error }
+
+// remoteClient downloads remote files, each request is bounded by the
+// configured timeout.
+func remoteClient(cfg *remoteConfig) *http.Client {
+	timeout := DefaultRemoteTimeout
+	if cfg != nil && cfg.timeout > 0 {
+		timeout = cfg.timeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// remoteSource is a downloaded remote file, its validators make the next
+// download conditional, see WithRefresh.
+type remoteSource struct {
+	url  string
+	path string
+	cfg  *remoteConfig
+	// temp is set for temp files, files in the cache directory are kept.
+	temp         bool
+	etag         string
+	lastModified string
+	// archive is the kind of a downloaded archive, see archiveKind.
+	archive string
+}
+
+// remoteMeta is stored next to the files of the cache directory, so that
+// the next run revalidates them.
+type remoteMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Archive      string `json:"archive,omitempty"`
+}
+
+// downloadJSON downloads the json at _url into the cache directory when
+// there is one, see WithRemoteCache, or into a temp file the caller owns.
+// a cached copy is revalidated, and served when the download fails.
+func downloadJSON(cfg *remoteConfig, _url string) (*remoteSource, error) {
+	src := &remoteSource{url: _url, cfg: cfg, temp: true}
+	if cfg != nil && cfg.cacheDir != "" {
+		path, err := cachePath(cfg.cacheDir, _url)
+		if err != nil {
+			return nil, err
+		}
+		src.path, src.temp = path, false
+		src.readMeta()
+	}
+
+	if err := src.fetchRetrying(); err != nil {
+		if _, statErr := os.Stat(src.path); !src.temp && statErr == nil {
+			logInfo(cfg.logger(), fmt.Sprintf("cannot download %q, serving the cached copy: %s", _url, err))
+			return src, nil
+		}
+		return nil, err
+	}
+	return src, nil
+}
+
+// isJSONType reports whether ctype is a json media type, parameters
+// included: application/json; charset=utf-8, application/problem+json.
+func isJSONType(ctype string) bool {
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// fetchRetrying fetches src, trying again with exponential backoff when
+// the failure is transient.
+func (src *remoteSource) fetchRetrying() error {
+	backoff := remoteBackoff
+	for attempt := 1; ; attempt++ {
+		_, err := src.fetch()
+		var transient transientError
+		if err == nil || !errors.As(err, &transient) || attempt == remoteAttempts {
+			return err
+		}
+		logInfo(src.cfg.logger(), fmt.Sprintf("%s, trying again in %s", err, backoff))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// downloadAll downloads the distinct urls, remoteParallel at a time. when
+// one fails the downloaded ones are removed and every failure is reported.
+func downloadAll(cfg *remoteConfig, urls []string) (map[string]*remoteSource, error) {
+	urls = slices.Compact(slices.Sorted(slices.Values(urls)))
+	sources := make([]*remoteSource, len(urls))
+	errs := make([]error, len(urls))
+
+	sem := make(chan struct{}, remoteParallel)
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			sources[i], errs[i] = downloadJSON(cfg, u)
+		})
+	}
+	wg.Wait()
+
+	downloads := make(map[string]*remoteSource, len(urls))
+	for i, src := range sources {
+		if src != nil {
+			downloads[urls[i]] = src
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		for _, src := range downloads {
+			if src.temp {
+				os.Remove(src.path)
+			}
+		}
+		return nil, err
+	}
+	return downloads, nil
+}
+
+// cachePath is where _url is cached in dir, named after its host and a
+// hash of the url.
+func cachePath(dir, _url string) (string, error) {
+	u, err := url.Parse(_url)
+	if err != nil {
+		return "", fmt.Errorf("parse URL: %w", err)
+	}
+	sum := sha256.Sum256([]byte(_url))
+	host := strings.ReplaceAll(u.Host, ":", "_")
+	return filepath.Join(dir, fmt.Sprintf("%s-%x.%s", host, sum[:8], cmp.Or(archiveKind(u.Path, ""), "json"))), nil
+}
+
+// fetch downloads src again, conditionally when it has validators, and
+// reports whether its file changed. the new copy replaces the old one
+// once it is complete.
+func (src *remoteSource) fetch() (changed bool, err error) {
+	logInfo(src.cfg.logger(), fmt.Sprintf("downloading: %q", src.url))
+	u, err := url.Parse(src.url)
+	if err != nil {
+		return false, fmt.Errorf("parse URL: %w", err)
+	}
+
+	req, err := remoteRequest(src.cfg, src.url)
+	if err != nil {
+		return false, fmt.Errorf("download: %w", err)
+	}
+	if src.etag != "" {
+		req.Header.Set("If-None-Match", src.etag)
+	}
+	if src.lastModified != "" {
+		req.Header.Set("If-Modified-Since", src.lastModified)
+	}
+	resp, err := remoteClient(src.cfg).Do(req)
+	if err != nil {
+		return false, transientError{fmt.Errorf("download: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		logInfo(src.cfg.logger(), fmt.Sprintf("not modified: %q", src.url))
+		return false, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logInfo(src.cfg.logger(), fmt.Sprintf("failed to download file from: %q", src.url))
+		err := fmt.Errorf("download of %q failed: %s", src.url, resp.Status)
+		if region := resp.Header.Get("X-Amz-Bucket-Region"); region != "" && resp.StatusCode != http.StatusNotFound {
+			err = fmt.Errorf("%w, the bucket is in %s (AWS_REGION)", err, region)
+		}
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return false, transientError{err}
+		}
+		return false, err
+	}
+
+	ctype := resp.Header.Get("Content-Type")
+	archive := archiveKind(u.Path, ctype)
+	// objects keep the content type they were uploaded with, often none
+	if cfg := src.cfg; (cfg == nil || !cfg.acceptAny) && archive == "" && !isBucket(src.url) && !isJSONType(ctype) {
+		return false, fmt.Errorf("unexpected content type for %q: %s", src.url, ctype)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, transientError{fmt.Errorf("download: %w", err)}
+	}
+	if archive == "" && !json.Valid(body) {
+		return false, fmt.Errorf("%q did not answer json (%s)", src.url, cmp.Or(ctype, "no content type"))
+	}
+
+	dir := ""
+	if src.path != "" {
+		dir = filepath.Dir(src.path)
+	}
+	tempFile, err := os.CreateTemp(dir, fmt.Sprintf("mok-%s.*.%s", strings.ReplaceAll(u.Host, ":", "_"), cmp.Or(archive, "json")))
+	if err != nil {
+		return false, fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tempFile.Name())
+		}
+	}()
+	logInfo(src.cfg.logger(), fmt.Sprintf("creating temp file: %q", tempFile.Name()))
+
+	if _, err := tempFile.Write(body); err != nil {
+		tempFile.Close()
+		return false, fmt.Errorf("save: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return false, fmt.Errorf("save: %w", err)
+	}
+	if src.path == "" {
+		src.path = tempFile.Name()
+	} else if err := os.Rename(tempFile.Name(), src.path); err != nil {
+		return false, fmt.Errorf("save: %w", err)
+	}
+
+	src.archive = archive
+	src.etag = resp.Header.Get("Etag")
+	src.lastModified = resp.Header.Get("Last-Modified")
+	if !src.temp {
+		src.writeMeta()
+	}
+	logInfo(src.cfg.logger(), fmt.Sprintf("succesfully downloaded file %q to %q", src.url, src.path))
+	return true, nil
+}
+
+// readMeta loads the validators of the cached copy, if there is one.
+func (src *remoteSource) readMeta() {
+	if _, err := os.Stat(src.path); err != nil {
+		return
+	}
+	data, err := os.ReadFile(src.path + ".meta")
+	if err != nil {
+		return
+	}
+	var meta remoteMeta
+	if json.Unmarshal(data, &meta) == nil && meta.URL == src.url {
+		src.etag, src.lastModified, src.archive = meta.ETag, meta.LastModified, meta.Archive
+	}
+}
+
+func (src *remoteSource) writeMeta() {
+	data, _ := json.Marshal(remoteMeta{URL: src.url, ETag: src.etag, LastModified: src.lastModified, Archive: src.archive})
+	if err := os.WriteFile(src.path+".meta", data, 0o644); err != nil {
+		logInfo(src.cfg.logger(), fmt.Sprintf("cannot write cache metadata of %q: %s", src.url, err))
+	}
+}
+
+// refreshRemote downloads the remote files again every interval and
+// reloads the ones that changed, until the server is shut down. the routes
+// are rebuilt when the ref of a git source moved.
+func (s *Server) refreshRemote(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		if s.gitMoved() {
+			if err := s.Reload(); err != nil {
+				logInfo(s.log, fmt.Sprintf("cannot reload the git sources: %s", err))
+			}
+		}
+		for _, f := range allFiles(s.Routes()) {
+			if f.remote == nil {
+				continue
+			}
+			changed, err := f.remote.fetch()
+			if err != nil {
+				logInfo(s.log, fmt.Sprintf("cannot refresh %q: %s", f.remote.url, err))
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := f.load(); err != nil {
+				logInfo(s.log, fmt.Sprintf("cannot reload %q: %s", f.FilePath, err))
+				continue
+			}
+			fmt.Fprintf(s.opts.out, "  refreshed %s (%s)\n", f.URLPath, f.remote.url)
+		}
+	}
+}
+
+// gitMoved reports whether the ref of a served git source moved.
+func (s *Server) gitMoved() bool {
+	polled := make(map[*gitCheckout]bool)
+	for _, f := range allFiles(s.Routes()) {
+		if f.checkout == nil || polled[f.checkout] {
+			continue
+		}
+		polled[f.checkout] = true
+		moved, err := f.checkout.moved()
+		if err != nil {
+			logInfo(s.log, fmt.Sprintf("cannot poll %q: %s", f.checkout.repo, err))
+			continue
+		}
+		if moved {
+			fmt.Fprintf(s.opts.out, "  %s moved\n", f.checkout.repo)
+			return true
+		}
+	}
+	return false
+}
+
+// netrcEntry is a machine of a .netrc file, machine is "" for default.
+type netrcEntry struct {
+	machine, login, password string
+}
+
+// remoteRequest is the GET of a remote file with the configured headers,
+// and the .netrc credentials of the host when no Authorization is set.
+// bucket objects are signed with the ambient credentials instead.
+func remoteRequest(cfg *remoteConfig, _url string) (*http.Request, error) {
+	if isBucket(_url) {
+		u, err := url.Parse(_url)
+		if err != nil {
+			return nil, err
+		}
+		return bucketRequest(u)
+	}
+	req, err := http.NewRequest(http.MethodGet, _url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return req, nil
+	}
+	for name, values := range cfg.header {
+		req.Header[name] = values
+	}
+	if req.Header.Get("Authorization") == "" {
+		if e, ok := netrcLookup(cfg.netrc, req.URL.Hostname()); ok {
+			req.SetBasicAuth(e.login, e.password)
+		}
+	}
+	return req, nil
+}
+
+// netrcPath is $NETRC or the .netrc in the home directory, _netrc on
+// windows like curl.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// loadNetrc reads the .netrc file at path.
+func loadNetrc(path string) ([]netrcEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading netrc: %w", err)
+	}
+	entries, err := parseNetrc(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// parseNetrc reads the machine, default, login and password tokens of a
+// .netrc file, comments and macros are skipped.
+func parseNetrc(data []byte) ([]netrcEntry, error) {
+	var tokens []string
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		for _, field := range strings.Fields(lines[i]) {
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+			if field == "macdef" {
+				// the macro runs until an empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				break
+			}
+			tokens = append(tokens, field)
+		}
+	}
+
+	var entries []netrcEntry
+	for i := 0; i < len(tokens); i++ {
+		switch token := tokens[i]; token {
+		case "default":
+			entries = append(entries, netrcEntry{})
+		case "machine", "login", "password", "account":
+			if i++; i == len(tokens) {
+				return nil, fmt.Errorf("netrc: %s without a value", token)
+			}
+			if token == "machine" {
+				entries = append(entries, netrcEntry{machine: tokens[i]})
+				continue
+			}
+			if len(entries) == 0 {
+				continue
+			}
+			switch e := &entries[len(entries)-1]; token {
+			case "login":
+				e.login = tokens[i]
+			case "password":
+				e.password = tokens[i]
+			}
+		}
+	}
+	return entries, nil
+}
+
+// netrcLookup finds the entry of host, the default one otherwise.
+func netrcLookup(entries []netrcEntry, host string) (netrcEntry, bool) {
+	var def *netrcEntry
+	for i, e := range entries {
+		if e.machine == host {
+			return e, true
+		}
+		if e.machine == "" && def == nil {
+			def = &entries[i]
+		}
+	}
+	if def != nil {
+		return *def, true
+	}
+	return netrcEntry{}, false
+}
@@ -0,0 +1,232 @@
+package mok
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// capturedRequests is how many requests are kept for inspection.
+const capturedRequests = 1000
+
+// maxCapturedBody caps how much of every request body is kept, the
+// handlers still see all of it.
+const maxCapturedBody = 64 << 10
+
+// Request is a served request, as captured for inspection.
+type Request struct {
+	Time     time.Time   `json:"time"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Query    string      `json:"query,omitempty"`
+	Header   http.Header `json:"header"`
+	Body     string      `json:"body,omitempty"`
+	Route    string      `json:"route,omitempty"`
+	Status   int         `json:"status"`
+	Duration string      `json:"duration"`
+}
+
+// requestLog is a ring buffer of the latest requests.
+type requestLog struct {
+	mu      sync.Mutex
+	entries []Request
+	next    int
+}
+
+func newRequestLog(size int) *requestLog {
+	return &requestLog{entries: make([]Request, 0, size)}
+}
+
+func (l *requestLog) add(e Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, e)
+		return
+	}
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// list returns the entries, newest first.
+func (l *requestLog) list() []Request {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := append(slices.Clone(l.entries[l.next:]), l.entries[:l.next]...)
+	slices.Reverse(entries)
+	return entries
+}
+
+func (l *requestLog) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = l.entries[:0]
+	l.next = 0
+}
+
+// captureBody reads the start of the request body for the log and puts it
+// back in front of the rest.
+func captureBody(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	head, _ := io.ReadAll(io.LimitReader(r.Body, maxCapturedBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+	return string(head)
+}
+
+// serveRequests lists captured requests newest first, filtered as described
+// at requestFilter and capped with ?limit=.
+func (s *Server) serveRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	match, err := requestFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := -1
+	if raw := query.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", raw), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries := []Request{}
+	for _, e := range s.requests.list() {
+		if limit >= 0 && len(entries) == limit {
+			break
+		}
+		if match(e) {
+			entries = append(entries, e)
+		}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// verifyRequests checks the captured requests against an expectation, the
+// filters of requestFilter select the requests and ?count= (exactly), ?min=
+// and ?max= bound how many there must be, at least one by default. it
+// answers 200 when the expectation is met and 417 otherwise, so that
+// `curl -f` fails.
+func (s *Server) verifyRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	match, err := requestFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bounds := map[string]int{"count": -1, "min": -1, "max": -1}
+	for name := range bounds {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid %s %q", name, raw), http.StatusBadRequest)
+			return
+		}
+		bounds[name] = n
+	}
+	if bounds["count"] >= 0 {
+		bounds["min"], bounds["max"] = bounds["count"], bounds["count"]
+	} else if bounds["min"] < 0 && bounds["max"] < 0 {
+		bounds["min"] = 1
+	}
+
+	matched := []Request{}
+	for _, e := range s.requests.list() {
+		if match(e) {
+			matched = append(matched, e)
+		}
+	}
+
+	n := len(matched)
+	ok := (bounds["min"] < 0 || n >= bounds["min"]) && (bounds["max"] < 0 || n <= bounds["max"])
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusExpectationFailed
+	}
+
+	result := map[string]any{"ok": ok, "count": n, "requests": matched}
+	for _, name := range []string{"min", "max"} {
+		if bounds[name] >= 0 {
+			result[name] = bounds[name]
+		}
+	}
+	writeJSON(w, status, result)
+}
+
+// requestFilter selects captured requests by ?path= (a trailing * matches a
+// prefix), ?method=, ?body= (a substring of the body), ?since= and ?until=
+// (RFC 3339 times or durations ago, e.g. 5m).
+func requestFilter(query url.Values) (func(Request) bool, error) {
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseSince(query.Get("until"))
+	if err != nil {
+		return nil, err
+	}
+	p, method, body := query.Get("path"), query.Get("method"), query.Get("body")
+
+	return func(e Request) bool {
+		if p != "" {
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				if !strings.HasPrefix(e.Path, prefix) {
+					return false
+				}
+			} else if e.Path != p {
+				return false
+			}
+		}
+		if method != "" && !strings.EqualFold(e.Method, method) {
+			return false
+		}
+		if body != "" && !strings.Contains(e.Body, body) {
+			return false
+		}
+		if !since.IsZero() && e.Time.Before(since) {
+			return false
+		}
+		if !until.IsZero() && e.Time.After(until) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+func (s *Server) clearRequests(w http.ResponseWriter, r *http.Request) {
+	s.requests.clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseSince reads an RFC 3339 time or a duration before now.
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want RFC 3339 or a duration", s)
+	}
+	return time.Now().Add(-d), nil
+}
@@ -0,0 +1,95 @@
+package mok
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// RouteInfo describes a served route for humans, see RouteTable. Method is
+// "" for routes answering every method, Status is 0 for scenarios and rules
+// whose responses have their own, Matchers are the conditions these
+// responses are picked with.
+type RouteInfo struct {
+	Method   string   `json:"method,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	Path     string   `json:"path"`
+	Source   string   `json:"source"`
+	Status   int      `json:"status,omitempty"`
+	Matchers []string `json:"matchers,omitempty"`
+}
+
+// RouteTable describes the served routes in the order they are listed at
+// startup.
+func (s *Server) RouteTable() []RouteInfo {
+	return routeTable(s.Routes())
+}
+
+func routeTable(files []*MokFile) []RouteInfo {
+	var table []RouteInfo
+	for _, f := range files {
+		route := RouteInfo{
+			Method: f.Method,
+			Host:   f.Host,
+			Path:   f.URLPath,
+			Source: f.FilePath,
+			Status: cmp.Or(f.Status, http.StatusOK),
+		}
+		if f.alias != "" {
+			route.Matchers = append(route.Matchers, "also "+f.alias)
+		}
+		switch {
+		case f.sequence != nil:
+			route.Status = 0
+			for i, step := range f.sequence.steps {
+				route.Matchers = append(route.Matchers, fmt.Sprintf("request %d -> %s", i+1, describeResponse(step)))
+			}
+			if f.sequence.loop {
+				route.Matchers = append(route.Matchers, "then from request 1 again")
+			}
+		case f.rules != nil:
+			route.Status = 0
+			for _, r := range f.rules.rules {
+				route.Matchers = append(route.Matchers, r.match.String()+" -> "+describeResponse(r.file))
+			}
+			if f.rules.fallback != nil {
+				route.Matchers = append(route.Matchers, "otherwise -> "+describeResponse(f.rules.fallback))
+			}
+		}
+		if f.auth != nil {
+			route.Matchers = append(route.Matchers, "auth "+f.auth.header)
+		}
+		table = append(table, route)
+	}
+	return table
+}
+
+// describeResponse is the file of a response and its status, 200 by
+// default.
+func describeResponse(f *MokFile) string {
+	status := cmp.Or(f.Status, http.StatusOK)
+	if f.FilePath == "" {
+		return fmt.Sprint(status)
+	}
+	return fmt.Sprintf("%s (%d)", f.FilePath, status)
+}
+
+// String is the conditions of m, e.g. header Authorization=Bearer x.
+func (m Matcher) String() string {
+	var conds []string
+	for _, c := range []struct {
+		kind  string
+		conds map[string]string
+	}{{"header", m.Header}, {"query", m.Query}, {"body", m.Body}} {
+		for _, name := range slices.Sorted(maps.Keys(c.conds)) {
+			conds = append(conds, c.kind+" "+name+"="+c.conds[name])
+		}
+	}
+	if len(conds) == 0 {
+		return "always"
+	}
+	return strings.Join(conds, ", ")
+}
@@ -0,0 +1,185 @@
+package mok
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxMatchBody caps how much of the request body matchers read.
+const maxMatchBody = 10 << 20
+
+// Matcher selects a rule by looking at the request, all its conditions must
+// hold. body keys are paths into the json request body, both JSONPath
+// ($.user.plan, $.items[0].id) and gjson (user.plan, items.0.id) styles
+// work, values are compared with the json value as a string. header and
+// query values must match exactly, an empty value matches a missing one:
+//
+//	match:
+//	  body:
+//	    $.type: premium
+//	  header:
+//	    Authorization: Bearer bad-token
+//	  query:
+//	    debug: "1"
+type Matcher struct {
+	Body   map[string]string `yaml:"body,omitempty"`
+	Header map[string]string `yaml:"header,omitempty"`
+	Query  map[string]string `yaml:"query,omitempty"`
+}
+
+type RuleConfig struct {
+	Match          Matcher `yaml:"match,omitempty"`
+	ResponseConfig `yaml:",inline"`
+}
+
+// matchedQueryKey holds the query parameters that picked the rule serving
+// the request, see arrayQuery.
+type matchedQueryKey struct{}
+
+type rule struct {
+	match Matcher
+	file  *MokFile
+}
+
+// ruleSet is the state of a route with rules, the first rule matching the
+// request serves it, the fallback, if any, serves everything else.
+type ruleSet struct {
+	rules    []rule
+	fallback *MokFile
+}
+
+func (rs *ruleSet) files() []*MokFile {
+	var files []*MokFile
+	for _, r := range rs.rules {
+		files = append(files, r.file)
+	}
+	if rs.fallback != nil {
+		files = append(files, rs.fallback)
+	}
+	return files
+}
+
+func (rs *ruleSet) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMatchBody))
+	if err != nil {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the chosen response (e.g. a template) may want to read the body too
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var doc any
+	if len(body) > 0 {
+		json.Unmarshal(body, &doc)
+	}
+
+	for _, rule := range rs.rules {
+		if rule.match.matches(r, doc) {
+			if len(rule.match.Query) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), matchedQueryKey{}, rule.match.Query))
+			}
+			rule.file.serve(w, r)
+			return
+		}
+	}
+
+	if rs.fallback == nil {
+		http.Error(w, "no rule matched the request", http.StatusNotFound)
+		return
+	}
+	rs.fallback.serve(w, r)
+}
+
+func (m Matcher) matches(r *http.Request, body any) bool {
+	for name, want := range m.Header {
+		if r.Header.Get(name) != want {
+			return false
+		}
+	}
+	query := r.URL.Query()
+	for name, want := range m.Query {
+		if query.Get(name) != want {
+			return false
+		}
+	}
+	for expr, want := range m.Body {
+		got, ok := evalPath(body, expr)
+		if !ok || jsonString(got) != want {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Matcher) validate() error {
+	for expr := range m.Body {
+		if _, err := parsePath(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonString formats v for comparisons, strings as they are and everything
+// else as json: 42, true, null, {"a":1}.
+func jsonString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+var indexRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// parsePath splits a JSONPath ($.a.b[0]) or gjson (a.b.0) expression into
+// its keys.
+func parsePath(expr string) ([]string, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	p = indexRe.ReplaceAllString(p, ".$1")
+	if p == "" {
+		return nil, nil
+	}
+
+	keys := strings.Split(p, ".")
+	for _, k := range keys {
+		if k == "" || strings.ContainsAny(k, "[]*") {
+			return nil, fmt.Errorf("invalid body path %q, only dotted keys and [n] indexes are supported", expr)
+		}
+	}
+	return keys, nil
+}
+
+// evalPath walks doc following expr, numeric keys index arrays.
+func evalPath(doc any, expr string) (any, bool) {
+	keys, err := parsePath(expr)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, key := range keys {
+		switch v := doc.(type) {
+		case map[string]any:
+			var ok bool
+			if doc, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
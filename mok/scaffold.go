@@ -0,0 +1,50 @@
+package mok
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// scaffold is the starter layout of mok init: a config with a template, a
+// scenario, rules and a profile, and the fixtures they serve.
+//
+//go:embed scaffold
+var scaffold embed.FS
+
+// Scaffold writes the starter layout into dir, created if needed, and
+// returns the files it wrote. files that exist already are left alone and
+// are not returned.
+func Scaffold(dir string) ([]string, error) {
+	root, err := fs.Sub(scaffold, "scaffold")
+	if err != nil {
+		return nil, err
+	}
+	var written []string
+	err = fs.WalkDir(root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		content, err := fs.ReadFile(root, name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0o644); err != nil {
+			return err
+		}
+		written = append(written, target)
+		return nil
+	})
+	return written, err
+}
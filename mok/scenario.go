@@ -0,0 +1,59 @@
+package mok
+
+import (
+	"net/http"
+	"sync"
+)
+
+// sequence is the state of a scenario route, see Config.
+type sequence struct {
+	steps []*MokFile
+	loop  bool
+
+	mu   sync.Mutex
+	next int
+}
+
+// step returns the response for the current request and moves on.
+func (s *sequence) step() *MokFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	step := s.steps[s.next]
+	switch {
+	case s.next < len(s.steps)-1:
+		s.next++
+	case s.loop:
+		s.next = 0
+	}
+	return step
+}
+
+func (s *sequence) reset() {
+	s.mu.Lock()
+	s.next = 0
+	s.mu.Unlock()
+}
+
+// resetScenarios rewinds every scenario, or only the ones mounted at
+// ?path= when passed.
+func resetScenarios(files []*MokFile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+
+		var reset []string
+		for _, f := range files {
+			if f.sequence == nil || (path != "" && f.URLPath != path) {
+				continue
+			}
+			f.sequence.reset()
+			reset = append(reset, f.pattern())
+		}
+
+		if path != "" && len(reset) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]any{"reset": []string{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"reset": reset})
+	}
+}
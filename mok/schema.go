@@ -0,0 +1,319 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// bodySchema validates the json bodies POSTed, PUT and PATCHed to a route
+// against a JSON Schema, see RouteConfig.
+type bodySchema struct {
+	// doc is the schema file, its $refs point into it (#/$defs/User)
+	doc *openAPIDoc
+}
+
+func loadBodySchema(fsys fs.FS, path string) (*bodySchema, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema: %w", err)
+	}
+	// yaml is a superset of json, one parser for both
+	var root any
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parsing schema %q: %w", path, err)
+	}
+	return &bodySchema{doc: &openAPIDoc{path: path, root: asMap(normalizeYAML(root))}}, nil
+}
+
+// allow reports whether the body of r matches the schema, r is answered
+// with a 400 listing the violations otherwise.
+func (b *bodySchema) allow(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMatchBody))
+	if err != nil {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	// rules and templates read it again
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "request body is not json: " + err.Error()})
+		return false
+	}
+	if errs := b.doc.validate(b.doc.root, v); len(errs) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error":   "request body does not match the schema",
+			"details": errs,
+		})
+		return false
+	}
+	return true
+}
+
+// schemaError is where a value breaks its schema, Path is a JSONPath such
+// as $.items[0].id.
+type schemaError struct {
+	Path string `json:"path"`
+	Err  string `json:"error"`
+}
+
+func (e schemaError) String() string { return e.Path + ": " + e.Err }
+
+// validate checks v against schema, the keywords JSON Schema and OpenAPI
+// schema objects share: $ref, type (and nullable), enum, const, properties,
+// required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum, maximum and their exclusive forms, allOf,
+// anyOf, oneOf and not. formats are annotations, they are not checked.
+func (doc *openAPIDoc) validate(schema map[string]any, v any) []schemaError {
+	var errs []schemaError
+	doc.check(schema, v, "$", 0, &errs)
+	return errs
+}
+
+func (doc *openAPIDoc) check(schema map[string]any, v any, at string, depth int, errs *[]schemaError) {
+	schema = doc.resolve(schema)
+	if depth > 64 {
+		return
+	}
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, schemaError{Path: at, Err: fmt.Sprintf(format, args...)})
+	}
+
+	if v == nil && schema["nullable"] == true {
+		return
+	}
+	if types := schemaTypes(schema); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(v, t) }) {
+		fail("expected %s, got %s", strings.Join(types, " or "), jsonType(v))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return jsonEqual(e, v) }) {
+		values, _ := json.Marshal(enum)
+		fail("expected one of %s", values)
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		value, _ := json.Marshal(c)
+		fail("expected %s", value)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := v[name]; !ok {
+					fail("missing required field %q", name)
+				}
+			}
+		}
+		props := asMap(schema["properties"])
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			if prop, ok := props[name]; ok {
+				doc.check(asMap(prop), v[name], fieldPath(at, name), depth+1, errs)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					*errs = append(*errs, schemaError{Path: fieldPath(at, name), Err: "unexpected field"})
+				}
+			case map[string]any:
+				doc.check(extra, v[name], fieldPath(at, name), depth+1, errs)
+			}
+		}
+	case []any:
+		if n, ok := toFloat(schema["minItems"]); ok && float64(len(v)) < n {
+			fail("expected at least %v items, got %d", n, len(v))
+		}
+		if n, ok := toFloat(schema["maxItems"]); ok && float64(len(v)) > n {
+			fail("expected at most %v items, got %d", n, len(v))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				doc.check(items, item, fmt.Sprintf("%s[%d]", at, i), depth+1, errs)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if n, ok := toFloat(schema["minLength"]); ok && float64(length) < n {
+			fail("expected at least %v characters, got %d", n, length)
+		}
+		if n, ok := toFloat(schema["maxLength"]); ok && float64(length) > n {
+			fail("expected at most %v characters, got %d", n, length)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("expected a match of %q", pattern)
+			}
+		}
+	default:
+		if n, ok := toFloat(v); ok {
+			doc.checkRange(schema, n, fail)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, s := range all {
+			doc.check(asMap(s), v, at, depth+1, errs)
+		}
+	}
+	matching := func(alts []any) int {
+		n := 0
+		for _, s := range alts {
+			var sub []schemaError
+			if doc.check(asMap(s), v, at, depth+1, &sub); len(sub) == 0 {
+				n++
+			}
+		}
+		return n
+	}
+	if alts, ok := schema["anyOf"].([]any); ok && matching(alts) == 0 {
+		fail("matches none of anyOf")
+	}
+	if alts, ok := schema["oneOf"].([]any); ok {
+		if n := matching(alts); n != 1 {
+			fail("matches %d of oneOf, expected 1", n)
+		}
+	}
+	if not, ok := schema["not"].(map[string]any); ok && matching([]any{not}) == 1 {
+		fail("matches not")
+	}
+}
+
+// checkRange checks minimum and maximum, exclusiveMinimum is a number in
+// JSON Schema and a flag next to minimum in OpenAPI 3.0.
+func (doc *openAPIDoc) checkRange(schema map[string]any, n float64, fail func(string, ...any)) {
+	if min, ok := toFloat(schema["minimum"]); ok {
+		if schema["exclusiveMinimum"] == true && n <= min {
+			fail("expected more than %v, got %v", min, n)
+		} else if n < min {
+			fail("expected at least %v, got %v", min, n)
+		}
+	}
+	if min, ok := toFloat(schema["exclusiveMinimum"]); ok && n <= min {
+		fail("expected more than %v, got %v", min, n)
+	}
+	if max, ok := toFloat(schema["maximum"]); ok {
+		if schema["exclusiveMaximum"] == true && n >= max {
+			fail("expected less than %v, got %v", max, n)
+		} else if n > max {
+			fail("expected at most %v, got %v", max, n)
+		}
+	}
+	if max, ok := toFloat(schema["exclusiveMaximum"]); ok && n >= max {
+		fail("expected less than %v, got %v", max, n)
+	}
+}
+
+// schemaTypes is the type of a schema, a list since 3.1 and JSON Schema
+// allow several, nil when anything goes.
+func schemaTypes(schema map[string]any) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, t := range t {
+			if t, ok := t.(string); ok {
+				types = append(types, t)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func hasType(v any, typ string) bool {
+	switch typ {
+	case "null":
+		return v == nil
+	case "integer":
+		n, ok := toFloat(v)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := toFloat(v)
+		return ok
+	}
+	return jsonType(v) == typ
+}
+
+// jsonType is the json name of the type of a decoded value.
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	if _, ok := toFloat(v); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toFloat is the value of a number decoded from json (json.Number) or
+// yaml (int, float64).
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// jsonEqual compares decoded values, numbers by value whatever decoded
+// them.
+func jsonEqual(a, b any) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// fieldPath is the JSONPath of a field of the object at, $.user.name or
+// $.headers["Content-Type"].
+func fieldPath(at, name string) string {
+	if identifierRe.MatchString(name) {
+		return at + "." + name
+	}
+	quoted, _ := json.Marshal(name)
+	return at + "[" + string(quoted) + "]"
+}
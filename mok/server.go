@@ -0,0 +1,1500 @@
+// Package mok serves json fixtures over http, it is what the mok command
+// runs and can be embedded in Go tests:
+//
+//	srv, err := mok.New(mok.WithFiles("testdata/users.json"))
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	ts := httptest.NewServer(srv.Handler())
+//	defer ts.Close()
+//
+//	resp, err := http.Get(ts.URL + "/users.json")
+package mok
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownTimeout is how long in-flight requests get to finish once mok is
+// asked to stop, through the admin API or a signal to the mok command.
+const ShutdownTimeout = 5 * time.Second
+
+// Server serves the routes of a config, files and directories. routes can
+// change at runtime (see AddRoute and the admin API), ServeMux can't
+// unregister patterns so every change builds a new mux and swaps it in.
+type Server struct {
+	opts      options
+	store     *crudStore
+	oidc      *oidcIssuer
+	grpc      *grpcMock
+	graphql   *graphQL
+	webhooks  *webhookReceiver
+	unmatched http.Handler
+	static    *staticFiles
+	index     *template.Template // see WithIndexTemplate
+	handler   http.Handler
+	requests  *requestLog
+	tracer    *tracer
+	har       *harLog // see WithRecordHAR
+	stats     routeStats
+	// unexpected are the requests no route answered, see WithStrict
+	unexpected unexpectedLog
+	// served counts the answered requests, see WithMaxRequests
+	served atomic.Int64
+
+	// input is the direct input being served, see SetDirectInput.
+	input atomic.Pointer[[]byte]
+	// profile is the selected profile of the config, see SetProfile.
+	profile string
+	// remote is how the remote files are downloaded.
+	remote *remoteConfig
+	// log is where a verbose server logs what it does, nil when it is not.
+	log *log.Logger
+
+	hs   *http.Server
+	ghs  *http.Server // serves grpc, see ServeGRPC
+	addr net.Addr
+	stop sync.Once
+	done chan struct{}
+
+	// changes serializes updates, building a mux can take a while (remote
+	// files are downloaded again on reload) and requests keep being served.
+	changes sync.Mutex
+
+	mu    sync.RWMutex
+	mux   *http.ServeMux
+	files []*MokFile // from the config and the files options
+	added []*MokFile // through AddFile, AddRoute and the admin API
+}
+
+type options struct {
+	config      string
+	files       []string
+	directInput []byte
+	inline      []mount
+	inputPath   string
+	follow      io.Reader
+	profile     string
+
+	verbose bool
+
+	remoteHeader http.Header
+	netrc        bool
+	netrcPath    string
+	remoteCache  string
+	acceptAny    bool
+	timeout      time.Duration
+	refresh      time.Duration
+
+	delay       Delay
+	rateLimit   RateLimit
+	throttle    Bandwidth
+	network     Network
+	compress    Compression
+	jsonStyle   JSONStyle
+	cache       string
+	contentType string
+	templates   bool
+	expires     time.Duration
+	header      http.Header
+	cors        bool
+	corsOrigins []string
+	authUser    string
+	authPass    string
+	crud        string
+	oidc        bool
+	oidcClaims  map[string]any
+	clients     []OAuthClient
+	utils       bool
+	webhooks    string
+	hookSecret  string
+	fallback    string
+	wiremock    string
+	static      string
+	websockets  []mount
+	jsonrpc     []mount
+	vhosts      []mount
+	prefix      string
+	pretty      bool
+	namespace   bool
+	keepExt     bool
+	grpc        string
+	grpcDir     string
+	graphql     string
+	graphqlData string
+	watch       time.Duration
+	fsys        fs.FS
+	tls         *tls.Config
+	h2c         bool
+	clientCAs   *x509.CertPool
+	clientCerts ClientCerts
+	out         io.Writer
+
+	accessLog io.Writer
+	logFormat LogFormat
+	traces    string
+	harPath   string
+	strict    bool
+
+	indexTemplate string
+	noIndex       bool
+
+	contract       string
+	contractStrict bool
+
+	maxRequests int
+	lifetime    time.Duration
+}
+
+// mount is what WithWebSocket, WithJSONRPC and WithVirtualHost serve at a
+// path or a host, a script, a directory or files.
+type mount struct {
+	at, source string
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+// WithConfig loads the routes of the yaml config at path, local files are
+// relative to its directory.
+func WithConfig(path string) Option {
+	return func(o *options) { o.config = path }
+}
+
+// WithFiles serves files, directories, OpenAPI documents and remote urls,
+// like the arguments of the mok command.
+func WithFiles(files ...string) Option {
+	return func(o *options) { o.files = append(o.files, files...) }
+}
+
+// WithDirectInput serves input on every path no route answers, routes
+// always win, see WithDirectInputPath to serve it on a single path.
+func WithDirectInput(input []byte) Option {
+	return func(o *options) { o.directInput = input }
+}
+
+// WithDirectInputPath serves the direct input at path only, next to the
+// routes like any other. a route at the same path is an error.
+func WithDirectInputPath(path string) Option {
+	return func(o *options) { o.inputPath = path }
+}
+
+// WithFollow keeps reading json documents from r, e.g. stdin, every one
+// replaces the direct input, until r ends. the direct input is served
+// until the first one arrives, it is required.
+func WithFollow(r io.Reader) Option {
+	return func(o *options) { o.follow = r }
+}
+
+// WithProfile selects a profile of the config, see Profile.
+func WithProfile(name string) Option {
+	return func(o *options) { o.profile = name }
+}
+
+// WithInlineJSON serves content, a json document, at path. / only answers
+// the root.
+func WithInlineJSON(path string, content []byte) Option {
+	return func(o *options) { o.inline = append(o.inline, mount{path, string(content)}) }
+}
+
+// WithDelay delays the routes without a delay of their own.
+func WithDelay(d Delay) Option {
+	return func(o *options) { o.delay = d }
+}
+
+// WithRateLimit limits the routes without a rate limit of their own, every
+// route has its own bucket.
+func WithRateLimit(l RateLimit) Option {
+	return func(o *options) { o.rateLimit = l }
+}
+
+// WithThrottle caps how fast response bodies are written to rate.
+func WithThrottle(rate Bandwidth) Option {
+	return func(o *options) { o.throttle = rate }
+}
+
+// WithNetwork emulates a network condition, its delay and bandwidth apply
+// unless WithDelay and WithThrottle say otherwise.
+func WithNetwork(n Network) Option {
+	return func(o *options) { o.network = n }
+}
+
+// WithCompression sets when responses are compressed for the routes that
+// don't say, CompressAuto by default.
+func WithCompression(mode Compression) Option {
+	return func(o *options) { o.compress = mode }
+}
+
+// WithJSONStyle lays every json response out in style, pretty or minified,
+// ?_pretty=1 and ?_pretty=0 still pick per request.
+func WithJSONStyle(style JSONStyle) Option {
+	return func(o *options) { o.jsonStyle = style }
+}
+
+// WithCacheControl sets Cache-Control on the responses of the routes that
+// don't set their own.
+func WithCacheControl(value string) Option {
+	return func(o *options) { o.cache = value }
+}
+
+// WithExpires sets Expires to now plus d on the responses of the routes
+// that don't set their own, a negative d makes them stale right away.
+func WithExpires(d time.Duration) Option {
+	return func(o *options) { o.expires = d }
+}
+
+// WithContentType sets the Content-Type of the routes that don't set their
+// own, instead of guessing it from the file extension and the content.
+func WithContentType(ctype string) Option {
+	return func(o *options) { o.contentType = ctype }
+}
+
+// WithTemplates renders every fixture as a template, not only the ones of
+// the routes with template, see renderTemplate.
+func WithTemplates() Option {
+	return func(o *options) { o.templates = true }
+}
+
+// WithHeaders adds header to every response.
+func WithHeaders(header http.Header) Option {
+	return func(o *options) { o.header = header }
+}
+
+// WithCORS allows cross origin requests and answers preflights. any origin
+// may read the responses without credentials, unless origins are given:
+// only those may then, cookies and Authorization included.
+func WithCORS(origins ...string) Option {
+	return func(o *options) { o.cors, o.corsOrigins = true, append(o.corsOrigins, origins...) }
+}
+
+// WithBasicAuth requires http basic auth with user and pass on every
+// request, the others get a 401. an empty user turns it off.
+func WithBasicAuth(user, pass string) Option {
+	return func(o *options) { o.authUser, o.authPass = user, pass }
+}
+
+// WithCRUD serves a read/write REST API from the top-level arrays of the
+// json file at path.
+func WithCRUD(path string) Option {
+	return func(o *options) { o.crud = path }
+}
+
+// WithOIDC serves a fake OpenID Connect provider minting JWTs with claims
+// on top of iss, sub, aud, iat, nbf and exp.
+func WithOIDC(claims map[string]any) Option {
+	return func(o *options) { o.oidc, o.oidcClaims = true, claims }
+}
+
+// WithUtils serves the utility endpoints of httpbin: /status/{code},
+// /delay/{seconds}, /headers, /ip and /anything, and /echo, /bytes/{n} and
+// /json/{n}.
+func WithUtils() Option {
+	return func(o *options) { o.utils = true }
+}
+
+// WithWebhooks records the webhooks POSTed to path, a subtree when it ends
+// in a slash, and lists them at /__mok__/webhooks. with a secret only the
+// ones with a valid GitHub or Stripe signature are accepted.
+func WithWebhooks(path, secret string) Option {
+	return func(o *options) { o.webhooks, o.hookSecret = path, secret }
+}
+
+// WithOAuthClients restricts the authorization code flow of WithOIDC to
+// clients, their secrets and redirect uris are checked. any client is
+// accepted without it.
+func WithOAuthClients(clients ...OAuthClient) Option {
+	return func(o *options) { o.clients = append(o.clients, clients...) }
+}
+
+// WithFallback proxies requests not matching any route to target.
+func WithFallback(target string) Option {
+	return func(o *options) { o.fallback = target }
+}
+
+// WithWiremock loads WireMock stubs from dir/mappings and bodies from
+// dir/__files, they answer what no route matches.
+func WithWiremock(dir string) Option {
+	return func(o *options) { o.wiremock = dir }
+}
+
+// WithStatic serves the files of dir as they are, whatever their type, when
+// no route matches. an index.html takes the root path from the dashboard.
+func WithStatic(dir string) Option {
+	return func(o *options) { o.static = dir }
+}
+
+// WithIndexTemplate renders the html/template at path at the root path
+// instead of the dashboard, {{range .Routes}} walks the routes, see
+// IndexRoute.
+func WithIndexTemplate(path string) Option {
+	return func(o *options) { o.indexTemplate = path }
+}
+
+// WithoutIndex serves neither the route listing at the root path nor the
+// dashboard, the admin API is still there.
+func WithoutIndex() Option {
+	return func(o *options) { o.noIndex = true }
+}
+
+// WithWebSocket upgrades the requests to path and plays the websocket
+// script at script, or echoes when it is "echo".
+func WithWebSocket(path, script string) Option {
+	return func(o *options) { o.websockets = append(o.websockets, mount{path, script}) }
+}
+
+// WithPrefix mounts every route under prefix, e.g. /api/v2, without
+// renaming the files. the admin API, the dashboard and the crud, oidc and
+// graphql endpoints stay where they are.
+func WithPrefix(prefix string) Option {
+	return func(o *options) { o.prefix = strings.TrimSuffix(prefix, "/") }
+}
+
+// WithNamespace mounts the files of the arguments that would be served at
+// the same route under the name of their directory, a/users.json at
+// /a/users.json and b/users.json at /b/users.json, instead of failing.
+func WithNamespace() Option {
+	return func(o *options) { o.namespace = true }
+}
+
+// WithPrettyURLs serves the fixtures of files and directories without
+// their extension, users.json at /users, and keeps answering /users.json
+// when keepExt is set. routes ending up at the same path are an error.
+func WithPrettyURLs(keepExt bool) Option {
+	return func(o *options) {
+		o.pretty = true
+		o.keepExt = keepExt
+	}
+}
+
+// WithVirtualHost serves files, like WithFiles, only to requests for host,
+// e.g. api.local. the same paths can be served for different hosts.
+func WithVirtualHost(host string, files ...string) Option {
+	return func(o *options) {
+		for _, f := range files {
+			o.vhosts = append(o.vhosts, mount{strings.ToLower(host), f})
+		}
+	}
+}
+
+// WithJSONRPC answers the JSON-RPC calls POSTed to path with the fixtures
+// in dir named after the method, e.g. dir/eth_blockNumber.json.
+func WithJSONRPC(path, dir string) Option {
+	return func(o *options) { o.jsonrpc = append(o.jsonrpc, mount{path, dir}) }
+}
+
+// WithGRPC answers the gRPC methods of schema, a .proto file or a binary
+// descriptor set, with the json fixtures in dir named after the method
+// path, e.g. dir/helloworld.Greeter/SayHello.json. see ServeGRPC.
+func WithGRPC(schema, dir string) Option {
+	return func(o *options) { o.grpc, o.grpcDir = schema, dir }
+}
+
+// WithGraphQL serves /graphql from the schema at path, answering with the
+// json fixture at data keyed by root field and making up the rest. data
+// can be empty.
+func WithGraphQL(schema, data string) Option {
+	return func(o *options) { o.graphql, o.graphqlData = schema, data }
+}
+
+// WithWatch polls the served files every interval and reloads the changed
+// ones, until the server is shut down.
+func WithWatch(interval time.Duration) Option {
+	return func(o *options) { o.watch = interval }
+}
+
+// WithFS reads local files, directories, OpenAPI documents and the config
+// from fsys instead of the operating system, e.g. fixtures embedded in a
+// test binary with go:embed. names are slash separated and relative to the
+// root of fsys, remote urls are still downloaded.
+func WithFS(fsys fs.FS) Option {
+	return func(o *options) { o.fsys = fsys }
+}
+
+// WithTLS makes Serve and Start serve https.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) { o.tls = cfg }
+}
+
+// WithClientCerts makes tls clients present a certificate signed by cas,
+// mode says what happens to the ones that don't. it requires WithTLS.
+func WithClientCerts(cas *x509.CertPool, mode ClientCerts) Option {
+	return func(o *options) { o.clientCAs, o.clientCerts = cas, cmp.Or(mode, ClientCertsRequire) }
+}
+
+// WithH2C also serves HTTP/2 without tls to clients that speak it from the
+// first byte (prior knowledge), http/1.1 keeps working.
+func WithH2C() Option {
+	return func(o *options) { o.h2c = true }
+}
+
+// WithOutput is where the route summary and reload notices are written,
+// nothing is written by default.
+func WithOutput(w io.Writer) Option {
+	return func(o *options) { o.out = w }
+}
+
+// WithAccessLog writes every request to w once it is answered, in format,
+// the combined log format by default.
+func WithAccessLog(w io.Writer, format LogFormat) Option {
+	return func(o *options) { o.accessLog, o.logFormat = w, format }
+}
+
+// WithTracing exports a span per request to the OTLP/HTTP traces endpoint,
+// e.g. http://localhost:4318/v1/traces, child of the span of the W3C
+// traceparent of the request. the spans are named after OTEL_SERVICE_NAME,
+// mok by default.
+func WithTracing(endpoint string) Option {
+	return func(o *options) { o.traces = endpoint }
+}
+
+// WithRecordHAR keeps every request and response of the session and writes
+// them to path as a HAR file on Shutdown, see WriteHAR.
+func WithRecordHAR(path string) Option {
+	return func(o *options) { o.harPath = path }
+}
+
+// WithStrict answers the requests no route answers with a 501, rather than
+// a 404 or a 405, and keeps them, see Unexpected.
+func WithStrict() Option {
+	return func(o *options) { o.strict = true }
+}
+
+// WithContract checks the routes against the OpenAPI document at spec when
+// the server starts, see CheckContract. the drift is printed to the output,
+// with strict New fails on it.
+func WithContract(spec string, strict bool) Option {
+	return func(o *options) { o.contract, o.contractStrict = spec, strict }
+}
+
+// WithMaxRequests shuts the server down once it answered n requests, the
+// ones to the admin api aside, for scripts that start mok, run a client
+// and wait for it to exit.
+func WithMaxRequests(n int) Option {
+	return func(o *options) { o.maxRequests = n }
+}
+
+// WithTimeout shuts the server down once it served for d, whatever it is
+// doing.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.lifetime = d }
+}
+
+// WithVerbose logs what the server does with the standard logger.
+func WithVerbose() Option {
+	return func(o *options) { o.verbose = true }
+}
+
+// WithRemoteHeaders sends header, e.g. Authorization, with the downloads of
+// remote files, bucket objects aside: they are signed with the ambient
+// credentials.
+func WithRemoteHeaders(header http.Header) Option {
+	return func(o *options) { o.remoteHeader = header }
+}
+
+// WithNetrc authenticates the downloads of remote files with the
+// credentials of their host in the .netrc file at path, $NETRC or ~/.netrc
+// when path is empty. an Authorization header wins, see WithRemoteHeaders.
+func WithNetrc(path string) Option {
+	return func(o *options) {
+		o.netrc = true
+		o.netrcPath = path
+	}
+}
+
+// WithRemoteCache keeps the downloads of remote files in dir instead of
+// temp files, they survive restarts and are revalidated with their ETag or
+// Last-Modified, the cached copy is served when the download fails.
+func WithRemoteCache(dir string) Option {
+	return func(o *options) { o.remoteCache = dir }
+}
+
+// WithRemoteAcceptAny downloads remote files whatever their Content-Type,
+// e.g. text/plain, as long as the body is json. archives and bucket objects
+// are accepted without it.
+func WithRemoteAcceptAny() Option {
+	return func(o *options) { o.acceptAny = true }
+}
+
+// WithRemoteTimeout bounds every download of a remote file, instead of
+// DefaultRemoteTimeout, failed downloads are tried again, each attempt with
+// the whole timeout.
+func WithRemoteTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.timeout = timeout }
+}
+
+// WithRefresh downloads the remote files again every interval, until the
+// server is shut down. unchanged files cost a conditional request, git
+// sources are cloned again when their ref moved.
+func WithRefresh(interval time.Duration) Option {
+	return func(o *options) { o.refresh = interval }
+}
+
+// New loads the routes and builds a Server, nothing listens until Serve or
+// Start is called.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{
+		requests: newRequestLog(capturedRequests),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	if s.opts.out == nil {
+		s.opts.out = io.Discard
+	}
+	if s.opts.fsys == nil {
+		s.opts.fsys = osFS{}
+	}
+	if s.opts.delay.IsZero() {
+		s.opts.delay = s.opts.network.Delay
+	}
+	if s.opts.throttle == 0 {
+		s.opts.throttle = s.opts.network.Bandwidth
+	}
+
+	if s.opts.follow != nil && len(s.opts.directInput) == 0 {
+		return nil, errors.New("following input requires a first document as direct input")
+	}
+	if s.opts.verbose {
+		s.log = log.Default()
+	}
+	s.remote = &remoteConfig{
+		log:       s.log,
+		header:    s.opts.remoteHeader,
+		cacheDir:  s.opts.remoteCache,
+		acceptAny: s.opts.acceptAny,
+		timeout:   s.opts.timeout,
+	}
+	if s.remote.cacheDir != "" {
+		if err := os.MkdirAll(s.remote.cacheDir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating remote cache: %w", err)
+		}
+	}
+	if s.opts.netrc {
+		var err error
+		if s.remote.netrc, err = loadNetrc(cmp.Or(s.opts.netrcPath, netrcPath())); err != nil {
+			return nil, err
+		}
+	}
+	if s.opts.harPath != "" {
+		s.har = &harLog{}
+	}
+	s.input.Store(&s.opts.directInput)
+	s.profile = s.opts.profile
+	if s.opts.prefix != "" && !strings.HasPrefix(s.opts.prefix, "/") {
+		return nil, fmt.Errorf("prefix must start with /, got %q", s.opts.prefix)
+	}
+	if s.opts.clientCAs != nil {
+		if s.opts.tls == nil {
+			return nil, errors.New("client certificates require tls")
+		}
+		s.opts.tls = clientAuthTLS(s.opts.tls, s.opts.clientCAs, s.opts.clientCerts)
+	}
+
+	files, err := s.sourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.init(files); err != nil {
+		removeTemp(s.log, files)
+		return nil, err
+	}
+	if s.opts.contract != "" {
+		if err := s.checkContract(); err != nil {
+			removeTemp(s.log, files)
+			return nil, err
+		}
+	}
+
+	s.handler = withCompression(withJSONStyle(s, s.opts.jsonStyle), cmp.Or(s.opts.compress, CompressAuto))
+	if s.opts.clientCerts == ClientCertsStatus {
+		s.handler = withClientCerts(s.handler, s.opts.clientCAs)
+	}
+	if s.opts.authUser != "" {
+		s.handler = withBasicAuth(s.handler, s.opts.authUser, s.opts.authPass)
+	}
+	if s.opts.throttle > 0 {
+		s.handler = withThrottle(s.handler, s.opts.throttle)
+	}
+	if s.opts.network.Drop > 0 {
+		s.handler = withDrop(s.handler, s.opts.network.Drop, s.log)
+	}
+	if s.opts.cache != "" || s.opts.expires != 0 {
+		s.handler = withCaching(s.handler, s.opts.cache, s.opts.expires)
+	}
+	if len(s.opts.header) > 0 {
+		s.handler = withHeaders(s.handler, s.opts.header)
+	}
+	if s.opts.cors {
+		s.handler = withCORS(s.handler, s.opts.corsOrigins)
+	}
+	if s.opts.accessLog != nil {
+		s.handler = withAccessLog(s.handler, s.opts.accessLog, cmp.Or(s.opts.logFormat, LogCombined))
+	}
+	if s.opts.traces != "" {
+		s.tracer = newTracer(s.opts.traces, s.log)
+		s.handler = withTracing(s.handler, s.tracer)
+	}
+	s.hs = &http.Server{Handler: s.handler, TLSConfig: s.opts.tls}
+	if s.opts.h2c {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		s.hs.Protocols = protocols
+	}
+	if s.grpc != nil {
+		// grpc clients speak http/2 without tls unless told otherwise
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		s.ghs = &http.Server{Handler: s.grpc, TLSConfig: s.opts.tls, Protocols: protocols}
+	}
+
+	if s.opts.watch > 0 {
+		go s.watchFiles(s.opts.watch)
+	}
+	if s.opts.follow != nil {
+		go s.followInput(s.opts.follow)
+	}
+	if s.opts.refresh > 0 {
+		go s.refreshRemote(s.opts.refresh)
+	}
+	return s, nil
+}
+
+func (s *Server) init(files []*MokFile) error {
+	var err error
+	if s.opts.crud != "" {
+		if s.store, err = loadCRUDStore(s.opts.crud, s.log); err != nil {
+			return err
+		}
+	}
+	if s.opts.oidc {
+		if s.oidc, err = newOIDCIssuer(s.opts.oidcClaims, s.opts.clients); err != nil {
+			return err
+		}
+	}
+	if s.opts.webhooks != "" {
+		s.webhooks = &webhookReceiver{path: s.opts.webhooks, secret: s.opts.hookSecret}
+	}
+	if s.opts.grpc != "" {
+		if s.grpc, err = loadGRPC(s.opts.fsys, s.opts.grpc, s.opts.grpcDir, s.log); err != nil {
+			return err
+		}
+	}
+	if s.opts.graphql != "" {
+		if s.graphql, err = loadGraphQL(s.opts.fsys, s.opts.graphql, s.opts.graphqlData); err != nil {
+			return err
+		}
+	}
+	if s.opts.indexTemplate != "" {
+		if s.index, err = loadIndexTemplate(s.opts.indexTemplate); err != nil {
+			return err
+		}
+	}
+	if s.unmatched, err = s.unmatchedHandler(); err != nil {
+		return err
+	}
+	if s.unmatched != nil && len(s.opts.directInput) > 0 && s.opts.inputPath == "" {
+		return errors.New("direct input answers every path no route answers, give it a path to use a fallback, wiremock stubs or static files")
+	}
+	return s.update(files, nil)
+}
+
+// Handler serves the routes, e.g. with httptest.NewServer.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Serve accepts connections on l until the server is shut down, it returns
+// nil once in-flight requests are done.
+func (s *Server) Serve(l net.Listener) error {
+	s.addr = l.Addr()
+	return s.serve(l)
+}
+
+func (s *Server) serve(l net.Listener) error {
+	if s.opts.lifetime > 0 {
+		t := time.AfterFunc(s.opts.lifetime, func() {
+			fmt.Fprintf(s.opts.out, "\n  served for %s, shutting down\n", s.opts.lifetime)
+			s.shutdownInBackground()
+		})
+		defer t.Stop()
+	}
+	var err error
+	if s.opts.tls != nil {
+		err = s.hs.ServeTLS(l, "", "")
+	} else {
+		err = s.hs.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		<-s.done
+		return nil
+	}
+	return err
+}
+
+// ServeGRPC answers the gRPC calls of WithGRPC on l until the server is
+// shut down, over http/2 with or without tls.
+func (s *Server) ServeGRPC(l net.Listener) error {
+	if s.ghs == nil {
+		return errors.New("grpc is not configured, see WithGRPC")
+	}
+	var err error
+	if s.opts.tls != nil {
+		err = s.ghs.ServeTLS(l, "", "")
+	} else {
+		err = s.ghs.Serve(l)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Start listens on addr (":0" picks a free port) and serves in the
+// background, see URL.
+func (s *Server) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.addr = l.Addr()
+	go s.serve(l)
+	return nil
+}
+
+// URL is the base url of a started server, unix:<path> when it serves on
+// a unix socket.
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.opts.tls != nil {
+		scheme = "https"
+	}
+	switch {
+	case s.addr == nil:
+		return ""
+	case s.addr.Network() == "unix":
+		return "unix:" + s.addr.String()
+	}
+	return scheme + "://" + s.addr.String()
+}
+
+// Shutdown stops the server, waiting for in-flight requests until ctx is
+// done. the watcher stops too, the pending spans are exported, the HAR of
+// WithRecordHAR is written and the downloaded copies of remote files are
+// removed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.stop.Do(func() {
+		defer close(s.done)
+		if s.ghs != nil {
+			err = s.ghs.Shutdown(ctx)
+		}
+		err = cmp.Or(s.hs.Shutdown(ctx), err)
+
+		if s.tracer != nil {
+			s.tracer.close()
+		}
+		if s.har != nil {
+			err = cmp.Or(err, s.saveHAR())
+		}
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		removeTemp(s.log, append(slices.Clone(s.files), s.added...))
+	})
+	return err
+}
+
+// shutdownInBackground shuts the server down without waiting for it, from
+// a request that would be waited for otherwise.
+func (s *Server) shutdownInBackground() {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			logInfo(s.log, "shutdown: "+err.Error())
+		}
+	}()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	mux := s.mux
+	s.mu.RUnlock()
+
+	// the dashboard polls, its own requests would drown everything else
+	if strings.HasPrefix(r.URL.Path, "/__mok__/") {
+		mux.ServeHTTP(w, r)
+		return
+	}
+
+	e := Request{
+		Time:   time.Now(),
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Header: r.Header.Clone(),
+		Body:   captureBody(r),
+	}
+	var reqBody []byte
+	var reqTruncated bool
+	var hw *harWriter
+	if s.har != nil {
+		reqBody, reqTruncated = readBody(r)
+		hw = &harWriter{ResponseWriter: w}
+		w = hw
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	if !s.opts.strict || s.strictMatch(mux, rec, r) {
+		mux.ServeHTTP(rec, r)
+	}
+	if sp := spanFrom(r.Context()); sp != nil {
+		sp.setRoute(r.Pattern)
+	}
+
+	e.Route = r.Pattern
+	e.Status = cmp.Or(rec.status, http.StatusOK)
+	elapsed := time.Since(e.Time)
+	e.Duration = elapsed.Round(time.Microsecond).String()
+	s.requests.add(e)
+	s.stats.add(r.Pattern, elapsed)
+	if s.har != nil {
+		s.har.add(r, reqBody, reqTruncated, hw, e.Status, e.Time)
+	}
+	if n := s.served.Add(1); n == int64(s.opts.maxRequests) {
+		fmt.Fprintf(s.opts.out, "\n  answered %d/%d requests, shutting down\n", n, s.opts.maxRequests)
+		s.shutdownInBackground()
+	}
+}
+
+// Requests lists the captured requests, newest first.
+func (s *Server) Requests() []Request {
+	return s.requests.list()
+}
+
+// Routes lists the served routes, added routes replace the ones with the
+// same pattern.
+func (s *Server) Routes() []*MokFile {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return mergeRoutes(s.files, s.added)
+}
+
+func mergeRoutes(files, added []*MokFile) []*MokFile {
+	merged := slices.DeleteFunc(slices.Clone(files), func(f *MokFile) bool {
+		return slices.ContainsFunc(added, func(a *MokFile) bool { return a.pattern() == f.pattern() })
+	})
+	return append(merged, added...)
+}
+
+// AddFile serves path, a file, directory, OpenAPI document or remote url,
+// next to the current routes.
+func (s *Server) AddFile(path string) error {
+	files, err := processFileArgs(s.opts.fsys, s.remote, []string{path}, s.opts.namespace)
+	if err != nil {
+		return err
+	}
+	if s.opts.pretty {
+		prettyURLs(files, s.opts.keepExt)
+	}
+	s.applyDefaults(files)
+	if err := s.add(files...); err != nil {
+		removeTemp(s.log, files)
+		return err
+	}
+	return nil
+}
+
+// AddRoute serves a route of the config format, local files are relative
+// to the working directory and cannot be outside of it. it replaces the
+// route with the same method and path until it is removed.
+func (s *Server) AddRoute(route RouteConfig) error {
+	if !strings.HasPrefix(route.Path, "/") {
+		return fmt.Errorf("path must start with /, got %q", route.Path)
+	}
+	for _, p := range routePaths(route) {
+		if !filepath.IsLocal(filepath.FromSlash(p)) {
+			return fmt.Errorf("%q is outside the working directory", p)
+		}
+	}
+	file, err := routeFile(s.opts.fsys, s.remote, route, ".")
+	if err != nil {
+		return err
+	}
+	s.applyDefaults([]*MokFile{file})
+	return s.add(file)
+}
+
+// routePaths are the local files a route reads: fixtures, scripts and
+// schemas.
+func routePaths(route RouteConfig) []string {
+	responses := append([]ResponseConfig{route.ResponseConfig}, route.Responses...)
+	for _, rc := range route.Rules {
+		responses = append(responses, rc.ResponseConfig)
+	}
+	if route.Auth != nil {
+		for _, resp := range []*ResponseConfig{route.Auth.Unauthorized, route.Auth.Forbidden} {
+			if resp != nil {
+				responses = append(responses, *resp)
+			}
+		}
+	}
+	paths := []string{route.JSONRPC, route.Schema}
+	if route.WebSocket != wsEcho {
+		paths = append(paths, route.WebSocket)
+	}
+	for _, resp := range responses {
+		paths = append(paths, resp.File)
+	}
+	return slices.DeleteFunc(paths, func(p string) bool { return p == "" || isRemote(p) })
+}
+
+func (s *Server) add(files ...*MokFile) error {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	s.mu.RLock()
+	current := s.files
+	added := slices.DeleteFunc(slices.Clone(s.added), func(a *MokFile) bool {
+		return slices.ContainsFunc(files, func(f *MokFile) bool { return a.pattern() == f.pattern() })
+	})
+	s.mu.RUnlock()
+
+	if err := s.update(current, append(added, files...)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		logInfo(s.log, fmt.Sprintf("added %s", f.pattern()))
+	}
+	return nil
+}
+
+var (
+	// errNoRoute is returned when removing a route that is not served.
+	errNoRoute = errors.New("no such route")
+	// errPattern wraps the ServeMux complaints about invalid and conflicting
+	// patterns.
+	errPattern = errors.New("cannot register route")
+)
+
+// RemoveRoute stops serving the route with method (empty for the route
+// answering every method) and path, prefixed by the host of virtual host
+// routes like in their pattern (api.local/users). routes from the config and the files
+// options come back on Reload.
+func (s *Server) RemoveRoute(method, path string) error {
+	target := &MokFile{URLPath: path, Method: strings.ToUpper(method)}
+	matches := func(f *MokFile) bool { return f.pattern() == target.pattern() }
+
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	s.mu.RLock()
+	files, added := slices.Clone(s.files), slices.Clone(s.added)
+	s.mu.RUnlock()
+
+	switch {
+	case slices.ContainsFunc(added, matches):
+		added = slices.DeleteFunc(added, matches)
+	case slices.ContainsFunc(files, matches):
+		files = slices.DeleteFunc(files, matches)
+	default:
+		return fmt.Errorf("%w %s", errNoRoute, target.pattern())
+	}
+
+	if err := s.update(files, added); err != nil {
+		return err
+	}
+	logInfo(s.log, fmt.Sprintf("removed %s", target.pattern()))
+	return nil
+}
+
+// Profile is the selected profile of the config, "" when there is none.
+func (s *Server) Profile() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.profile
+}
+
+// Profiles lists the profiles of the config.
+func (s *Server) Profiles() ([]string, error) {
+	if s.opts.config == "" {
+		return nil, nil
+	}
+	cfg, err := loadConfig(s.opts.fsys, s.opts.config)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(cfg.Profiles)), nil
+}
+
+// SetProfile switches to the named profile of the config and reloads the
+// routes, "" goes back to the plain config. added routes are kept, the
+// current routes keep being served when that fails.
+func (s *Server) SetProfile(name string) error {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	s.mu.Lock()
+	prev := s.profile
+	s.profile = name
+	s.mu.Unlock()
+
+	files, err := s.sourceFiles()
+	if err == nil {
+		s.mu.RLock()
+		added := s.added
+		s.mu.RUnlock()
+		if err = s.update(files, added); err != nil {
+			removeTemp(s.log, files)
+		}
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.profile = prev
+		s.mu.Unlock()
+		return err
+	}
+
+	fmt.Fprintf(s.opts.out, "  switched to profile %s\n", cmp.Or(name, "(none)"))
+	return nil
+}
+
+// SetDirectInput replaces the direct input served by the server, see
+// WithDirectInput.
+func (s *Server) SetDirectInput(input []byte) error {
+	if !json.Valid(input) {
+		return errors.New("direct input is not valid json")
+	}
+	s.input.Store(&input)
+	for _, f := range s.Routes() {
+		if !f.direct {
+			continue
+		}
+		f.mu.Lock()
+		f.content = input
+		f.modTime = time.Now()
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+// followInput serves every json document read from r as the direct input.
+func (s *Server) followInput(r io.Reader) {
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(s.opts.out, "  stopped following input: %s\n", err)
+			}
+			return
+		}
+		if err := s.SetDirectInput(doc); err != nil {
+			logInfo(s.log, err.Error())
+			continue
+		}
+		logInfo(s.log, fmt.Sprintf("direct input updated, %d bytes", len(doc)))
+	}
+}
+
+// Reload reads the config and the files again, added routes are kept. the
+// current routes keep being served when that fails.
+func (s *Server) Reload() error {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	files, err := s.sourceFiles()
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	added := s.added
+	s.mu.RUnlock()
+	if err := s.update(files, added); err != nil {
+		removeTemp(s.log, files)
+		return err
+	}
+
+	fmt.Fprintln(s.opts.out, "  reloaded routes")
+	return nil
+}
+
+// sourceFiles loads the routes of the config and of the files, the config
+// comes first.
+func (s *Server) sourceFiles() ([]*MokFile, error) {
+	var files []*MokFile
+	var cfg *Config
+	if s.opts.config != "" {
+		var err error
+		if cfg, err = loadConfig(s.opts.fsys, s.opts.config); err != nil {
+			return nil, err
+		}
+		logInfo(s.log, fmt.Sprintf("loaded config: %q", s.opts.config))
+		if files, err = configFiles(s.opts.fsys, s.remote, cfg, s.opts.config); err != nil {
+			return nil, err
+		}
+	}
+
+	argFiles, err := s.fileArgs(s.opts.files)
+	if err != nil {
+		removeTemp(s.log, files)
+		return nil, err
+	}
+	files = append(files, argFiles...)
+
+	for _, vhost := range s.opts.vhosts {
+		hostFiles, err := s.fileArgs([]string{vhost.source})
+		if err != nil {
+			removeTemp(s.log, files)
+			return nil, err
+		}
+		for _, f := range hostFiles {
+			f.Host = vhost.at
+		}
+		files = append(files, hostFiles...)
+	}
+	if input := *s.input.Load(); len(input) > 0 && s.opts.inputPath != "" {
+		file, err := inlineFile(s.opts.inputPath, input)
+		if err != nil {
+			removeTemp(s.log, files)
+			return nil, err
+		}
+		file.FilePath = "direct input"
+		file.direct = true
+		files = append(files, file)
+	}
+	for _, inline := range s.opts.inline {
+		file, err := inlineFile(inline.at, []byte(inline.source))
+		if err != nil {
+			removeTemp(s.log, files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	for _, ws := range s.opts.websockets {
+		file, err := webSocketFile(s.opts.fsys, ws.at, ws.source, ".")
+		if err != nil {
+			removeTemp(s.log, files)
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	for _, rpc := range s.opts.jsonrpc {
+		file, err := jsonRPCFile(s.opts.fsys, rpc.at, rpc.source, ".")
+		if err != nil {
+			removeTemp(s.log, files)
+			return nil, err
+		}
+		file.Method = http.MethodPost
+		files = append(files, file)
+	}
+
+	if s.profile != "" {
+		if files, err = s.applyProfile(cfg, files); err != nil {
+			removeTemp(s.log, files)
+			return nil, err
+		}
+	}
+
+	if err := routeConflict(files); err != nil {
+		removeTemp(s.log, files)
+		return nil, err
+	}
+	s.applyDefaults(files)
+	return files, nil
+}
+
+// fileArgs resolves files, directories and urls into served files.
+func (s *Server) fileArgs(args []string) ([]*MokFile, error) {
+	files, err := processFileArgs(s.opts.fsys, s.remote, args, s.opts.namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		logInfo(s.log, fmt.Sprintf("found file: %q", f.FilePath))
+	}
+	if s.opts.pretty {
+		prettyURLs(files, s.opts.keepExt)
+	}
+	return files, nil
+}
+
+// applyProfile serves the routes of the current profile in place of the
+// ones of files with the same method and path.
+func (s *Server) applyProfile(cfg *Config, files []*MokFile) ([]*MokFile, error) {
+	profile, err := cfg.profile(s.profile)
+	if err != nil {
+		return files, err
+	}
+	routes, err := configFiles(s.opts.fsys, s.remote, &Config{Routes: profile.Routes}, s.opts.config)
+	if err != nil {
+		return files, fmt.Errorf("profile %s: %w", s.profile, err)
+	}
+	argFiles, err := s.fileArgs(profile.fileArgs(s.opts.fsys, s.opts.config))
+	if err != nil {
+		removeTemp(s.log, routes)
+		return files, fmt.Errorf("profile %s: %w", s.profile, err)
+	}
+
+	merged := mergeRoutes(files, append(routes, argFiles...))
+	removeTemp(s.log, slices.DeleteFunc(files, func(f *MokFile) bool { return slices.Contains(merged, f) }))
+	profile.apply(merged)
+	return merged, nil
+}
+
+// applyDefaults applies the server delay, content type, templating and rate
+// limit to the files without one of their own, and mounts them under the
+// prefix.
+func (s *Server) applyDefaults(files []*MokFile) {
+	for _, f := range files {
+		f.URLPath = s.opts.prefix + f.URLPath
+		if f.alias != "" {
+			f.alias = s.opts.prefix + f.alias
+		}
+	}
+	for _, f := range allFiles(files) {
+		if f.Delay.IsZero() {
+			f.Delay = s.opts.delay
+		}
+		f.ContentType = cmp.Or(f.ContentType, s.opts.contentType)
+		f.Template = f.Template || s.opts.templates
+	}
+	// limits count requests to the route, not to its responses
+	for _, f := range files {
+		if f.RateLimit.IsZero() {
+			f.RateLimit = s.opts.rateLimit
+		}
+		if !f.RateLimit.IsZero() && f.limiter == nil {
+			f.limiter = newTokenBucket(f.RateLimit)
+		}
+	}
+}
+
+// update builds a mux serving files and added and swaps it in, the current
+// one is kept when that fails. downloaded files of the routes that are gone
+// are removed.
+func (s *Server) update(files, added []*MokFile) error {
+	mux, err := s.build(mergeRoutes(files, added))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := append(slices.Clone(files), added...)
+	gone := slices.DeleteFunc(append(slices.Clone(s.files), s.added...), func(f *MokFile) bool {
+		return slices.Contains(kept, f)
+	})
+	s.mux, s.files, s.added = mux, files, added
+	removeTemp(s.log, gone)
+	return nil
+}
+
+func (s *Server) build(files []*MokFile) (mux *http.ServeMux, err error) {
+	defer func() {
+		// ServeMux panics on invalid and conflicting patterns
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%w: %v", errPattern, p)
+		}
+	}()
+
+	mux = http.NewServeMux()
+	s.setupHandlers(mux, files)
+	if s.store != nil {
+		s.store.register(mux)
+	}
+	if s.oidc != nil {
+		s.oidc.register(mux)
+	}
+	if s.graphql != nil {
+		s.graphql.register(mux)
+	}
+	if s.opts.utils {
+		registerUtils(mux)
+	}
+	if s.webhooks != nil {
+		s.webhooks.register(mux)
+	}
+	s.registerAdmin(mux)
+
+	if s.unmatched != nil {
+		// the least specific pattern, it only sees what nothing else matched
+		mux.Handle("/", s.unmatched)
+	}
+	return mux, nil
+}
+
+func (s *Server) setupHandlers(mux *http.ServeMux, files []*MokFile) {
+	if len(s.opts.directInput) > 0 && s.opts.inputPath == "" {
+		// the least specific pattern, the routes win
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			s.opts.delay.sleep(r.Context())
+			serveDirectInput(w, *s.input.Load())
+		})
+	} else if !s.opts.noIndex && !slices.ContainsFunc(files, func(f *MokFile) bool { return f.URLPath == "/{$}" }) &&
+		(s.static == nil || !s.static.hasIndex()) {
+		// only the exact root, anything else falls through to the mux so that
+		// unknown paths are 404 and known paths with the wrong method are 405.
+		mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Accept") == "application/json" {
+				serveJSONIndex(w, files)
+				return
+			}
+			if s.index != nil {
+				serveIndex(s.index, files)(w, r)
+				return
+			}
+			serveDashboard(w, r)
+		})
+	}
+
+	hasScenarios := false
+	for _, f := range files {
+		for _, p := range f.patterns() {
+			mux.HandleFunc(p, f.handle)
+		}
+		hasScenarios = hasScenarios || f.sequence != nil
+	}
+
+	if hasScenarios {
+		handleAdmin(mux, "POST /__mok__/scenarios/reset", resetScenarios(files))
+	}
+	mux.HandleFunc("GET /__mok__/openapi.json", serveOpenAPI(files))
+}
+
+// unmatchedHandler serves requests no route matches, static files first,
+// wiremock stubs next, then upstream. it is nil when none is configured.
+func (s *Server) unmatchedHandler() (http.Handler, error) {
+	var unmatched http.Handler
+	if s.opts.fallback != "" {
+		target, err := parseTarget(s.opts.fallback)
+		if err != nil {
+			return nil, err
+		}
+		unmatched = newProxy(target, s.log)
+	}
+	if s.opts.wiremock != "" {
+		wm, err := loadWiremock(s.opts.wiremock, s.log)
+		if err != nil {
+			return nil, err
+		}
+		wm.next = unmatched
+		unmatched = wm
+		fmt.Fprintf(s.opts.out, "  serving %d wiremock stubs from %s\n\n", len(wm.stubs), s.opts.wiremock)
+	}
+	if s.opts.static != "" {
+		static, err := newStaticFiles(s.opts.fsys, s.opts.static)
+		if err != nil {
+			return nil, err
+		}
+		static.next = unmatched
+		s.static, unmatched = static, static
+	}
+	return unmatched, nil
+}
+
+// PrintSummary writes where the server listens and what it serves to the
+// output, see WithOutput.
+func (s *Server) PrintSummary(baseURL string) {
+	out := s.opts.out
+	catchAll := len(s.opts.directInput) > 0 && s.opts.inputPath == ""
+	if catchAll && len(s.Routes()) == 0 {
+		fmt.Fprintf(out, "mok is serving direct input on %s/\n", baseURL)
+		return
+	}
+
+	fmt.Fprintf(out, "  mok is listening at %s\n", baseURL)
+	if profile := s.Profile(); profile != "" {
+		fmt.Fprintf(out, "  serving profile %s\n", profile)
+	}
+	if files := s.Routes(); len(files) > 0 {
+		fmt.Fprintln(out, "\n  available endpoints:")
+
+		maxURLLen := 0
+		for _, file := range files {
+			urlLen := len("GET " + file.pattern())
+			if urlLen > maxURLLen {
+				maxURLLen = urlLen
+			}
+		}
+
+		for _, file := range files {
+			url := file.pattern()
+			source := fmt.Sprintf("(%s)", file.FilePath)
+			if file.Status != 0 {
+				source += fmt.Sprintf(" -> %d", file.Status)
+			}
+
+			padding := maxURLLen - len(" "+url)
+			spaces := strings.Repeat(" ", padding)
+
+			fmt.Fprintf(out, "   %s%s  %s\n", url, spaces, source)
+		}
+	}
+
+	if catchAll {
+		fmt.Fprintln(out, "\n  direct input:\n   every path no route answers")
+	}
+
+	if s.store != nil {
+		fmt.Fprintf(out, "\n  crud collections (%s):\n", s.store.path)
+		for _, name := range s.store.names() {
+			fmt.Fprintf(out, "   /%s  /%s/{id}\n", name, name)
+		}
+	}
+
+	if s.static != nil {
+		fmt.Fprintf(out, "\n  static files:\n   /  (%s)\n", s.static.dir)
+	}
+
+	if s.graphql != nil {
+		fmt.Fprintf(out, "\n  graphql (%s):\n   /graphql\n", s.opts.graphql)
+	}
+
+	if s.grpc != nil {
+		fmt.Fprintf(out, "\n  grpc methods (%s):\n", s.opts.grpc)
+		for _, p := range s.grpc.paths() {
+			fmt.Fprintf(out, "   %s\n", p)
+		}
+	}
+
+	if s.opts.utils {
+		fmt.Fprintln(out, "\n  utilities:\n   /status/{codes}  /delay/{seconds}  /headers  /ip  /anything  /echo  /bytes/{n}  /json/{n}")
+	}
+
+	if s.webhooks != nil {
+		verified := "unsigned"
+		if s.webhooks.secret != "" {
+			verified = "github and stripe signatures verified"
+		}
+		fmt.Fprintf(out, "\n  webhooks (%s):\n   POST %s\n", verified, s.webhooks.path)
+	}
+
+	if s.oidc != nil {
+		fmt.Fprintf(out, "\n  oidc issuer:\n   %s/.well-known/openid-configuration\n", baseURL)
+	}
+}
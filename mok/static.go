@@ -0,0 +1,67 @@
+package mok
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// staticFiles serves the files of a directory as they are, images, html,
+// csv and anything else, with the Content-Type of their extension or
+// sniffed from their content. it answers what no route matches, before
+// wiremock stubs and the fallback.
+type staticFiles struct {
+	dir  string
+	fsys fs.FS
+	// next serves requests for missing files, 404 when nil.
+	next http.Handler
+}
+
+func newStaticFiles(fsys fs.FS, dir string) (*staticFiles, error) {
+	var sub fs.FS
+	if isOS(fsys) {
+		// fs.Sub doesn't know about absolute and parent paths
+		sub = os.DirFS(dir)
+	} else {
+		var err error
+		if sub, err = fs.Sub(fsys, fsPath(fsys, dir)); err != nil {
+			return nil, err
+		}
+	}
+	if info, err := fs.Stat(sub, "."); err != nil {
+		return nil, fmt.Errorf("static: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("static: %s is not a directory", dir)
+	}
+	return &staticFiles{dir: dir, fsys: sub}, nil
+}
+
+func (s *staticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if info, err := fs.Stat(s.fsys, name); err == nil && info.IsDir() {
+		name = path.Join(name, "index.html")
+	}
+
+	if info, err := fs.Stat(s.fsys, name); err != nil || info.IsDir() {
+		if s.next != nil {
+			s.next.ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFileFS(w, r, s.fsys, name)
+}
+
+// hasIndex reports whether the directory has an index.html, it then takes
+// the root path from the dashboard.
+func (s *staticFiles) hasIndex() bool {
+	_, err := fs.Stat(s.fsys, "index.html")
+	return err == nil
+}
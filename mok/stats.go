@@ -0,0 +1,158 @@
+package mok
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsSamples is how many latencies of every route the percentiles are
+// computed over, the latest ones.
+const statsSamples = 1000
+
+// RouteStats is how often a route was hit and how fast it answered, the
+// latencies are in milliseconds. requests no route answered are counted
+// under the route "".
+type RouteStats struct {
+	Route string  `json:"route"`
+	Hits  int     `json:"hits"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// routeCounter counts the hits of a route and keeps its latest latencies.
+type routeCounter struct {
+	hits      int
+	latencies []time.Duration
+	next      int
+	max       time.Duration
+}
+
+type routeStats struct {
+	mu     sync.Mutex
+	routes map[string]*routeCounter
+}
+
+func (st *routeStats) add(route string, d time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.routes == nil {
+		st.routes = map[string]*routeCounter{}
+	}
+	c := st.routes[route]
+	if c == nil {
+		c = &routeCounter{}
+		st.routes[route] = c
+	}
+	c.hits++
+	c.max = max(c.max, d)
+	if len(c.latencies) < statsSamples {
+		c.latencies = append(c.latencies, d)
+		return
+	}
+	c.latencies[c.next] = d
+	c.next = (c.next + 1) % len(c.latencies)
+}
+
+func (st *routeStats) clear() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.routes = nil
+}
+
+// Stats lists the hits and latencies of every route, the routes never hit
+// included, most hit first.
+func (s *Server) Stats() []RouteStats {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	stats := []RouteStats{}
+	seen := map[string]bool{}
+	for route, c := range s.stats.routes {
+		sorted := slices.Sorted(slices.Values(c.latencies))
+		stats = append(stats, RouteStats{
+			Route: route,
+			Hits:  c.hits,
+			P50:   millis(percentile(sorted, 50)),
+			P90:   millis(percentile(sorted, 90)),
+			P99:   millis(percentile(sorted, 99)),
+			Max:   millis(c.max),
+		})
+		seen[route] = true
+	}
+	for _, f := range s.Routes() {
+		for _, pattern := range f.patterns() {
+			if !seen[pattern] {
+				stats = append(stats, RouteStats{Route: pattern})
+				seen[pattern] = true
+			}
+		}
+	}
+	slices.SortFunc(stats, func(a, b RouteStats) int {
+		return cmp.Or(cmp.Compare(b.Hits, a.Hits), cmp.Compare(a.Route, b.Route))
+	})
+	return stats
+}
+
+// percentile is the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// PrintStats writes the Stats of the session as a table, to see which
+// fixtures a test run exercised.
+func (s *Server) PrintStats() {
+	writeStats(s.opts.out, s.Stats())
+}
+
+func writeStats(out io.Writer, stats []RouteStats) {
+	if len(stats) == 0 {
+		return
+	}
+	width := len("route")
+	for _, st := range stats {
+		width = max(width, len(cmp.Or(st.Route, "(no route)")))
+	}
+	fmt.Fprintf(out, "\n  %-*s  %6s  %9s  %9s  %9s  %9s\n", width, "route", "hits", "p50", "p90", "p99", "max")
+	for _, st := range stats {
+		fmt.Fprintf(out, "  %-*s  %6d", width, cmp.Or(st.Route, "(no route)"), st.Hits)
+		if st.Hits == 0 {
+			fmt.Fprintf(out, "  %9s  %9s  %9s  %9s\n", "-", "-", "-", "-")
+			continue
+		}
+		fmt.Fprintf(out, "  %7.2fms  %7.2fms  %7.2fms  %7.2fms\n", st.P50, st.P90, st.P99, st.Max)
+	}
+}
+
+// serveStats answers the Stats as json, or as the table of PrintStats to
+// ?format=text.
+func (s *Server) serveStats(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Query().Get("format"), "text") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeStats(w, s.Stats())
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Stats())
+}
+
+func (s *Server) clearStats(w http.ResponseWriter, r *http.Request) {
+	s.stats.clear()
+	w.WriteHeader(http.StatusNoContent)
+}
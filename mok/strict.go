@@ -0,0 +1,58 @@
+package mok
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// with WithStrict a request no route answers, an unknown path or a method
+// the path does not take, is a 501 rather than a 404 or a 405, and is kept
+// for the end of the run: mok then fails a test suite that called the api
+// in a way its fixtures do not describe.
+
+// Unexpected is a request strict mode answered with a 501, Count is how
+// many times it was sent.
+type Unexpected struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Count  int    `json:"count"`
+}
+
+type unexpectedLog struct {
+	mu    sync.Mutex
+	calls []Unexpected
+}
+
+func (l *unexpectedLog) add(r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := slices.IndexFunc(l.calls, func(u Unexpected) bool {
+		return u.Method == r.Method && u.Path == r.URL.Path
+	})
+	if i < 0 {
+		l.calls = append(l.calls, Unexpected{Method: r.Method, Path: r.URL.Path})
+		i = len(l.calls) - 1
+	}
+	l.calls[i].Count++
+}
+
+// Unexpected lists the requests no route answered in strict mode, in the
+// order they were first sent, see WithStrict.
+func (s *Server) Unexpected() []Unexpected {
+	s.unexpected.mu.Lock()
+	defer s.unexpected.mu.Unlock()
+	return slices.Clone(s.unexpected.calls)
+}
+
+// strictMatch reports whether a route answers r, the unexpected requests
+// are answered and kept otherwise.
+func (s *Server) strictMatch(mux *http.ServeMux, w http.ResponseWriter, r *http.Request) bool {
+	if _, pattern := mux.Handler(r); pattern != "" {
+		return true
+	}
+	s.unexpected.add(r)
+	http.Error(w, "mok: unexpected request, no route answers "+r.Method+" "+r.URL.Path, http.StatusNotImplemented)
+	return false
+}
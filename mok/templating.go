@@ -0,0 +1,118 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// maxTemplateBody caps how much of the request body templates can see.
+const maxTemplateBody = 10 << 20
+
+// templateData is what fixture templates are rendered with:
+//
+//	{"hello": {{ json .Query.name }}, "id": {{ .PathParam.id }}}
+type templateData struct {
+	Method    string
+	Path      string
+	Query     map[string]string
+	Header    map[string]string
+	PathParam map[string]string
+	// Body is the request body decoded as json, an empty object when it is
+	// not json so that {{ .Body.field }} never fails.
+	Body any
+	// RawBody is the request body as is.
+	RawBody string
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes v, use it to safely embed request data in responses
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// default returns def when v is empty
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// isTemplate reports whether content has template actions, templates
+// without any are served untouched. fixtures are only templates when their
+// route says so, see WithTemplates, literal {{ }} are common in json.
+func isTemplate(content []byte) bool {
+	return bytes.Contains(content, []byte("{{"))
+}
+
+func renderTemplate(name string, content []byte, r *http.Request) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+
+	data, err := newTemplateData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("rendering template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func newTemplateData(r *http.Request) (*templateData, error) {
+	data := &templateData{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     make(map[string]string),
+		Header:    make(map[string]string),
+		PathParam: make(map[string]string),
+		Body:      map[string]any{},
+	}
+	for k, v := range r.URL.Query() {
+		data.Query[k] = v[0]
+	}
+	for k, v := range r.Header {
+		data.Header[k] = strings.Join(v, ", ")
+	}
+	for _, name := range patternWildcards(r.Pattern) {
+		data.PathParam[name] = r.PathValue(name)
+	}
+
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxTemplateBody))
+		if err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+		data.RawBody = string(body)
+		if len(body) > 0 {
+			var v any
+			if json.Unmarshal(body, &v) == nil {
+				data.Body = v
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// patternWildcards returns the wildcard names of a ServeMux pattern,
+// "GET /users/{id}/{rest...}" -> [id rest].
+func patternWildcards(pattern string) []string {
+	var names []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if wildcardRe.MatchString(segment) {
+			names = append(names, strings.TrimSuffix(strings.Trim(segment, "{}"), "..."))
+		}
+	}
+	return names
+}
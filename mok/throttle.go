@@ -0,0 +1,121 @@
+package mok
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// throttleTick is how often a throttled response writes a chunk, small
+// enough for progress bars to move smoothly.
+const throttleTick = 50 * time.Millisecond
+
+// Bandwidth is a transfer rate in bytes per second, parsed from bits
+// ("50kbps", "1.5mbps") or bytes ("64KB/s", "1MB/s"), units are powers of
+// 1000 like network speeds are.
+type Bandwidth int64
+
+var bandwidthUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	// longest suffixes first, "kbps" also ends in "bps"
+	{"gbps", 1e9 / 8},
+	{"mbps", 1e6 / 8},
+	{"kbps", 1e3 / 8},
+	{"bps", 1.0 / 8},
+	{"gb/s", 1e9},
+	{"mb/s", 1e6},
+	{"kb/s", 1e3},
+	{"b/s", 1},
+}
+
+func parseBandwidth(s string) (Bandwidth, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	lower := strings.ToLower(s)
+	for _, u := range bandwidthUnits {
+		num, found := strings.CutSuffix(lower, u.suffix)
+		if !found {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid bandwidth %q", s)
+		}
+		return Bandwidth(max(n*u.bytes, 1)), nil
+	}
+	return 0, fmt.Errorf("invalid bandwidth %q, expected e.g. 50kbps, 2mbps or 64KB/s", s)
+}
+
+func (b Bandwidth) String() string {
+	if b == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(b)*8/1e3, 'f', -1, 64) + "kbps"
+}
+
+// Set implements flag.Value.
+func (b *Bandwidth) Set(s string) error {
+	parsed, err := parseBandwidth(s)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// withThrottle caps how fast response bodies are written, emulating a slow
+// network. the admin API and the dashboard are not throttled.
+func withThrottle(next http.Handler, rate Bandwidth) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/__mok__/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&throttledWriter{ResponseWriter: w, ctx: r.Context(), rate: rate}, r)
+	})
+}
+
+// throttledWriter writes a chunk every throttleTick and flushes it, so the
+// client really receives the body slowly instead of all of it at the end.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx  context.Context
+	rate Bandwidth
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	chunk := max(int(int64(w.rate)*int64(throttleTick)/int64(time.Second)), 1)
+	rc := http.NewResponseController(w.ResponseWriter)
+
+	written := 0
+	for written < len(b) {
+		n, err := w.ResponseWriter.Write(b[written:min(written+chunk, len(b))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		rc.Flush()
+
+		t := time.NewTimer(time.Duration(int64(n) * int64(time.Second) / int64(w.rate)))
+		select {
+		case <-t.C:
+		case <-w.ctx.Done():
+			t.Stop()
+			return written, w.ctx.Err()
+		}
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,287 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// with WithTracing every request is a server span, child of the span of
+// its W3C traceparent header, exported to an OTLP/HTTP collector as json.
+// the fallback proxy passes the span on to the real api in traceparent,
+// the captured requests keep the header the client sent.
+
+const (
+	// traceBatch is how many spans are exported at once at most, they
+	// are exported every traceInterval otherwise.
+	traceBatch    = 512
+	traceInterval = time.Second
+	// traceQueue is how many spans wait for the collector, the ones
+	// after it are dropped.
+	traceQueue = 4096
+)
+
+// TracesEndpoint is where spans are exported to by default, after the
+// environment variables of the OpenTelemetry SDKs, "" without any.
+func TracesEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// span is the server span of a request.
+type span struct {
+	traceID, spanID, parentID string
+	sampled                   bool
+	state                     string
+	name                      string
+	start, end                time.Time
+	attrs                     map[string]any
+}
+
+type spanKey struct{}
+
+// spanFrom is the span of the request of ctx, nil when not tracing.
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// setRoute names s after the pattern of the route answering it,
+// "GET /users/{id}" is the route /users/{id}.
+func (s *span) setRoute(pattern string) {
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		s.attrs["http.route"] = pattern[i:]
+	}
+}
+
+// traceparent is the traceparent header naming s as the parent.
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + s.traceID + "-" + s.spanID + "-" + flags
+}
+
+// parseTraceparent reads the trace id, the parent span id and the sampled
+// flag of a version 00 traceparent header.
+func parseTraceparent(h string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false, false
+	}
+	for _, p := range parts[:4] {
+		if _, err := hex.DecodeString(p); err != nil || strings.ToLower(p) != p {
+			return "", "", false, false
+		}
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return "", "", false, false
+	}
+	flags, _ := strconv.ParseUint(parts[3], 16, 8)
+	return parts[1], parts[2], flags&1 == 1, true
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tracer exports the spans of the server to an OTLP/HTTP endpoint.
+type tracer struct {
+	log      *log.Logger
+	endpoint string
+	service  string
+	client   *http.Client
+
+	spans chan *span
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// newTracer exports to endpoint, the traces path of a collector is added
+// when it has no path, http://localhost:4318 is http://localhost:4318/v1/traces.
+func newTracer(endpoint string, l *log.Logger) *tracer {
+	if u, err := url.Parse(endpoint); err == nil && strings.Trim(u.Path, "/") == "" {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	t := &tracer{
+		log:      l,
+		endpoint: endpoint,
+		service:  cmp.Or(os.Getenv("OTEL_SERVICE_NAME"), "mok"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan *span, traceQueue),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.export()
+	return t
+}
+
+// withTracing runs every request in a span, child of its traceparent.
+// requests to the admin api are not traced.
+func withTracing(next http.Handler, t *tracer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/__mok__/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s := &span{spanID: randomID(8), sampled: true, name: r.Method, start: time.Now()}
+		if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			s.traceID, s.parentID, s.sampled = traceID, parentID, sampled
+			s.state = r.Header.Get("tracestate")
+		} else {
+			s.traceID = randomID(16)
+		}
+		s.attrs = map[string]any{
+			"http.request.method": r.Method,
+			"url.path":            r.URL.Path,
+			"url.scheme":          "http",
+			"server.address":      r.Host,
+		}
+		if r.TLS != nil {
+			s.attrs["url.scheme"] = "https"
+		}
+		if r.URL.RawQuery != "" {
+			s.attrs["url.query"] = r.URL.RawQuery
+		}
+		if ua := r.UserAgent(); ua != "" {
+			s.attrs["user_agent.original"] = ua
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			s.attrs["client.address"] = host
+		}
+
+		rec := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), spanKey{}, s)))
+
+		s.end = time.Now()
+		s.attrs["http.response.status_code"] = cmp.Or(rec.status, http.StatusOK)
+		if route, ok := s.attrs["http.route"].(string); ok {
+			s.name = r.Method + " " + route
+		}
+		if s.sampled {
+			t.record(s)
+		}
+	})
+}
+
+// record queues s for export, spans are dropped when the collector does
+// not keep up.
+func (t *tracer) record(s *span) {
+	select {
+	case t.spans <- s:
+	default:
+		logInfo(t.log, "dropping a span, the trace exporter is behind")
+	}
+}
+
+// export sends the queued spans in batches until close.
+func (t *tracer) export() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-t.spans:
+			if batch = append(batch, s); len(batch) < traceBatch {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.stop:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			t.send(batch)
+			return
+		}
+		t.send(batch)
+		batch = nil
+	}
+}
+
+// close exports the spans still queued.
+func (t *tracer) close() {
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+}
+
+// send posts spans to the collector in the json encoding of OTLP.
+func (t *tracer) send(spans []*span) {
+	if len(spans) == 0 {
+		return
+	}
+	otlp := make([]map[string]any, len(spans))
+	for i, s := range spans {
+		attrs := make([]map[string]any, 0, len(s.attrs))
+		for k, v := range s.attrs {
+			value := map[string]any{"stringValue": fmt.Sprint(v)}
+			if n, ok := v.(int); ok {
+				value = map[string]any{"intValue": strconv.Itoa(n)}
+			}
+			attrs = append(attrs, map[string]any{"key": k, "value": value})
+		}
+		code := 0 // unset
+		if status, _ := s.attrs["http.response.status_code"].(int); status >= 500 {
+			code = 2 // error
+		}
+		otlp[i] = map[string]any{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"parentSpanId":      s.parentID,
+			"traceState":        s.state,
+			"name":              s.name,
+			"kind":              2, // server
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attrs,
+			"status":            map[string]any{"code": code},
+		}
+	}
+	body, _ := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []any{
+				map[string]any{"key": "service.name", "value": map[string]any{"stringValue": t.service}},
+			}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "mok"},
+				"spans": otlp,
+			}},
+		}},
+	})
+
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logInfo(t.log, fmt.Sprintf("exporting %d spans: %s", len(spans), err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logInfo(t.log, fmt.Sprintf("exporting %d spans: %s", len(spans), resp.Status))
+	}
+}
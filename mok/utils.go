@@ -0,0 +1,170 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUtilDelay caps /delay, like httpbin does.
+const maxUtilDelay = 10 * time.Second
+
+// registerUtils mounts the endpoints of httpbin behind -utils, for testing
+// the plumbing of clients without running httpbin next to mok:
+//
+//	/status/{codes}     answers with the status, one of 200,404 picked at random
+//	/delay/{seconds}    answers like /anything after the delay, 10s at most
+//	GET /headers        the request headers
+//	GET /ip             the address of the client
+//	/anything           the request, any method and any path below it
+//	/echo               the request, see echoedRequest
+//	GET /bytes/{n}      n random bytes, see serveBytes
+//	GET /json/{n}       a json array of n bytes, see serveJSON
+func registerUtils(mux *http.ServeMux) {
+	mux.HandleFunc("/status/{codes}", serveStatus)
+	mux.HandleFunc("/delay/{seconds}", serveDelay)
+	mux.HandleFunc("GET /headers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"headers": requestHeaders(r)})
+	})
+	mux.HandleFunc("GET /ip", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"origin": clientAddr(r)})
+	})
+	mux.HandleFunc("/anything", serveAnything)
+	mux.HandleFunc("/anything/", serveAnything)
+	mux.HandleFunc("/echo", serveEcho)
+	mux.HandleFunc("GET /bytes/{n}", serveBytes)
+	mux.HandleFunc("GET /json/{n}", serveJSON)
+}
+
+// serveStatus answers with the status of the path, or one of a comma
+// separated list of them.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	codes := strings.Split(r.PathValue("codes"), ",")
+	code, err := strconv.Atoi(strings.TrimSpace(codes[rand.IntN(len(codes))]))
+	if err != nil || code < 100 || code > 999 {
+		http.Error(w, "invalid status "+r.PathValue("codes"), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(code)
+}
+
+func serveDelay(w http.ResponseWriter, r *http.Request) {
+	seconds, err := strconv.ParseFloat(r.PathValue("seconds"), 64)
+	if err != nil || seconds < 0 {
+		http.Error(w, "invalid delay "+r.PathValue("seconds"), http.StatusBadRequest)
+		return
+	}
+	delay := min(time.Duration(seconds*float64(time.Second)), maxUtilDelay)
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+	serveAnything(w, r)
+}
+
+// serveAnything describes the request the way httpbin does: the query in
+// args, the raw body in data, forms in form and files and json bodies in
+// json.
+func serveAnything(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMatchBody))
+	if err != nil {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := map[string]any{
+		"method":  r.Method,
+		"url":     requestURL(r),
+		"args":    flatten(r.URL.Query()),
+		"headers": requestHeaders(r),
+		"origin":  clientAddr(r),
+		"data":    "",
+		"form":    map[string]any{},
+		"files":   map[string]any{},
+		"json":    nil,
+	}
+
+	ctype, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case ctype == "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			resp["form"] = flatten(form)
+		}
+	case ctype == "multipart/form-data":
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(maxMatchBody)
+		if err != nil {
+			http.Error(w, "parsing multipart body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer form.RemoveAll()
+		resp["form"] = flatten(form.Value)
+		files := url.Values{}
+		for name, headers := range form.File {
+			for _, h := range headers {
+				if f, err := h.Open(); err == nil {
+					content, _ := io.ReadAll(f)
+					f.Close()
+					files.Add(name, string(content))
+				}
+			}
+		}
+		resp["files"] = flatten(files)
+	default:
+		resp["data"] = string(body)
+		var v any
+		if json.Unmarshal(body, &v) == nil {
+			resp["json"] = v
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// flatten turns the values of a query or a form into a json object, a
+// string for names given once and a list for the repeated ones.
+func flatten(values url.Values) map[string]any {
+	flat := make(map[string]any, len(values))
+	for name, v := range values {
+		if len(v) == 1 {
+			flat[name] = v[0]
+		} else {
+			flat[name] = v
+		}
+	}
+	return flat
+}
+
+// requestHeaders are the headers of r with Host, which net/http moves out of
+// them, repeated headers are joined with commas.
+func requestHeaders(r *http.Request) map[string]string {
+	headers := map[string]string{"Host": r.Host}
+	for name, v := range r.Header {
+		headers[name] = strings.Join(v, ",")
+	}
+	return headers
+}
+
+// clientAddr is the ip address r came from.
+func clientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// requestURL is the absolute url r was sent to.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
@@ -0,0 +1,205 @@
+package mok
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Problem is something wrong with a fixture or a config, Line and Column
+// are 1-based and 0 when unknown.
+type Problem struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Err    string `json:"error"`
+}
+
+// String is the problem the way compilers put it, file:line:column: error.
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.File != "" {
+		b.WriteString(p.File + ":")
+		if p.Line > 0 {
+			fmt.Fprintf(&b, "%d:", p.Line)
+			if p.Column > 0 {
+				fmt.Fprintf(&b, "%d:", p.Column)
+			}
+		}
+		b.WriteString(" ")
+	}
+	return b.String() + p.Err
+}
+
+// Validate reads the config and the fixtures of args like New would,
+// without serving them, and lists what is wrong with them: fixtures that
+// do not parse and routes that conflict, every one of them rather than the
+// first. fsys is where local files are read from, the operating system when
+// nil, like New. files is how many fixtures were checked.
+func Validate(fsys fs.FS, config string, args []string) (problems []Problem, files int) {
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	var served []*MokFile
+	defer func() { removeTemp(nil, served) }()
+	if config != "" {
+		if cfg, err := loadConfig(fsys, config); err != nil {
+			problems = append(problems, yamlProblem(config, err))
+		} else {
+			// every route on its own, a broken one does not hide the others
+			baseDir := dirPath(fsys, config)
+			for i, route := range cfg.Routes {
+				file, err := configRoute(fsys, nil, i, route, baseDir)
+				if err != nil {
+					problems = append(problems, Problem{File: config, Err: err.Error()})
+					continue
+				}
+				served = append(served, file)
+			}
+		}
+	}
+
+	args, err := expandArgFiles(fsys, args)
+	if err != nil {
+		problems = append(problems, Problem{Err: err.Error()})
+		args = nil
+	}
+	// every source on its own, a broken one does not hide the others
+	seen := map[string]bool{}
+	for _, arg := range args {
+		var resolved []*MokFile
+		if isRemote(arg) {
+			resolved, err = processFileArgs(fsys, nil, []string{arg}, false)
+		} else {
+			resolved, err = resolveFile(fsys, nil, arg)
+		}
+		if err != nil {
+			problems = append(problems, Problem{File: arg, Err: err.Error()})
+			continue
+		}
+		for _, f := range resolved {
+			if !seen[f.FilePath] {
+				seen[f.FilePath] = true
+				served = append(served, f)
+			}
+		}
+	}
+
+	for _, f := range allFiles(served) {
+		if f.inline || f.paramFile || f.FilePath == "" {
+			continue
+		}
+		files++
+		content, err := fs.ReadFile(f.source(), f.FilePath)
+		if err != nil {
+			problems = append(problems, Problem{File: f.FilePath, Err: err.Error()})
+			continue
+		}
+		if f.local() {
+			content = expandEnv(f.FilePath, content)
+		}
+		if p, ok := checkFixture(f.FilePath, content, f.Template); !ok {
+			problems = append(problems, p)
+		}
+	}
+	return append(problems, routeProblems(served)...), files
+}
+
+// checkFixture parses the fixture at name the way it is served, templates
+// are only json once rendered.
+func checkFixture(name string, content []byte, template bool) (Problem, bool) {
+	switch {
+	case isYAML(name):
+		if _, err := yamlToJSON(content); err != nil {
+			return yamlProblem(name, err), false
+		}
+	case isCSV(name):
+		if _, err := csvToJSON(content); err != nil {
+			p := Problem{File: name, Err: err.Error()}
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				p.Line, p.Column, p.Err = perr.Line, perr.Column, perr.Err.Error()
+			}
+			return p, false
+		}
+	case isJSONC(name), path.Ext(name) == ".json":
+		if !isJSONC(name) && template && isTemplate(content) {
+			return Problem{}, true
+		}
+		stripped := stripJSONC(content)
+		err := json.Unmarshal(stripped, new(any))
+		if err == nil {
+			return Problem{}, true
+		}
+		p := Problem{File: name, Err: err.Error()}
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			p.Line, p.Column = lineColumn(stripped, int(serr.Offset))
+		}
+		return p, false
+	}
+	return Problem{}, true
+}
+
+// lineColumn is where the byte at offset is in content, a json syntax error
+// is at the byte before its offset.
+func lineColumn(content []byte, offset int) (line, column int) {
+	offset = min(max(offset-1, 0), len(content))
+	before := content[:offset]
+	line = strings.Count(string(before), "\n") + 1
+	return line, offset - strings.LastIndexByte(string(before), '\n')
+}
+
+var yamlLine = regexp.MustCompile(`line (\d+)`)
+
+// yamlProblem finds the line in the errors of yaml.v3, "yaml: line 3: ...".
+func yamlProblem(name string, err error) Problem {
+	p := Problem{File: name, Err: err.Error()}
+	if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+		p.Line, _ = strconv.Atoi(m[1])
+	}
+	return p
+}
+
+// routeProblems lists the routes served twice and the patterns the
+// ServeMux refuses, every one of them rather than the first.
+func routeProblems(files []*MokFile) []Problem {
+	var problems []Problem
+	mux := http.NewServeMux()
+	served := map[string]*MokFile{}
+	for _, f := range files {
+		for _, p := range f.patterns() {
+			if other, ok := served[p]; ok && other != f {
+				problems = append(problems, Problem{
+					File: f.FilePath,
+					Err:  fmt.Sprintf("%s is served at %s already", other.FilePath, p),
+				})
+				continue
+			}
+			served[p] = f
+			if err := register(mux, p); err != nil {
+				problems = append(problems, Problem{File: f.FilePath, Err: err.Error()})
+			}
+		}
+	}
+	return problems
+}
+
+// register adds pattern to mux, ServeMux panics on invalid and
+// conflicting patterns.
+func register(mux *http.ServeMux, pattern string) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%w: %v", errPattern, p)
+		}
+	}()
+	mux.HandleFunc(pattern, http.NotFound)
+	return nil
+}
@@ -0,0 +1,54 @@
+package mok
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// WatchInterval is how often the mok command polls watched files.
+const WatchInterval = 500 * time.Millisecond
+
+// watchFiles polls the served files and reloads the ones whose modification
+// time or size changed. polling is boring but works everywhere (network
+// mounts, editors replacing files on save, containers) without extra deps.
+// routes are listed on every tick, they change through the admin API.
+func (s *Server) watchFiles(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		for _, f := range allFiles(s.Routes()) {
+			if f.inline || f.paramFile || f.FilePath == "" {
+				// read on every request anyway, or nothing to read
+				continue
+			}
+
+			info, err := fs.Stat(f.source(), f.FilePath)
+			if err != nil {
+				// files are often deleted and recreated on save, try next tick
+				logInfo(s.log, fmt.Sprintf("cannot stat watched file %q: %s", f.FilePath, err))
+				continue
+			}
+
+			f.mu.RLock()
+			unchanged := info.ModTime().Equal(f.modTime) && info.Size() == f.size
+			f.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+
+			if err := f.load(); err != nil {
+				logInfo(s.log, fmt.Sprintf("cannot reload %q: %s", f.FilePath, err))
+				continue
+			}
+			fmt.Fprintf(s.opts.out, "  reloaded %s (%s)\n", f.URLPath, f.FilePath)
+		}
+	}
+}
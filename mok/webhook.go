@@ -0,0 +1,218 @@
+package mok
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookTolerance is how old the timestamp of a Stripe signature may be,
+// the default of Stripe's libraries, replayed webhooks are refused.
+const webhookTolerance = 5 * time.Minute
+
+// Webhook is a webhook mok received as a consumer, see WithWebhooks.
+// Signature is how it was signed, github or stripe, and Error why it was
+// refused, Verified is set when it matched the secret.
+type Webhook struct {
+	Time      time.Time   `json:"time"`
+	Path      string      `json:"path"`
+	Event     string      `json:"event,omitempty"`
+	Header    http.Header `json:"header"`
+	Body      any         `json:"body"`
+	Signature string      `json:"signature,omitempty"`
+	Verified  bool        `json:"verified"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// webhookReceiver records the webhooks POSTed to path, the latest
+// capturedRequests of them, and verifies their signatures when it has a
+// secret:
+//
+//	github  X-Hub-Signature-256: sha256=<hmac of the body>
+//	stripe  Stripe-Signature: t=<unix time>,v1=<hmac of "<time>.<body>">
+//
+// both are hex encoded HMAC-SHA256. webhooks without a valid signature are
+// answered 401 and recorded too, tests assert on refusals as well.
+type webhookReceiver struct {
+	path   string
+	secret string
+
+	mu       sync.Mutex
+	received []Webhook
+}
+
+func (wr *webhookReceiver) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+wr.path, wr.receive)
+}
+
+func (wr *webhookReceiver) receive(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMatchBody))
+	if err != nil {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook := Webhook{
+		Time:   time.Now(),
+		Path:   r.URL.Path,
+		Event:  webhookEvent(r.Header, body),
+		Header: r.Header.Clone(),
+		Body:   string(body),
+	}
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		hook.Body = v
+	}
+	if wr.secret != "" {
+		hook.Signature, err = verifySignature(r.Header, body, wr.secret, hook.Time)
+		hook.Verified = err == nil
+		if err != nil {
+			hook.Error = err.Error()
+		}
+	}
+	wr.add(hook)
+
+	if hook.Error != "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": hook.Error})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"received": true})
+}
+
+func (wr *webhookReceiver) add(hook Webhook) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if len(wr.received) == capturedRequests {
+		wr.received = slices.Delete(wr.received, 0, 1)
+	}
+	wr.received = append(wr.received, hook)
+}
+
+// webhookEvent is the kind of a webhook: X-GitHub-Event, or the type or
+// event field of a json body, as Stripe and most others send it.
+func webhookEvent(header http.Header, body []byte) string {
+	if event := header.Get("X-GitHub-Event"); event != "" {
+		return event
+	}
+	var fields struct {
+		Type  any `json:"type"`
+		Event any `json:"event"`
+	}
+	json.Unmarshal(body, &fields)
+	for _, v := range []any{fields.Type, fields.Event} {
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// verifySignature checks the GitHub or the Stripe signature of body and
+// returns which one it was.
+func verifySignature(header http.Header, body []byte, secret string, now time.Time) (string, error) {
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		hexSig, ok := strings.CutPrefix(sig, "sha256=")
+		if !ok || !validMAC(body, hexSig, secret) {
+			return "github", errors.New("X-Hub-Signature-256 does not match the secret")
+		}
+		return "github", nil
+	}
+	if sig := header.Get("Stripe-Signature"); sig != "" {
+		var ts string
+		var macs []string
+		for _, part := range strings.Split(sig, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "t":
+				ts = v
+			case "v1":
+				macs = append(macs, v)
+			}
+		}
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return "stripe", errors.New("Stripe-Signature has no timestamp")
+		}
+		if age := now.Sub(time.Unix(unix, 0)); age > webhookTolerance || age < -webhookTolerance {
+			return "stripe", fmt.Errorf("Stripe-Signature is %s old, more than %s", age.Round(time.Second), webhookTolerance)
+		}
+		signed := append([]byte(ts+"."), body...)
+		if !slices.ContainsFunc(macs, func(mac string) bool { return validMAC(signed, mac, secret) }) {
+			return "stripe", errors.New("Stripe-Signature does not match the secret")
+		}
+		return "stripe", nil
+	}
+	return "", errors.New("missing signature, expected X-Hub-Signature-256 or Stripe-Signature")
+}
+
+// validMAC reports whether hexMAC is the HMAC-SHA256 of payload with secret.
+func validMAC(payload []byte, hexMAC, secret string) bool {
+	got, err := hex.DecodeString(hexMAC)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// serveWebhooks lists the received webhooks newest first, filtered by
+// ?event=, ?verified= and ?since= (see parseSince) and capped with ?limit=.
+func (wr *webhookReceiver) serveWebhooks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var verified *bool
+	if raw := query.Get("verified"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid verified %q", raw), http.StatusBadRequest)
+			return
+		}
+		verified = &v
+	}
+	limit := -1
+	if raw := query.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", raw), http.StatusBadRequest)
+			return
+		}
+	}
+	event := query.Get("event")
+
+	wr.mu.Lock()
+	received := slices.Clone(wr.received)
+	wr.mu.Unlock()
+	slices.Reverse(received)
+
+	hooks := []Webhook{}
+	for _, h := range received {
+		if limit >= 0 && len(hooks) == limit {
+			break
+		}
+		if (event == "" || h.Event == event) && (verified == nil || h.Verified == *verified) &&
+			(since.IsZero() || !h.Time.Before(since)) {
+			hooks = append(hooks, h)
+		}
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+func (wr *webhookReceiver) clear(w http.ResponseWriter, r *http.Request) {
+	wr.mu.Lock()
+	wr.received = nil
+	wr.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
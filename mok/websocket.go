@@ -0,0 +1,377 @@
+package mok
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// websocket routes upgrade the connection and play a script, echo answers
+// every message with itself:
+//
+//	frames:            # sent once connected, in order
+//	  - send: {"type": "hello"}
+//	  - delay: 1s
+//	    send: {"type": "tick"}
+//	loop: true         # play the frames again until the client leaves
+//	echo: true         # send back the messages no reply matches
+//	replies:
+//	  - match: ping    # a regexp on the client message
+//	    send: pong
+//	close: true        # close once the frames are sent
+//
+// strings are sent as they are, anything else as json.
+
+// wsEcho is the script answering every message with itself.
+const wsEcho = "echo"
+
+// wsGUID is what the handshake key is hashed with, see RFC 6455.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage is the largest message a client can send.
+const wsMaxMessage = 16 << 20
+
+// wsCloseTimeout is how long the client gets to answer a close frame.
+const wsCloseTimeout = time.Second
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// wsScript is what a websocket route sends, see the comment above.
+type wsScript struct {
+	Frames  []wsMessage `yaml:"frames,omitempty"`
+	Loop    bool        `yaml:"loop,omitempty"`
+	Echo    bool        `yaml:"echo,omitempty"`
+	Replies []wsReply   `yaml:"replies,omitempty"`
+	Close   bool        `yaml:"close,omitempty"`
+}
+
+// wsMessage is a message sent after waiting for Delay.
+type wsMessage struct {
+	Send  wsPayload `yaml:"send"`
+	Delay Delay     `yaml:"delay,omitempty"`
+}
+
+// wsReply is sent when a client message matches Match.
+type wsReply struct {
+	Match     string `yaml:"match"`
+	wsMessage `yaml:",inline"`
+
+	re *regexp.Regexp
+}
+
+// wsPayload is the text of a message, yaml values that are not strings
+// are encoded as json.
+type wsPayload []byte
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *wsPayload) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode && value.Tag == "!!str" {
+		*p = []byte(value.Value)
+		return nil
+	}
+	var v any
+	if err := value.Decode(&v); err != nil {
+		return err
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return err
+	}
+	*p, err = json.Marshal(v)
+	return err
+}
+
+// webSocketFile builds the route playing the script at scriptPath, or
+// echoing when it is "echo". scripts are relative to baseDir.
+func webSocketFile(fsys fs.FS, urlPath, scriptPath, baseDir string) (*MokFile, error) {
+	script := &wsScript{Echo: true}
+	if scriptPath != wsEcho {
+		if !filepath.IsAbs(scriptPath) {
+			scriptPath = joinPath(fsys, baseDir, scriptPath)
+		}
+		data, err := fs.ReadFile(fsys, fsPath(fsys, scriptPath))
+		if err != nil {
+			return nil, fmt.Errorf("reading websocket script: %w", err)
+		}
+		script = &wsScript{}
+		if err := yaml.Unmarshal(data, script); err != nil {
+			return nil, fmt.Errorf("parsing websocket script %q: %w", scriptPath, err)
+		}
+		if err := script.validate(); err != nil {
+			return nil, fmt.Errorf("websocket script %q: %w", scriptPath, err)
+		}
+	}
+
+	return &MokFile{
+		FilePath: "websocket: " + scriptPath,
+		URLPath:  urlPath,
+		ws:       script,
+	}, nil
+}
+
+func (s *wsScript) validate() error {
+	if s.Loop && len(s.Frames) == 0 {
+		return fmt.Errorf("loop without frames")
+	}
+	if s.Loop && s.Close {
+		return fmt.Errorf("loop and close cannot be used together")
+	}
+	for i := range s.Replies {
+		re, err := regexp.Compile(s.Replies[i].Match)
+		if err != nil {
+			return fmt.Errorf("reply %d: %w", i, err)
+		}
+		s.Replies[i].re = re
+	}
+	return nil
+}
+
+// serve upgrades the connection and plays the script until the client or
+// the script closes it.
+func (s *wsScript) serve(w http.ResponseWriter, r *http.Request) {
+	c, err := acceptWebSocket(w, r)
+	if err != nil {
+		// the client was told why
+		return
+	}
+	defer c.conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer cancel()
+		s.answer(ctx, c)
+	}()
+
+	for {
+		for _, m := range s.Frames {
+			if !c.send(ctx, m) {
+				return
+			}
+		}
+		if !s.Loop {
+			break
+		}
+	}
+
+	if s.Close {
+		c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, 1000))
+		select {
+		case <-ctx.Done():
+		case <-time.After(wsCloseTimeout):
+		}
+		return
+	}
+	<-ctx.Done()
+}
+
+// answer reads the client messages and sends the replies, it returns when
+// the connection is closed.
+func (s *wsScript) answer(ctx context.Context, c *wsConn) {
+	for {
+		op, msg, err := c.readMessage()
+		if err != nil {
+			return
+		}
+
+		replied := false
+		for _, reply := range s.Replies {
+			if reply.re.Match(msg) {
+				replied = true
+				if !c.send(ctx, reply.wsMessage) {
+					return
+				}
+			}
+		}
+		if !replied && s.Echo {
+			c.writeFrame(op, msg)
+		}
+	}
+}
+
+// acceptWebSocket answers the handshake and takes over the connection, the
+// request gets a 426 when it is not an upgrade.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not an upgrade")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("bad handshake")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	// clients asking for subprotocols want one back, mok speaks whatever
+	if protocol, _, _ := strings.Cut(r.Header.Get("Sec-WebSocket-Protocol"), ","); protocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + strings.TrimSpace(protocol) + "\r\n"
+	}
+	if _, err := rw.WriteString(resp + "\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// wsConn is the server side of a websocket connection.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu sync.Mutex // serializes writes, the script and the replies race
+}
+
+// send waits for the delay of m and sends it, it reports whether the
+// connection is still up.
+func (c *wsConn) send(ctx context.Context, m wsMessage) bool {
+	m.Delay.sleep(ctx)
+	if ctx.Err() != nil {
+		return false
+	}
+	return c.writeFrame(wsText, m.Send) == nil
+}
+
+// writeFrame writes a single unmasked frame, servers don't mask.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	header := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = binary.BigEndian.AppendUint16(append(header, 126), uint16(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// readMessage reads the next text or binary message, joining fragments and
+// answering pings on the way. it returns io.EOF once the client closes.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var (
+		op  byte
+		msg []byte
+	)
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOp {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			// echo the status code, that completes the closing handshake
+			c.writeFrame(wsClose, payload[:min(len(payload), 2)])
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, errors.New("continuation without a message")
+			}
+		default:
+			op = frameOp
+		}
+
+		msg = append(msg, payload...)
+		if len(msg) > wsMaxMessage {
+			return 0, nil, errors.New("message too large")
+		}
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.r, head[:]); err != nil {
+		return
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, errors.New("message too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
@@ -0,0 +1,419 @@
+package mok
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// wiremock stubs are loaded from a WireMock root directory, mappings/*.json
+// describe the stubs and bodyFileName is relative to __files/, just like
+// WireMock does. stubs match with regexps and priorities rather than paths,
+// so they get their own handler, consulted when no mok route matches.
+type wiremockStub struct {
+	source string
+
+	Priority int             `json:"priority"`
+	Request  wiremockRequest `json:"request"`
+	Response struct {
+		Status                 int             `json:"status"`
+		Headers                map[string]any  `json:"headers"`
+		Body                   string          `json:"body"`
+		JSONBody               json.RawMessage `json:"jsonBody"`
+		Base64Body             string          `json:"base64Body"`
+		BodyFileName           string          `json:"bodyFileName"`
+		FixedDelayMilliseconds int             `json:"fixedDelayMilliseconds"`
+	} `json:"response"`
+
+	body    []byte
+	urlRe   *regexp.Regexp
+	headers map[string]*stringMatcher
+	query   map[string]*stringMatcher
+	bodies  []*bodyMatcher
+}
+
+type wiremockRequest struct {
+	Method          string                     `json:"method"`
+	URL             string                     `json:"url"`
+	URLPath         string                     `json:"urlPath"`
+	URLPattern      string                     `json:"urlPattern"`
+	URLPathPattern  string                     `json:"urlPathPattern"`
+	URLPathTemplate string                     `json:"urlPathTemplate"`
+	Headers         map[string]json.RawMessage `json:"headers"`
+	QueryParameters map[string]json.RawMessage `json:"queryParameters"`
+	BodyPatterns    []json.RawMessage          `json:"bodyPatterns"`
+}
+
+// stringMatcher is a WireMock string value pattern: equalTo, contains,
+// doesNotContain, matches, doesNotMatch or absent.
+type stringMatcher struct {
+	EqualTo         *string `json:"equalTo"`
+	CaseInsensitive bool    `json:"caseInsensitive"`
+	Contains        *string `json:"contains"`
+	DoesNotContain  *string `json:"doesNotContain"`
+	Matches         *string `json:"matches"`
+	DoesNotMatch    *string `json:"doesNotMatch"`
+	Absent          bool    `json:"absent"`
+
+	re *regexp.Regexp
+}
+
+// bodyMatcher is a WireMock body pattern, on top of the string patterns it
+// supports equalToJson and matchesJsonPath (dotted paths and [n] indexes).
+type bodyMatcher struct {
+	stringMatcher
+	EqualToJSON     json.RawMessage `json:"equalToJson"`
+	MatchesJSONPath json.RawMessage `json:"matchesJsonPath"`
+
+	jsonValue any
+	jsonPath  string
+	pathValue *stringMatcher
+}
+
+type wiremockHandler struct {
+	log   *log.Logger
+	stubs []*wiremockStub
+	// next serves requests no stub matches, 404 when nil.
+	next http.Handler
+}
+
+func loadWiremock(root string, l *log.Logger) (*wiremockHandler, error) {
+	mappings := filepath.Join(root, "mappings")
+	if info, err := os.Stat(mappings); err != nil || !info.IsDir() {
+		// also accept the mappings directory itself
+		mappings = root
+		root = filepath.Dir(root)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(mappings, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	h := &wiremockHandler{log: l}
+	for _, p := range paths {
+		stubs, err := loadWiremockMapping(p, filepath.Join(root, "__files"))
+		if err != nil {
+			return nil, fmt.Errorf("wiremock %q: %w", p, err)
+		}
+		h.stubs = append(h.stubs, stubs...)
+	}
+	if len(h.stubs) == 0 {
+		return nil, fmt.Errorf("wiremock: no mappings found in %q", mappings)
+	}
+
+	// lower numbers win, WireMock defaults to 5
+	slices.SortStableFunc(h.stubs, func(a, b *wiremockStub) int {
+		return cmp.Compare(cmp.Or(a.Priority, 5), cmp.Or(b.Priority, 5))
+	})
+
+	logInfo(h.log, fmt.Sprintf("wiremock: loaded %d stubs from %q", len(h.stubs), mappings))
+	return h, nil
+}
+
+// loadWiremockMapping reads a mapping file, either a single stub or
+// {"mappings": [...]}.
+func loadWiremockMapping(path, filesDir string) ([]*wiremockStub, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var many struct {
+		Mappings []*wiremockStub `json:"mappings"`
+	}
+	if err := json.Unmarshal(data, &many); err != nil {
+		return nil, err
+	}
+	stubs := many.Mappings
+	if len(stubs) == 0 {
+		var stub wiremockStub
+		if err := json.Unmarshal(data, &stub); err != nil {
+			return nil, err
+		}
+		stubs = []*wiremockStub{&stub}
+	}
+
+	for i, stub := range stubs {
+		stub.source = fmt.Sprintf("%s#%d", path, i)
+		if err := stub.compile(filesDir); err != nil {
+			return nil, fmt.Errorf("mapping %d: %w", i, err)
+		}
+	}
+	return stubs, nil
+}
+
+func (s *wiremockStub) compile(filesDir string) error {
+	req := s.Request
+	var err error
+	switch {
+	case req.URL != "":
+		s.urlRe, err = regexp.Compile("^" + regexp.QuoteMeta(req.URL) + "$")
+	case req.URLPath != "":
+		s.urlRe, err = regexp.Compile("^" + regexp.QuoteMeta(req.URLPath) + "$")
+	case req.URLPattern != "":
+		s.urlRe, err = regexp.Compile("^(?:" + req.URLPattern + ")$")
+	case req.URLPathPattern != "":
+		s.urlRe, err = regexp.Compile("^(?:" + req.URLPathPattern + ")$")
+	case req.URLPathTemplate != "":
+		tmpl := regexp.QuoteMeta(req.URLPathTemplate)
+		tmpl = regexp.MustCompile(`\\\{[^}]+\\\}`).ReplaceAllString(tmpl, `[^/]+`)
+		s.urlRe, err = regexp.Compile("^" + tmpl + "$")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid url matcher: %w", err)
+	}
+
+	if s.headers, err = compileStringMatchers(req.Headers); err != nil {
+		return fmt.Errorf("headers: %w", err)
+	}
+	if s.query, err = compileStringMatchers(req.QueryParameters); err != nil {
+		return fmt.Errorf("queryParameters: %w", err)
+	}
+	for _, raw := range req.BodyPatterns {
+		bm, err := compileBodyMatcher(raw)
+		if err != nil {
+			return fmt.Errorf("bodyPatterns: %w", err)
+		}
+		s.bodies = append(s.bodies, bm)
+	}
+
+	resp := &s.Response
+	switch {
+	case resp.BodyFileName != "":
+		if s.body, err = os.ReadFile(filepath.Join(filesDir, filepath.Clean("/"+resp.BodyFileName))); err != nil {
+			return fmt.Errorf("bodyFileName: %w", err)
+		}
+	case len(resp.JSONBody) > 0:
+		s.body = resp.JSONBody
+	case resp.Base64Body != "":
+		if s.body, err = base64.StdEncoding.DecodeString(resp.Base64Body); err != nil {
+			return fmt.Errorf("base64Body: %w", err)
+		}
+	default:
+		s.body = []byte(resp.Body)
+	}
+	return nil
+}
+
+func compileStringMatchers(raw map[string]json.RawMessage) (map[string]*stringMatcher, error) {
+	matchers := make(map[string]*stringMatcher, len(raw))
+	for name, data := range raw {
+		m := &stringMatcher{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := m.compile(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		matchers[name] = m
+	}
+	return matchers, nil
+}
+
+func compileBodyMatcher(raw json.RawMessage) (*bodyMatcher, error) {
+	bm := &bodyMatcher{}
+	if err := json.Unmarshal(raw, bm); err != nil {
+		return nil, err
+	}
+
+	if len(bm.EqualToJSON) > 0 {
+		// equalToJson is either inline json or a string containing json
+		var s string
+		if json.Unmarshal(bm.EqualToJSON, &s) == nil {
+			bm.EqualToJSON = json.RawMessage(s)
+		}
+		if err := json.Unmarshal(bm.EqualToJSON, &bm.jsonValue); err != nil {
+			return nil, fmt.Errorf("equalToJson: %w", err)
+		}
+	}
+
+	if len(bm.MatchesJSONPath) > 0 {
+		// either "$.path" or {"expression": "$.path", "equalTo": "..."}
+		if json.Unmarshal(bm.MatchesJSONPath, &bm.jsonPath) != nil {
+			var expr struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(bm.MatchesJSONPath, &expr); err != nil {
+				return nil, fmt.Errorf("matchesJsonPath: %w", err)
+			}
+			bm.jsonPath = expr.Expression
+			bm.pathValue = &stringMatcher{}
+			if err := json.Unmarshal(bm.MatchesJSONPath, bm.pathValue); err != nil {
+				return nil, fmt.Errorf("matchesJsonPath: %w", err)
+			}
+			if err := bm.pathValue.compile(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := parsePath(bm.jsonPath); err != nil {
+			return nil, fmt.Errorf("matchesJsonPath: %w", err)
+		}
+	}
+
+	return bm, bm.compile()
+}
+
+func (m *stringMatcher) compile() error {
+	var expr *string
+	if m.Matches != nil {
+		expr = m.Matches
+	} else if m.DoesNotMatch != nil {
+		expr = m.DoesNotMatch
+	}
+	if expr == nil {
+		return nil
+	}
+	var err error
+	m.re, err = regexp.Compile("^(?:" + *expr + ")$")
+	return err
+}
+
+// match checks value, present is false when the header, parameter or
+// body is missing altogether.
+func (m *stringMatcher) match(value string, present bool) bool {
+	if m.Absent {
+		return !present
+	}
+	if !present {
+		return false
+	}
+
+	switch {
+	case m.EqualTo != nil && m.CaseInsensitive:
+		return strings.EqualFold(value, *m.EqualTo)
+	case m.EqualTo != nil:
+		return value == *m.EqualTo
+	case m.Contains != nil:
+		return strings.Contains(value, *m.Contains)
+	case m.DoesNotContain != nil:
+		return !strings.Contains(value, *m.DoesNotContain)
+	case m.Matches != nil:
+		return m.re.MatchString(value)
+	case m.DoesNotMatch != nil:
+		return !m.re.MatchString(value)
+	}
+	return true
+}
+
+func (bm *bodyMatcher) match(body []byte) bool {
+	switch {
+	case bm.jsonValue != nil:
+		var got any
+		return json.Unmarshal(body, &got) == nil && reflect.DeepEqual(got, bm.jsonValue)
+	case bm.jsonPath != "":
+		var doc any
+		if json.Unmarshal(body, &doc) != nil {
+			return false
+		}
+		v, ok := evalPath(doc, bm.jsonPath)
+		if !ok {
+			return false
+		}
+		return bm.pathValue == nil || bm.pathValue.match(jsonString(v), true)
+	}
+	return bm.stringMatcher.match(string(body), len(body) > 0)
+}
+
+func (s *wiremockStub) matches(r *http.Request, body []byte) bool {
+	if method := s.Request.Method; method != "" && method != "ANY" && method != r.Method {
+		return false
+	}
+
+	if s.urlRe != nil {
+		target := r.URL.Path
+		if s.Request.URL != "" || s.Request.URLPattern != "" {
+			// url and urlPattern include the query string
+			target = r.URL.RequestURI()
+		}
+		if !s.urlRe.MatchString(target) {
+			return false
+		}
+	}
+
+	for name, m := range s.headers {
+		values, present := r.Header[http.CanonicalHeaderKey(name)]
+		if !m.match(strings.Join(values, ","), present) {
+			return false
+		}
+	}
+
+	query := r.URL.Query()
+	for name, m := range s.query {
+		values, present := query[name]
+		if !present {
+			if !m.match("", false) {
+				return false
+			}
+			continue
+		}
+		if !slices.ContainsFunc(values, func(v string) bool { return m.match(v, true) }) {
+			return false
+		}
+	}
+
+	for _, bm := range s.bodies {
+		if !bm.match(body) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *wiremockHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMatchBody))
+	if err != nil {
+		http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	for _, stub := range h.stubs {
+		if stub.matches(r, body) {
+			logInfo(h.log, fmt.Sprintf("wiremock: %s %s matched %s", r.Method, r.URL, stub.source))
+			stub.serve(w, r)
+			return
+		}
+	}
+
+	if h.next != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, "mok: no wiremock stub matched the request", http.StatusNotFound)
+}
+
+func (s *wiremockStub) serve(w http.ResponseWriter, r *http.Request) {
+	Delay{Base: time.Duration(s.Response.FixedDelayMilliseconds) * time.Millisecond}.sleep(r.Context())
+
+	for name, value := range s.Response.Headers {
+		switch v := value.(type) {
+		case string:
+			w.Header().Set(name, v)
+		case []any:
+			for _, e := range v {
+				w.Header().Add(name, fmt.Sprint(e))
+			}
+		default:
+			w.Header().Set(name, fmt.Sprint(v))
+		}
+	}
+	if len(s.Response.JSONBody) > 0 && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.WriteHeader(cmp.Or(s.Response.Status, http.StatusOK))
+	w.Write(s.body)
+}
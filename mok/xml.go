@@ -0,0 +1,244 @@
+package mok
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// routes can serve a json fixture as xml and an xml fixture as json with
+// format, for legacy APIs. the mapping is the usual one:
+//
+//	{"user": {"@id": "1", "name": "rob", "tags": ["a", "b"]}}
+//	<user id="1"><name>rob</name><tags>a</tags><tags>b</tags></user>
+//
+// keys starting with @ are attributes, #text is the text of an element with
+// attributes or children, repeated elements are arrays. a document with more
+// than one top-level key is wrapped in <response>, a top-level array in
+// <response> with <item> elements.
+
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+)
+
+// convertFormat converts content, named name, to format when it is not in
+// that format already. it returns the converted content and the name it is
+// served as.
+func convertFormat(format, name string, content []byte) ([]byte, string, error) {
+	isXML := strings.EqualFold(strings.TrimPrefix(path.Ext(name), "."), "xml")
+	switch {
+	case format == formatXML && !isXML:
+		out, err := jsonToXML(content)
+		return out, strings.TrimSuffix(name, path.Ext(name)) + ".xml", err
+	case format == formatJSON && isXML:
+		out, err := xmlToJSON(content)
+		return out, strings.TrimSuffix(name, path.Ext(name)) + ".json", err
+	}
+	return content, name, nil
+}
+
+func validFormat(format string) error {
+	switch format {
+	case "", formatJSON, formatXML:
+		return nil
+	}
+	return fmt.Errorf("invalid format %q, expected json or xml", format)
+}
+
+func jsonToXML(content []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("converting to xml: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+
+	root, isObject := v.(map[string]any)
+	if isObject && len(root) == 1 {
+		for name, child := range root {
+			if !strings.HasPrefix(name, "@") && name != "#text" {
+				if err := encodeXML(enc, name, child); err != nil {
+					return nil, err
+				}
+				return finishXML(enc, &buf)
+			}
+		}
+	}
+	if err := encodeXML(enc, "response", v); err != nil {
+		return nil, err
+	}
+	return finishXML(enc, &buf)
+}
+
+func finishXML(enc *xml.Encoder, buf *bytes.Buffer) ([]byte, error) {
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+var xmlNameRe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// xmlName makes a json key a valid element name.
+func xmlName(key string) string {
+	name := xmlNameRe.ReplaceAllString(key, "_")
+	if name == "" || !(name[0] == '_' || (name[0]|0x20 >= 'a' && name[0]|0x20 <= 'z')) {
+		name = "_" + name
+	}
+	return name
+}
+
+func encodeXML(enc *xml.Encoder, name string, v any) error {
+	switch v := v.(type) {
+	case []any:
+		// a top-level array has nothing to repeat, its items are wrapped
+		if name == "response" {
+			start := xml.StartElement{Name: xml.Name{Local: name}}
+			if err := enc.EncodeToken(start); err != nil {
+				return err
+			}
+			for _, item := range v {
+				if err := encodeXML(enc, "item", item); err != nil {
+					return err
+				}
+			}
+			return enc.EncodeToken(start.End())
+		}
+		for _, item := range v {
+			if err := encodeXML(enc, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+		keys := slices.Sorted(func(yield func(string) bool) {
+			for k := range v {
+				if !yield(k) {
+					return
+				}
+			}
+		})
+		for _, k := range keys {
+			if attr, ok := strings.CutPrefix(k, "@"); ok {
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: xmlName(attr)}, Value: xmlText(v[k])})
+			}
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			switch {
+			case strings.HasPrefix(k, "@"):
+			case k == "#text":
+				if err := enc.EncodeToken(xml.CharData(xmlText(v[k]))); err != nil {
+					return err
+				}
+			default:
+				if err := encodeXML(enc, k, v[k]); err != nil {
+					return err
+				}
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	}
+	return enc.EncodeElement(xmlText(v), xml.StartElement{Name: xml.Name{Local: xmlName(name)}})
+}
+
+// xmlText formats a json scalar as text.
+func xmlText(v any) string {
+	if v == nil {
+		return ""
+	}
+	return jsonString(v)
+}
+
+// xmlNode is an element being decoded, children keep the document order.
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     strings.Builder
+}
+
+func xmlToJSON(content []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("converting to json: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: tok.Name.Local, attrs: tok.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(tok)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("converting to json: no root element")
+	}
+	return json.MarshalIndent(map[string]any{root.name: root.value()}, "", "  ")
+}
+
+// value is the json value of the element, see the mapping above.
+func (n *xmlNode) value() any {
+	text := strings.TrimSpace(n.text.String())
+	if len(n.attrs) == 0 && len(n.children) == 0 {
+		return text
+	}
+
+	v := map[string]any{}
+	for _, a := range n.attrs {
+		v["@"+a.Name.Local] = a.Value
+	}
+	for _, c := range n.children {
+		switch existing := v[c.name].(type) {
+		case nil:
+			v[c.name] = c.value()
+		case []any:
+			v[c.name] = append(existing, c.value())
+		default:
+			v[c.name] = []any{existing, c.value()}
+		}
+	}
+	if text != "" {
+		v["#text"] = text
+	}
+	return v
+}